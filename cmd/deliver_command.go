@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rodcorsi/mattermail/mmail"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+type deliverCommand struct {
+	configFile string
+	profile    string
+	input      io.Reader
+	help       bool
+}
+
+func (dc *deliverCommand) execute() error {
+	if dc.help {
+		return nil
+	}
+
+	config, err := model.NewConfigFromFile(dc.configFile)
+	if err != nil {
+		return &exitError{exConfig, fmt.Errorf("Error on read '%v' file, make sure if this file is has a valid configuration.\nerr:%v", dc.configFile, err.Error())}
+	}
+
+	if err := mmail.Deliver(config, dc.profile, dc.input); err != nil {
+		return &exitError{deliverExitCode(err), err}
+	}
+
+	return nil
+}
+
+// deliverExitCode returns the exit code used by the MTA to bounce or try again later
+func deliverExitCode(err error) int {
+	de, ok := err.(*mmail.DeliverError)
+	if !ok {
+		return exSoftware
+	}
+
+	switch de.Kind {
+	case mmail.DeliverConfigError:
+		return exConfig
+	case mmail.DeliverProfileError:
+		return exNoUser
+	case mmail.DeliverMessageError:
+		return exDataErr
+	case mmail.DeliverPostError:
+		return exTempFail
+	}
+	return exSoftware
+}
+
+func (dc *deliverCommand) parse(arguments []string) error {
+	// the exit code of invalid flags is exUsage
+	flags := flag.NewFlagSet("deliver", flag.ContinueOnError)
+	flags.Usage = deliverUsage
+
+	flags.StringVar(&dc.configFile, "config", "./config.json", "Sets the file location for config.json")
+	flags.StringVar(&dc.configFile, "c", "./config.json", "Sets the file location for config.json")
+	flags.StringVar(&dc.profile, "profile", "", "Sets the profile used to post the message")
+	flags.StringVar(&dc.profile, "p", "", "Sets the profile used to post the message")
+
+	if err := flags.Parse(arguments); err == flag.ErrHelp {
+		dc.help = true
+		return nil
+	} else if err != nil {
+		return &exitError{exUsage, err}
+	}
+
+	if dc.profile == "" {
+		return &exitError{exUsage, fmt.Errorf("Profile is empty, set the profile using -p ProfileName")}
+	}
+
+	dc.input = os.Stdin
+	return nil
+}
+
+func deliverUsage() {
+	fmt.Printf(`Read one email from standard input and post it using the profile,
+used by procmail, sieve or .forward to deliver the email
+
+Usage:
+	mattermail deliver [options] < message.eml
+
+Options:
+    -c, --config   Sets the file location for config.json
+                   Default: ./config.json
+    -p, --profile  Sets the profile used to post the message
+    -h, --help     Show this help
+
+Exit codes:
+    0   Email posted
+    64  Invalid command line
+    65  Email could not be read or posted with the profile, ex: no channel to post
+    67  Profile not found or disabled
+    75  Email could not be posted, the MTA needs to try again later
+    78  Invalid config.json
+`)
+}
@@ -3,6 +3,34 @@ package cmd
 // Version show the current version, changed during the make build
 var Version = "4.0-dev"
 
+// exit codes defined in sysexits.h
+const (
+	exUsage    = 64
+	exDataErr  = 65
+	exNoUser   = 67
+	exSoftware = 70
+	exTempFail = 75
+	exConfig   = 78
+)
+
+// exitError error with the exit code of process
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// ExitCode returns the exit code of process for the error returned by Execute
+func ExitCode(err error) int {
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	return 1
+}
+
 type command interface {
 	execute() error
 	parse(arguments []string) error
@@ -34,6 +62,9 @@ func parseCommand(args []string) (cmd command, err error) {
 		case "migrate":
 			cmd = &migrateCommand{}
 			err = cmd.parse(args[2:])
+		case "deliver":
+			cmd = &deliverCommand{}
+			err = cmd.parse(args[2:])
 		case "-h", "--help":
 			cmd = &stringCommand{usage}
 		case "-v", "--version":
@@ -55,6 +86,7 @@ Version: ` + Version + `
 Usage:
 	mattermail server  Starts Mattermail server
 	mattermail migrate Migrates config.json to new version
+	mattermail deliver Posts one email read from standard input
 
 For more details execute:
 
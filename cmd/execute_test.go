@@ -1,6 +1,12 @@
 package cmd
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rodcorsi/mattermail/mmail"
+)
 
 func TestParseCommand(t *testing.T) {
 	assertServer := func(n int, args []string) {
@@ -47,4 +53,50 @@ func TestParseCommand(t *testing.T) {
 	assertString(7, []string{"mattermail", "-h"})
 	assertString(8, []string{"mattermail", "--version"})
 	assertString(9, []string{"mattermail", "-v"})
+
+	assertDeliver := func(n int, args []string, code int) {
+		cmd, err := parseCommand(args)
+		if _, ok := cmd.(*deliverCommand); !ok {
+			t.Fatalf("Test %v Expected deliverCommand result:%T args:%v", n, cmd, args)
+		}
+
+		if (code == 0 && err != nil) || (code != 0 && ExitCode(err) != code) {
+			t.Fatalf("Test %v Expected exit code %v args:%v error:%v", n, code, args, err)
+		}
+	}
+
+	assertDeliver(10, []string{"mattermail", "deliver", "-p", "Orders"}, 0)
+	assertDeliver(11, []string{"mattermail", "deliver", "-c", "./config.json", "--profile", "Orders"}, 0)
+	assertDeliver(12, []string{"mattermail", "deliver"}, exUsage)
+	assertDeliver(13, []string{"mattermail", "deliver", "-p", "Orders", "--unknown"}, exUsage)
+	assertDeliver(14, []string{"mattermail", "deliver", "-h"}, 0)
+}
+
+func TestDeliverCommand(t *testing.T) {
+	cmd := &deliverCommand{
+		configFile: "./notfound.json",
+		profile:    "Orders",
+		input:      strings.NewReader(""),
+	}
+
+	if err := cmd.execute(); ExitCode(err) != exConfig {
+		t.Fatal("Expected exit code", exConfig, "error:", err)
+	}
+
+	codes := map[mmail.DeliverErrorKind]int{
+		mmail.DeliverConfigError:  exConfig,
+		mmail.DeliverProfileError: exNoUser,
+		mmail.DeliverMessageError: exDataErr,
+		mmail.DeliverPostError:    exTempFail,
+	}
+
+	for kind, code := range codes {
+		if c := deliverExitCode(&mmail.DeliverError{Kind: kind, Err: errors.New("error")}); c != code {
+			t.Fatalf("Expected exit code %v for kind %v result:%v", code, kind, c)
+		}
+	}
+
+	if c := deliverExitCode(errors.New("error")); c != exSoftware {
+		t.Fatal("Expected exit code", exSoftware, "result:", c)
+	}
 }
@@ -10,6 +10,6 @@ import (
 func main() {
 	if err := cmd.Execute(os.Args); err != nil {
 		fmt.Println(err.Error())
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -1,6 +1,7 @@
 package mmail
 
 import (
+	"net"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -15,6 +16,17 @@ func Start(config *model.Config) error {
 		return errors.Wrap(err, "Config is invalid")
 	}
 
+	// receiver is shared between profiles, it listens before the profiles start to not reject emails
+	var receiver *Receiver
+	var listener net.Listener
+	if config.Receiver != nil {
+		var err error
+		if listener, err = net.Listen("tcp", config.Receiver.Address); err != nil {
+			return errors.Wrapf(err, "Receiver could not listen on %v", config.Receiver.Address)
+		}
+		receiver = NewReceiver(config.Receiver, NewLog("receiver", *config.Debug), *config.Debug)
+	}
+
 	hasconfig := false
 
 	for _, profile := range config.Profiles {
@@ -23,28 +35,96 @@ func Start(config *model.Config) error {
 		}
 		hasconfig = true
 
-		wg.Add(1)
-		debug := *config.Debug
-		p := profile
-		go func() {
-			createMatterMail(p, config.Directory, debug).Listen()
-			wg.Done()
-		}()
+		// threads are shared between folders of the profile
+		threads := NewThreadCacheFile(config.Directory, cacheAccount(profile.Email))
+
+		// access token is shared between folders of the profile
+		var tokens OAuth2TokenSource
+		if profile.Email.OAuth2 != nil {
+			tokens = NewOAuth2TokenFile(profile.Email.OAuth2, config.Directory, profile.Email.Username)
+		}
+
+		// each folder has its own connection and idle/poll cycle
+		for _, folder := range profile.Email.Folders {
+			wg.Add(1)
+			mm := createMatterMail(profile, folder.Name, config.Directory, threads, tokens, receiver, *config.Debug)
+			go func() {
+				mm.Listen()
+				wg.Done()
+			}()
+		}
+
+		// send replies posted in Mattermost threads as email
+		if profile.SMTP != nil {
+			wg.Add(1)
+			debug := *config.Debug
+			p := profile
+			go func() {
+				createReplyBridge(p, threads, debug).Listen()
+				wg.Done()
+			}()
+		}
 	}
 
 	if !hasconfig {
 		return errors.New(`There is no enabled profile. Check "Disabled" field in config.json`)
 	}
 
+	if receiver != nil {
+		wg.Add(1)
+		go func() {
+			if err := receiver.Serve(listener); err != nil {
+				receiver.log.Error("Receiver stopped err:", err.Error())
+			}
+			wg.Done()
+		}()
+	}
+
 	wg.Wait()
 
 	return nil
 }
 
-func createMatterMail(profile *model.Profile, directory string, debug bool) *MatterMail {
-	logger := NewLog(profile.Name, debug)
-	cache := NewUIDCacheFile(directory, profile.Email.Username, MailBox)
-	mailProvider := NewMailProviderImap(profile.Email, logger, cache, debug)
+func createMatterMail(profile *model.Profile, folder, directory string, threads ThreadCache, tokens OAuth2TokenSource, receiver *Receiver, debug bool) *MatterMail {
+	prefix := profile.Name
+	if folder != MailBox {
+		prefix += "/" + folder
+	}
+
+	logger := NewLog(prefix, debug)
+	var mailProvider MailProvider
+	if profile.Email.IsReceiver() {
+		provider := NewMailProviderReceiver(logger)
+		receiver.Register(profile.Email.Recipients, provider)
+		mailProvider = provider
+	} else if profile.Email.Pop3Server != "" {
+		cache := NewUIDLCacheFile(directory, profile.Email.Username)
+		mailProvider = NewMailProviderPop3(profile.Email, logger, cache, debug)
+	} else {
+		cache := NewUIDCacheFile(directory, profile.Email.Username, folder)
+		mailProvider = NewMailProviderImap(profile.Email, folder, logger, cache, tokens, debug)
+	}
+
+	return newProfileMatterMail(profile, folder, logger, mailProvider, threads)
+}
+
+// newProfileMatterMail creates the MatterMail posting in the Mattermost of the profile
+func newProfileMatterMail(profile *model.Profile, folder string, logger Logger, mailProvider MailProvider, threads ThreadCache) *MatterMail {
+	mattermost := NewMattermostProvider(profile.Mattermost, logger)
+	return NewMatterMail(profile, folder, logger, mailProvider, mattermost, threads)
+}
+
+// cacheAccount returns the account used to name the cache files of the profile
+func cacheAccount(email *model.Email) string {
+	if email.Username == "" && email.IsReceiver() {
+		return email.Recipients[0]
+	}
+	return email.Username
+}
+
+func createReplyBridge(profile *model.Profile, threads ThreadCache, debug bool) *ReplyBridge {
+	logger := NewLog(profile.Name+"/replies", debug)
 	mattermost := NewMattermostProvider(profile.Mattermost, logger)
-	return NewMatterMail(profile, logger, mailProvider, mattermost)
+	sender := NewMailSenderSMTP(profile.SMTP, logger)
+	return NewReplyBridge(profile, logger, mattermost, sender, threads)
 }
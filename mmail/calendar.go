@@ -0,0 +1,490 @@
+package mmail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// calendarProperty content line of iCalendar ex: DTSTART;TZID=America/Sao_Paulo:20170605T140000
+type calendarProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// calendarComponent component of iCalendar ex: VEVENT, the properties with the same name are
+// kept in order
+type calendarComponent struct {
+	Name       string
+	Properties map[string][]*calendarProperty
+	Components []*calendarComponent
+}
+
+// get returns the first property with name or nil
+func (c *calendarComponent) get(name string) *calendarProperty {
+	if p := c.Properties[name]; len(p) > 0 {
+		return p[0]
+	}
+	return nil
+}
+
+// value returns the value of the first property with name
+func (c *calendarComponent) value(name string) string {
+	if p := c.get(name); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// find returns the first sub component with name or nil
+func (c *calendarComponent) find(name string) *calendarComponent {
+	for _, sub := range c.Components {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// isCalendarPart returns true for text/calendar parts and .ics attachments
+func isCalendarPart(p *enmime.Part) bool {
+	return p.ContentType == "text/calendar" || p.ContentType == "application/ics" ||
+		strings.HasSuffix(strings.ToLower(p.FileName), ".ics")
+}
+
+// readCalendar returns the event of the first calendar part of email or nil, the calendar of
+// an email without other parts is moved by enmime to attachments or inlines
+func readCalendar(env *enmime.Envelope) (*model.MailCalendar, error) {
+	var parts []*enmime.Part
+	if env.Root != nil {
+		parts = env.Root.DepthMatchAll(isCalendarPart)
+	}
+	parts = append(parts, env.Attachments...)
+	parts = append(parts, env.Inlines...)
+
+	for _, part := range parts {
+		if !isCalendarPart(part) || len(part.Content) == 0 {
+			continue
+		}
+
+		root, err := parseCalendar(string(part.Content))
+		if err != nil {
+			return nil, err
+		}
+		return calendarEvent(root)
+	}
+	return nil, nil
+}
+
+// parseCalendar parses the components and properties of an iCalendar (RFC 5545)
+func parseCalendar(s string) (*calendarComponent, error) {
+	// unfold the long lines
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\n ", "", -1)
+	s = strings.Replace(s, "\n\t", "", -1)
+
+	var stack []*calendarComponent
+	var root *calendarComponent
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		p, err := parseCalendarProperty(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.Name {
+		case "BEGIN":
+			c := &calendarComponent{Name: strings.ToUpper(p.Value), Properties: make(map[string][]*calendarProperty)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			} else if root == nil {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, errors.Errorf("unexpected END:%v", p.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, errors.Errorf("property %v out of component", p.Name)
+			}
+			c := stack[len(stack)-1]
+			c.Properties[p.Name] = append(c.Properties[p.Name], p)
+		}
+	}
+
+	if root == nil || root.Name != "VCALENDAR" {
+		return nil, errors.New("VCALENDAR not found")
+	}
+	return root, nil
+}
+
+// parseCalendarProperty parses a content line, parameter values can be quoted
+func parseCalendarProperty(line string) (*calendarProperty, error) {
+	p := &calendarProperty{Params: make(map[string]string)}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, errors.Errorf("invalid calendar line %v", line)
+	}
+	p.Name = strings.ToUpper(line[:i])
+
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			return nil, errors.Errorf("invalid parameter of %v", p.Name)
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.Index(line[1:], `"`)
+			if end < 0 {
+				return nil, errors.Errorf("invalid parameter %v of %v", name, p.Name)
+			}
+			value = line[1 : end+1]
+			line = line[end+2:]
+			i = 0
+		} else {
+			i = strings.IndexAny(line, ";:")
+			if i < 0 {
+				return nil, errors.Errorf("invalid parameter %v of %v", name, p.Name)
+			}
+			value = line[:i]
+			line = line[i:]
+			i = 0
+		}
+		p.Params[name] = value
+
+		if line == "" {
+			return nil, errors.Errorf("property %v without value", p.Name)
+		}
+	}
+
+	p.Value = line[i+1:]
+	return p, nil
+}
+
+var calendarTextReplacer = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+// calendarText unescapes a text value
+func calendarText(s string) string {
+	return strings.TrimSpace(calendarTextReplacer.Replace(s))
+}
+
+// calendarAddress returns the name of CN parameter and the address of a mailto: value
+func calendarAddress(p *calendarProperty) model.MailAddress {
+	address := p.Value
+	if strings.HasPrefix(strings.ToLower(address), "mailto:") {
+		address = address[len("mailto:"):]
+	}
+	return model.MailAddress{Name: calendarText(p.Params["CN"]), Address: address}
+}
+
+// calendarEvent returns the first event of calendar
+func calendarEvent(root *calendarComponent) (*model.MailCalendar, error) {
+	event := root.find("VEVENT")
+	if event == nil {
+		return nil, errors.New("VEVENT not found")
+	}
+
+	c := &model.MailCalendar{
+		Method:      strings.ToUpper(root.value("METHOD")),
+		Summary:     calendarText(event.value("SUMMARY")),
+		Description: calendarText(event.value("DESCRIPTION")),
+		Location:    calendarText(event.value("LOCATION")),
+	}
+
+	c.Cancelled = c.Method == model.CalendarMethodCancel || strings.ToUpper(event.value("STATUS")) == "CANCELLED"
+
+	if p := event.get("ORGANIZER"); p != nil {
+		c.Organizer = calendarAddress(p)
+	}
+
+	for _, p := range event.Properties["ATTENDEE"] {
+		c.Attendees = append(c.Attendees, model.MailAttendee{
+			MailAddress: calendarAddress(p),
+			Status:      strings.ToUpper(p.Params["PARTSTAT"]),
+		})
+	}
+
+	if p := event.get("DTSTART"); p != nil {
+		start, allDay, err := calendarTime(p, root)
+		if err != nil {
+			return nil, errors.Wrap(err, "DTSTART")
+		}
+		c.Start = start
+		c.AllDay = allDay
+	}
+
+	if p := event.get("DTEND"); p != nil {
+		end, allDay, err := calendarTime(p, root)
+		if err != nil {
+			return nil, errors.Wrap(err, "DTEND")
+		}
+
+		// end date of all day events is exclusive
+		if allDay {
+			end = end.AddDate(0, 0, -1)
+		}
+		c.End = end
+	} else if d := event.value("DURATION"); d != "" && !c.Start.IsZero() {
+		if duration, err := calendarDuration(d); err == nil {
+			c.End = c.Start.Add(duration)
+			if c.AllDay {
+				c.End = c.End.AddDate(0, 0, -1)
+			}
+		}
+	}
+
+	if rrule := event.value("RRULE"); rrule != "" {
+		c.Recurrence = recurrenceSummary(rrule, c.Start.Location())
+	}
+
+	return c, nil
+}
+
+// calendarTime parses the date or date-time of property, the timezone of TZID parameter is read
+// from tz database or from VTIMEZONE of calendar, times ending with Z are UTC
+func calendarTime(p *calendarProperty, root *calendarComponent) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(p.Value)
+
+	if strings.ToUpper(p.Params["VALUE"]) == "DATE" || len(value) == 8 {
+		t, err = time.Parse("20060102", value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	loc := time.UTC
+	if tzid := p.Params["TZID"]; tzid != "" {
+		loc = calendarLocation(tzid, value, root)
+	}
+
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// calendarLocation returns the location of tzid, timezones not found in tz database ex:
+// "Pacific Standard Time" use the offset of VTIMEZONE of the month of date
+func calendarLocation(tzid, date string, root *calendarComponent) *time.Location {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+
+	for _, tz := range root.Components {
+		if tz.Name != "VTIMEZONE" || tz.value("TZID") != tzid {
+			continue
+		}
+
+		standard := tz.find("STANDARD")
+		daylight := tz.find("DAYLIGHT")
+		rule := standard
+		if standard == nil || daylight != nil && isDaylight(date, standard, daylight) {
+			rule = daylight
+		}
+
+		if rule == nil {
+			break
+		}
+
+		if offset, err := calendarOffset(rule.value("TZOFFSETTO")); err == nil {
+			return time.FixedZone(tzid, offset)
+		}
+	}
+	return time.FixedZone(tzid, 0)
+}
+
+// isDaylight returns true if the month of date is in daylight saving time, the months where
+// daylight and standard time start are taken from BYMONTH of RRULE or from DTSTART
+func isDaylight(date string, standard, daylight *calendarComponent) bool {
+	if len(date) < 6 {
+		return false
+	}
+
+	month, err := strconv.Atoi(date[4:6])
+	if err != nil {
+		return false
+	}
+
+	startMonth := func(c *calendarComponent) int {
+		for _, part := range strings.Split(c.value("RRULE"), ";") {
+			if strings.HasPrefix(part, "BYMONTH=") {
+				if m, err := strconv.Atoi(part[len("BYMONTH="):]); err == nil {
+					return m
+				}
+			}
+		}
+		if d := c.value("DTSTART"); len(d) >= 6 {
+			if m, err := strconv.Atoi(d[4:6]); err == nil {
+				return m
+			}
+		}
+		return 0
+	}
+
+	dst, std := startMonth(daylight), startMonth(standard)
+	if dst < std {
+		// northern hemisphere
+		return month >= dst && month < std
+	}
+	return month >= dst || month < std
+}
+
+// calendarOffset parses the UTC offset in seconds ex: -0300
+func calendarOffset(s string) (int, error) {
+	if len(s) < 5 || (s[0] != '+' && s[0] != '-') {
+		return 0, errors.Errorf("invalid offset %v", s)
+	}
+
+	hours, err := strconv.Atoi(s[1:3])
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.Atoi(s[3:5])
+	if err != nil {
+		return 0, err
+	}
+
+	offset := hours*3600 + minutes*60
+	if s[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// calendarDuration parses a duration ex: PT1H30M, P1D
+func calendarDuration(s string) (time.Duration, error) {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	if !strings.HasPrefix(s, "P") {
+		return 0, errors.Errorf("invalid duration %v", s)
+	}
+
+	var d time.Duration
+	number := ""
+	for _, r := range s[1:] {
+		if r >= '0' && r <= '9' {
+			number += string(r)
+			continue
+		}
+		if r == 'T' {
+			continue
+		}
+
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, errors.Errorf("invalid duration %v", s)
+		}
+		number = ""
+
+		switch r {
+		case 'W':
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case 'D':
+			d += time.Duration(n) * 24 * time.Hour
+		case 'H':
+			d += time.Duration(n) * time.Hour
+		case 'M':
+			d += time.Duration(n) * time.Minute
+		case 'S':
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, errors.Errorf("invalid duration %v", s)
+		}
+	}
+
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+var (
+	recurrenceWeekdays = map[string]string{
+		"MO": "Monday", "TU": "Tuesday", "WE": "Wednesday", "TH": "Thursday",
+		"FR": "Friday", "SA": "Saturday", "SU": "Sunday",
+	}
+
+	recurrenceUnits = map[string][2]string{
+		"DAILY":   {"Daily", "days"},
+		"WEEKLY":  {"Weekly", "weeks"},
+		"MONTHLY": {"Monthly", "months"},
+		"YEARLY":  {"Yearly", "years"},
+	}
+
+	recurrenceOrdinals = map[string]string{"1": "first", "2": "second", "3": "third", "4": "fourth", "-1": "last"}
+)
+
+// recurrenceSummary returns a summary of RRULE ex: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10"
+// => "Every 2 weeks on Monday, Wednesday, 10 times"
+func recurrenceSummary(rrule string, loc *time.Location) string {
+	rule := make(map[string]string)
+	for _, part := range strings.Split(rrule, ";") {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			rule[strings.ToUpper(kv[0])] = strings.ToUpper(kv[1])
+		}
+	}
+
+	unit, ok := recurrenceUnits[rule["FREQ"]]
+	if !ok {
+		return rrule
+	}
+
+	summary := unit[0]
+	if interval, err := strconv.Atoi(rule["INTERVAL"]); err == nil && interval > 1 {
+		summary = fmt.Sprintf("Every %v %v", interval, unit[1])
+	}
+
+	if byday := rule["BYDAY"]; byday != "" {
+		var days []string
+		for _, d := range strings.Split(byday, ",") {
+			if len(d) < 2 {
+				continue
+			}
+			name, ok := recurrenceWeekdays[d[len(d)-2:]]
+			if !ok {
+				continue
+			}
+			if ordinal, ok := recurrenceOrdinals[d[:len(d)-2]]; ok {
+				name = "the " + ordinal + " " + name
+			}
+			days = append(days, name)
+		}
+		if len(days) > 0 {
+			summary += " on " + strings.Join(days, ", ")
+		}
+	} else if bymonthday := rule["BYMONTHDAY"]; bymonthday != "" {
+		summary += " on day " + bymonthday
+	}
+
+	if count := rule["COUNT"]; count != "" {
+		summary += ", " + count + " times"
+	} else if until := rule["UNTIL"]; until != "" {
+		if t, allDay, err := calendarTime(&calendarProperty{Value: until}, &calendarComponent{}); err == nil {
+			if !allDay {
+				t = t.In(loc)
+			}
+			summary += " until " + t.Format("Jan 2, 2006")
+		}
+	}
+
+	return summary
+}
@@ -0,0 +1,186 @@
+package mmail
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+func readEml(t *testing.T, name string) *MailMessage {
+	f, err := os.Open(findDir("emltest") + name)
+	if err != nil {
+		t.Fatal("Error on open eml:", err)
+	}
+	defer f.Close()
+
+	mm, err := ReadMailMessage(f)
+	if err != nil {
+		t.Fatal("Failed to parsing msg:", err.Error())
+	}
+	return mm
+}
+
+func TestReadMailMessageCalendar(t *testing.T) {
+	// outlook invitation with windows timezone
+	c := readEml(t, "invite_outlook.eml").Calendar
+	if c == nil {
+		t.Fatal("Expected calendar of invite_outlook.eml")
+	}
+
+	if c.Method != model.CalendarMethodRequest || c.Summary != "Weekly status" || c.Location != "Room 3" || c.Cancelled {
+		t.Fatalf("Expected request of Weekly status in Room 3 result:%+v", c)
+	}
+
+	if c.Description != "Status of the week, bring your numbers." {
+		t.Fatal("Expected description unescaped result:", c.Description)
+	}
+
+	if c.Organizer != (model.MailAddress{Name: "John Doe", Address: "jdoe@example.com"}) {
+		t.Fatal("Expected organizer John Doe result:", c.Organizer)
+	}
+
+	attendees := []model.MailAttendee{
+		{MailAddress: model.MailAddress{Name: "Mary Smith", Address: "mary@example.net"}, Status: model.AttendeeNeedsAction},
+		{MailAddress: model.MailAddress{Name: "Rodrigo", Address: "test@gmail.com"}, Status: model.AttendeeNeedsAction},
+	}
+	if !reflect.DeepEqual(c.Attendees, attendees) {
+		t.Fatalf("Expected attendees %+v result:%+v", attendees, c.Attendees)
+	}
+
+	// June is standard time -0300 in VTIMEZONE
+	if want := time.Date(2017, 6, 6, 17, 0, 0, 0, time.UTC); !c.Start.Equal(want) || c.End.Sub(c.Start) != time.Hour {
+		t.Fatal("Expected start at", want, "result:", c.Start, c.End)
+	}
+
+	if c.Recurrence != "Weekly on Tuesday until Dec 26, 2017" {
+		t.Fatal("Expected weekly recurrence result:", c.Recurrence)
+	}
+
+	// google cancellation in UTC
+	c = readEml(t, "invite_cancel.eml").Calendar
+	if c == nil || c.Method != model.CalendarMethodCancel || !c.Cancelled || c.Summary != "Deploy review" {
+		t.Fatalf("Expected cancelled Deploy review result:%+v", c)
+	}
+
+	if want := time.Date(2017, 6, 8, 13, 0, 0, 0, time.UTC); !c.Start.Equal(want) || c.Location != "" {
+		t.Fatal("Expected start at", want, "result:", c.Start)
+	}
+
+	if c.Attendees[0].Name != "John Doe" || c.Attendees[0].Status != model.AttendeeAccepted {
+		t.Fatal("Expected folded attendee John Doe result:", c.Attendees)
+	}
+
+	// reply of all day event in root part
+	c = readEml(t, "invite_reply.eml").Calendar
+	if c == nil || c.Method != model.CalendarMethodReply || !c.AllDay || !c.Start.Equal(c.End) {
+		t.Fatalf("Expected reply of all day event result:%+v", c)
+	}
+
+	if c.Title() != ":white_check_mark: **Accepted:** Accepted: Weekly status by Mary Smith <mary@example.net>" {
+		t.Fatal("Expected accepted title result:", c.Title())
+	}
+
+	// email without calendar
+	if c = readEml(t, "gmail.eml").Calendar; c != nil {
+		t.Fatalf("Expected no calendar result:%+v", c)
+	}
+}
+
+func TestCreateMattermostPostCalendar(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#channel1"}
+
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	msg := readEml(t, "invite_cancel.eml")
+	mP, err := createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	want := ":incoming_envelope: _From: **Rodrigo <test@gmail.com>**_\n>_Canceled event: Deploy review @ Thu Jun 8, 2017 10am - 11am (BRT)_\n\n" +
+		":x: **Cancelled:** ~~Deploy review~~\n" +
+		"**When:** Thu, Jun 8 2017 13:00 - 14:00 (UTC)\n" +
+		"**Organizer:** Rodrigo <test@gmail.com>\n" +
+		"**Attendees:** John Doe <jdoe@example.com> (accepted)\n\n" +
+		"This event has been canceled.\n"
+	if mP.messages["#channel1"] != want {
+		t.Fatalf("expected '%v' result:'%v'", want, mP.messages["#channel1"])
+	}
+}
+
+func Test_parseCalendarProperty(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want *calendarProperty
+	}{
+		{"value", "SUMMARY:Meeting: today", &calendarProperty{Name: "SUMMARY", Params: map[string]string{}, Value: "Meeting: today"}},
+		{"params", "DTSTART;TZID=America/Sao_Paulo:20170605T140000", &calendarProperty{Name: "DTSTART", Params: map[string]string{"TZID": "America/Sao_Paulo"}, Value: "20170605T140000"}},
+		{"quoted", `attendee;cn="Doe; John:";partstat=ACCEPTED:mailto:j@example.com`, &calendarProperty{Name: "ATTENDEE", Params: map[string]string{"CN": "Doe; John:", "PARTSTAT": "ACCEPTED"}, Value: "mailto:j@example.com"}},
+		{"invalid", "SUMMARY", nil},
+		{"invalid param", "DTSTART;TZID", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCalendarProperty(tt.line)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatal("Expected error result:", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCalendarProperty() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_recurrenceSummary(t *testing.T) {
+	tests := []struct {
+		rrule string
+		want  string
+	}{
+		{"FREQ=DAILY", "Daily"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10", "Every 2 weeks on Monday, Wednesday, 10 times"},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "Monthly on the last Friday"},
+		{"FREQ=MONTHLY;BYMONTHDAY=15;UNTIL=20171231", "Monthly on day 15 until Dec 31, 2017"},
+		{"FREQ=SECONDLY", "FREQ=SECONDLY"},
+	}
+	for _, tt := range tests {
+		if got := recurrenceSummary(tt.rrule, time.UTC); got != tt.want {
+			t.Errorf("recurrenceSummary(%v) = %v, want %v", tt.rrule, got, tt.want)
+		}
+	}
+}
+
+func Test_calendarDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		want     time.Duration
+	}{
+		{"PT1H30M", 90 * time.Minute},
+		{"P1D", 24 * time.Hour},
+		{"P1W", 7 * 24 * time.Hour},
+		{"-PT15M", -15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got, err := calendarDuration(tt.duration); err != nil || got != tt.want {
+			t.Errorf("calendarDuration(%v) = %v %v, want %v", tt.duration, got, err, tt.want)
+		}
+	}
+
+	if _, err := calendarDuration("1H"); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
@@ -0,0 +1,95 @@
+package mmail
+
+import (
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// DeliverErrorKind kind of failure of Deliver
+type DeliverErrorKind int
+
+const (
+	// DeliverConfigError config is invalid
+	DeliverConfigError DeliverErrorKind = iota
+	// DeliverProfileError profile does not exist or is disabled
+	DeliverProfileError
+	// DeliverMessageError message could not be read or posted with the profile, ex: there is no
+	// channel to post
+	DeliverMessageError
+	// DeliverPostError message could not be posted, Mattermost can be unavailable
+	DeliverPostError
+)
+
+// DeliverError error returned by Deliver with the kind of failure
+type DeliverError struct {
+	Kind DeliverErrorKind
+	Err  error
+}
+
+func (e *DeliverError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error
+func (e *DeliverError) Cause() error {
+	return e.Err
+}
+
+// Deliver reads one message and posts it using the profile, used when the message is piped by a MTA
+func Deliver(config *model.Config, profileName string, r io.Reader) error {
+	if len(config.Profiles) == 0 {
+		return &DeliverError{DeliverConfigError, errors.New("Config is invalid: Field 'Profiles' is empty set Profiles configuration")}
+	}
+
+	var profile *model.Profile
+	for _, p := range config.Profiles {
+		if strings.EqualFold(p.Name, profileName) {
+			profile = p
+			break
+		}
+	}
+
+	if profile == nil {
+		return &DeliverError{DeliverProfileError, errors.Errorf("Profile '%v' not found", profileName)}
+	}
+
+	if *profile.Disabled {
+		return &DeliverError{DeliverProfileError, errors.Errorf("Profile '%v' is disabled", profile.Name)}
+	}
+
+	// the other profiles are not used
+	if err := config.ValidateProfile(profile); err != nil {
+		return &DeliverError{DeliverConfigError, errors.Wrap(err, "Config is invalid")}
+	}
+
+	logger := NewLog(profile.Name, *config.Debug)
+	threads := NewThreadCacheFile(config.Directory, cacheAccount(profile.Email))
+
+	// the message is posted once, there is no mail provider
+	m := newProfileMatterMail(profile, MailBox, logger, nil, threads)
+
+	msg, err := ReadMailMessage(r)
+	if err != nil {
+		return &DeliverError{DeliverMessageError, errors.Wrap(err, "parse mail message")}
+	}
+
+	msg.Folder = MailBox
+
+	defer func() {
+		if err := m.mmProvider.Logout(); err != nil {
+			logger.Error("Logout error err:", err)
+		}
+	}()
+
+	if err := m.PostMailMessage(msg); err != nil {
+		if IsPermanentError(err) {
+			return &DeliverError{DeliverMessageError, errors.Wrap(err, "post mail message")}
+		}
+		return &DeliverError{DeliverPostError, errors.Wrap(err, "post mail message")}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package mmail
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+func TestDeliver(t *testing.T) {
+	mock := newMattermostServerMock()
+	defer mock.Close()
+
+	profile := model.NewProfile()
+	profile.Name = "Orders"
+	profile.Channels = []string{"#town-square"}
+	profile.Email.ImapServer = "imap.example.com:143"
+	profile.Email.Username = "deliver@example.com"
+	profile.Email.Password = "password"
+	profile.Mattermost.Server = mock.URL
+	profile.Mattermost.Team = "team1"
+	profile.Mattermost.User = "mattermail"
+	profile.Mattermost.Password = "password"
+
+	config := model.NewConfig()
+	config.Directory = os.TempDir()
+	*config.Debug = false
+	// a broken profile does not affect the other profiles
+	broken := model.NewProfile()
+	broken.Name = "Broken"
+	config.Profiles = []*model.Profile{profile, broken}
+
+	defer os.Remove(NewThreadCacheFile(config.Directory, "deliver@example.com").filename)
+
+	kind := func(err error) DeliverErrorKind {
+		if de, ok := err.(*DeliverError); ok {
+			return de.Kind
+		}
+		t.Fatalf("Expected DeliverError result:%T %v", err, err)
+		return -1
+	}
+
+	msg := "From: john@example.com\r\nSubject: Hello\r\n\r\nHello world\r\n"
+
+	if err := Deliver(config, "orders", strings.NewReader(msg)); err != nil {
+		t.Fatal("Error on deliver:", err.Error())
+	}
+
+	if err := Deliver(config, "Billing", strings.NewReader(msg)); kind(err) != DeliverProfileError {
+		t.Fatal("Expected DeliverProfileError err:", err)
+	}
+
+	if err := Deliver(config, "Broken", strings.NewReader(msg)); kind(err) != DeliverConfigError {
+		t.Fatal("Expected DeliverConfigError of broken profile err:", err)
+	}
+
+	if err := Deliver(config, "Orders", iotest.TimeoutReader(strings.NewReader(msg))); kind(err) != DeliverMessageError {
+		t.Fatal("Expected DeliverMessageError err:", err)
+	}
+
+	// channel does not exist, the MTA must not try again
+	profile.Channels = []string{"#unknown"}
+	if err := Deliver(config, "Orders", strings.NewReader(msg)); kind(err) != DeliverMessageError {
+		t.Fatal("Expected DeliverMessageError without channel err:", err)
+	}
+
+	profile.Channels = []string{"#town-square"}
+	mock.Close()
+
+	if err := Deliver(config, "Orders", strings.NewReader(msg)); kind(err) != DeliverPostError {
+		t.Fatal("Expected DeliverPostError err:", err)
+	}
+
+	config.Profiles = nil
+
+	if err := Deliver(config, "Orders", strings.NewReader(msg)); kind(err) != DeliverConfigError {
+		t.Fatal("Expected DeliverConfigError err:", err)
+	}
+}
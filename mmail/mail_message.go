@@ -1,13 +1,27 @@
 package mmail
 
 import (
+	"bytes"
 	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif" // decode dimension of inline images
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
 	"strings"
+	"time"
 	"unicode/utf8"
 
+	"github.com/jaytaylor/html2text"
 	"github.com/jhillyerd/enmime"
 	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
 )
 
 // Emails content type
@@ -22,14 +36,35 @@ type Attachment struct {
 	Content  []byte
 }
 
+// InlinePart part of HTML email referenced by cid: URL, usually an image
+type InlinePart struct {
+	Attachment
+	ContentID   string
+	ContentType string
+}
+
 // MailMessage mail message with fields used in mattermail
 type MailMessage struct {
-	From        string
-	Subject     string
-	EmailText   string
-	EmailBody   string
-	EmailType   int
-	Attachments []*Attachment
+	Folder        string
+	From          string
+	ReplyTo       string
+	Subject       string
+	FromAddress   *mail.Address
+	To            []*mail.Address
+	Cc            []*mail.Address
+	Date          time.Time
+	MessageID     string
+	InReplyTo     string
+	References    []string
+	Header        map[string][]string
+	EmailText     string
+	EmailMarkdown string // HTML part converted to markdown, empty when email has no HTML
+	HTMLOnly      bool   // email without text part, EmailText is converted from HTML
+	EmailBody     string // HTML with cid: URLs or text of email
+	Inlines       []*InlinePart
+	Calendar      *model.MailCalendar // event of calendar invitation or nil
+	EmailType     int
+	Attachments   []*Attachment
 }
 
 // ReadMailMessage convert net/mail in MailMessage
@@ -41,51 +76,291 @@ func ReadMailMessage(r io.Reader) (*MailMessage, error) {
 	}
 
 	mm.From = env.GetHeader("From")
+	mm.ReplyTo = env.GetHeader("Reply-To")
 	mm.Subject = env.GetHeader("Subject")
+
+	if from, err := env.AddressList("From"); err == nil && len(from) > 0 {
+		mm.FromAddress = from[0]
+	}
+
+	// invalid addresses are ignored, they are available in the headers
+	mm.To, _ = env.AddressList("To")
+	mm.Cc, _ = env.AddressList("Cc")
+
+	if date, err := mail.ParseDate(env.GetHeader("Date")); err == nil {
+		mm.Date = date
+	}
+
+	if ids := parseMessageIDs(env.GetHeader("Message-ID")); len(ids) > 0 {
+		mm.MessageID = ids[0]
+	}
+
+	if ids := parseMessageIDs(env.GetHeader("In-Reply-To")); len(ids) > 0 {
+		mm.InReplyTo = ids[0]
+	}
+
+	mm.References = parseMessageIDs(env.GetHeader("References"))
+	mm.Header = decodeHeaders(env.Root.Header)
 	mm.EmailText = env.Text
 
-	var emailbody string
 	if len(env.HTML) > 0 {
 		mm.EmailType = EmailTypeHTML
-		emailbody = env.HTML
-		for _, p := range env.Inlines {
-			emailbody = replaceCID(emailbody, p)
-		}
+		mm.EmailBody = env.HTML
 
-		for _, p := range env.OtherParts {
-			emailbody = replaceCID(emailbody, p)
+		// invalid HTML is posted using the text
+		mm.EmailMarkdown, _ = htmlToMarkdown(env.HTML)
+		for _, e := range env.Errors {
+			if e.Name == enmime.ErrorPlainTextFromHTML {
+				mm.HTMLOnly = true
+			}
 		}
 
+		// inline parts are embedded or uploaded on post
+		for _, parts := range [][]*enmime.Part{env.Inlines, env.OtherParts} {
+			for _, p := range parts {
+				if inline := readInlinePart(p, len(mm.Inlines)+1); inline != nil {
+					mm.Inlines = append(mm.Inlines, inline)
+				}
+			}
+		}
 	} else {
 		mm.EmailType = EmailTypeText
-		emailbody = env.Text
+		mm.EmailBody = env.Text
 	}
 
-	mm.EmailBody = emailbody
+	// invalid calendar is posted as attachment only
+	mm.Calendar, _ = readCalendar(env)
 
-	mm.Attachments = make([]*Attachment, len(env.Attachments))
+	for _, a := range env.Attachments {
+		// winmail.dat of Outlook is replaced by its attachments, invalid one is kept
+		if isTNEFPart(a) {
+			if t, err := readTNEF(a.Content); err == nil {
+				mm.Attachments = append(mm.Attachments, t.Attachments...)
+				mm.setTNEFBody(t)
+				continue
+			}
+		}
 
-	for i, a := range env.Attachments {
-		mm.Attachments[i] = &Attachment{
+		mm.Attachments = append(mm.Attachments, &Attachment{
 			Filename: removeNonUTF8(a.FileName),
 			Content:  a.Content,
-		}
+		})
 	}
 	return mm, nil
 }
 
-//Replace cid:**** by embedded base64 image
-func replaceCID(html string, part *enmime.Part) string {
+// setTNEFBody uses the body of winmail.dat when the email has no text, the HTML is used only
+// when the email has no HTML part
+func (msg *MailMessage) setTNEFBody(t *tnefMessage) {
+	if strings.TrimSpace(msg.EmailText) != "" {
+		return
+	}
+
+	msg.EmailText = t.Text
+	if msg.EmailType == EmailTypeText {
+		msg.EmailBody = t.Text
+	}
+
+	if t.HTML == "" || msg.EmailType == EmailTypeHTML {
+		return
+	}
+
+	msg.EmailType = EmailTypeHTML
+	msg.EmailBody = t.HTML
+	msg.EmailMarkdown, _ = htmlToMarkdown(t.HTML)
+
+	if strings.TrimSpace(msg.EmailText) == "" {
+		msg.EmailText, _ = html2text.FromString(t.HTML)
+		msg.HTMLOnly = true
+	}
+}
+
+// postText returns the text posted in Mattermost, the HTML converted to markdown is used when the
+// email has no text part or source is MessageSourceHTML
+func (msg *MailMessage) postText(source string) string {
+	if msg.EmailMarkdown != "" && (msg.HTMLOnly || source == model.MessageSourceHTML) {
+		return msg.EmailMarkdown
+	}
+	return msg.EmailText
+}
+
+// filterMessage returns the fields used to match the filter rules
+func (msg *MailMessage) filterMessage() *model.FilterMessage {
+	return &model.FilterMessage{
+		Folder:  msg.Folder,
+		From:    msg.From,
+		Subject: msg.Subject,
+		Header:  msg.Header,
+		Body:    msg.EmailText,
+	}
+}
+
+// templateData returns the fields used in MailTemplate to post the message in channel
+func (msg *MailMessage) templateData(message, channel string, rule *model.Rule) *model.MailTemplateData {
+	data := &model.MailTemplateData{
+		Folder:    msg.Folder,
+		From:      msg.From,
+		Subject:   msg.Subject,
+		Message:   message,
+		To:        mailAddresses(msg.To),
+		Cc:        mailAddresses(msg.Cc),
+		Date:      msg.Date,
+		MessageID: msg.MessageID,
+		Header:    model.MailHeader(msg.Header),
+		Rule:      rule,
+		Channel:   channel,
+	}
+
+	if msg.FromAddress != nil {
+		data.FromAddress = model.MailAddress{Name: msg.FromAddress.Name, Address: msg.FromAddress.Address}
+	}
+
+	if msg.ReplyTo != "" {
+		replyTo, _ := mail.ParseAddressList(msg.ReplyTo)
+		data.ReplyTo = mailAddresses(replyTo)
+	}
+
+	for _, a := range msg.Attachments {
+		data.Attachments = append(data.Attachments, model.MailAttachment{Filename: a.Filename, Size: len(a.Content)})
+	}
+
+	// copy used by Sanitize of each channel
+	if msg.Calendar != nil {
+		calendar := *msg.Calendar
+		calendar.Attendees = append([]model.MailAttendee(nil), msg.Calendar.Attendees...)
+		data.Calendar = &calendar
+	}
+
+	return data
+}
+
+// mailAddresses converts net/mail addresses in model.MailAddresses
+func mailAddresses(addresses []*mail.Address) model.MailAddresses {
+	var l model.MailAddresses
+	for _, a := range addresses {
+		l = append(l, model.MailAddress{Name: a.Name, Address: a.Address})
+	}
+	return l
+}
+
+// decodeHeaders returns all headers with RFC 2047 encoded words decoded
+func decodeHeaders(header textproto.MIMEHeader) map[string][]string {
+	dec := &mime.WordDecoder{}
+	decoded := make(map[string][]string, len(header))
+	for name, values := range header {
+		for _, v := range values {
+			if d, err := dec.DecodeHeader(v); err == nil {
+				v = d
+			}
+			decoded[name] = append(decoded[name], v)
+		}
+	}
+	return decoded
+}
+
+var messageIDRegex = regexp.MustCompile(`<[^<>\s]+>`)
+
+// parseMessageIDs extracts the ids of Message-ID, In-Reply-To and References headers ex:
+// parseMessageIDs("<a@example.com> <b@example.com>") => [a@example.com b@example.com]
+func parseMessageIDs(header string) []string {
+	var ids []string
+	for _, id := range messageIDRegex.FindAllString(header, -1) {
+		ids = append(ids, strings.Trim(id, "<>"))
+	}
+
+	// some clients do not use angle brackets
+	if len(ids) == 0 && strings.TrimSpace(header) != "" {
+		ids = strings.Fields(header)
+	}
+
+	return ids
+}
+
+// readInlinePart returns the part referenced by cid: URL or nil when the part has no Content-ID,
+// parts without filename are named inline<n>
+func readInlinePart(part *enmime.Part, n int) *InlinePart {
 	cid := strings.Replace(part.Header.Get("Content-ID"), "<", "", -1)
 	cid = strings.Replace(cid, ">", "", -1)
 
 	if len(cid) == 0 {
-		return html
+		return nil
 	}
 
+	filename := removeNonUTF8(part.FileName)
+	if filename == "" {
+		filename = fmt.Sprintf("inline%v", n)
+		if ext, _ := mime.ExtensionsByType(part.ContentType); len(ext) > 0 {
+			filename += ext[0]
+		}
+	}
+
+	return &InlinePart{
+		Attachment:  Attachment{Filename: filename, Content: part.Content},
+		ContentID:   cid,
+		ContentType: part.ContentType,
+	}
+}
+
+// htmlBody returns the HTML of email with the inline parts embedded as base64 data URIs, the
+// images of uploaded parts are replaced by their alt text or by the filename ex: [logo.png],
+// the files are posted and are not next to email.html
+func (msg *MailMessage) htmlBody(uploaded map[*InlinePart]bool) string {
+	body := msg.EmailBody
+	for _, p := range msg.Inlines {
+		if uploaded[p] {
+			body = removeCIDImages(body, p)
+		}
+		body = replaceCID(body, p)
+	}
+	return body
+}
+
+var imgAltRegex = regexp.MustCompile(`(?is)\balt\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// removeCIDImages replaces the img tags of the part by their alt text or by the filename
+func removeCIDImages(body string, part *InlinePart) string {
+	img := regexp.MustCompile(`(?is)<img\b[^>]*\bsrc\s*=\s*["']?cid:` + regexp.QuoteMeta(part.ContentID) + `(?:["'\s/][^>]*)?>`)
+
+	return img.ReplaceAllStringFunc(body, func(tag string) string {
+		if alt := imgAltRegex.FindStringSubmatch(tag); alt != nil && strings.TrimSpace(alt[1]+alt[2]) != "" {
+			return alt[1] + alt[2]
+		}
+		return "[" + html.EscapeString(part.Filename) + "]"
+	})
+}
+
+// inlineImages returns the inline images that are large enough to be uploaded
+func (msg *MailMessage) inlineImages(cfg *model.InlineImages, log Logger) []*InlinePart {
+	var images []*InlinePart
+	for _, p := range msg.Inlines {
+		if !strings.HasPrefix(p.ContentType, "image/") {
+			continue
+		}
+
+		width, height := imageDimension(p.Content)
+		if !cfg.Accept(len(p.Content), width, height) {
+			log.Debugf("Inline image '%v' with %v and %vx%v is not uploaded\n", p.Filename, model.HumanSize(len(p.Content)), width, height)
+			continue
+		}
+		images = append(images, p)
+	}
+	return images
+}
+
+// imageDimension returns width and height of gif, jpeg and png images, other formats return zero
+func imageDimension(content []byte) (int, int) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+//Replace cid:**** by embedded base64 image
+func replaceCID(html string, part *InlinePart) string {
 	b64 := "data:" + part.ContentType + ";base64," + base64.StdEncoding.EncodeToString(part.Content)
 
-	return strings.Replace(html, "cid:"+cid, b64, -1)
+	return strings.Replace(html, "cid:"+part.ContentID, b64, -1)
 }
 
 func removeNonUTF8(s string) string {
@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/rodcorsi/mattermail/model"
 )
 
 func TestReadMailMessage(t *testing.T) {
@@ -183,6 +188,217 @@ func testMailMessage(r io.Reader, expected *MailMessage) error {
 	return nil
 }
 
+func TestReadMailMessageThread(t *testing.T) {
+	email := `From: John Doe <jdoe@machine.example>
+To: Mary Smith <mary@example.net>
+Subject: Re: Saying Hello
+Date: Fri, 21 Nov 1997 09:55:06 -0600
+Message-ID: <3456@local.machine.example>
+In-Reply-To: <1234@local.machine.example>
+References: <1234@local.machine.example>
+ <2345@local.machine.example>
+
+This is a reply.
+`
+	mm, err := ReadMailMessage(bytes.NewBuffer([]byte(email)))
+	if err != nil {
+		t.Fatal("Failed to parsing msg:", err)
+	}
+
+	if mm.MessageID != "3456@local.machine.example" {
+		t.Fatalf("field MessageID expected: '3456@local.machine.example' found:'%v'", mm.MessageID)
+	}
+
+	if mm.InReplyTo != "1234@local.machine.example" {
+		t.Fatalf("field InReplyTo expected: '1234@local.machine.example' found:'%v'", mm.InReplyTo)
+	}
+
+	expected := []string{"1234@local.machine.example", "2345@local.machine.example"}
+	if !reflect.DeepEqual(mm.References, expected) {
+		t.Fatalf("field References expected: '%v' found:'%v'", expected, mm.References)
+	}
+}
+
+func TestReadMailMessageHeader(t *testing.T) {
+	email := `From: Monitor <monitor@example.com>
+To: Oncall <oncall+db@example.com>
+Cc: =?UTF-8?B?Sm/Do28=?= <joao@example.com>
+Received: from a.example.com
+Received: from b.example.com
+X-Priority: 1 (Highest)
+Subject: [PROD] Disk usage
+
+Disk usage 95%
+`
+	mm, err := ReadMailMessage(bytes.NewBufferString(email))
+	if err != nil {
+		t.Fatal("Failed to parsing msg:", err.Error())
+	}
+
+	if v := mm.Header["To"]; len(v) != 1 || v[0] != "Oncall <oncall+db@example.com>" {
+		t.Fatal("Expected header To result:", v)
+	}
+
+	if v := mm.Header["Cc"]; len(v) != 1 || v[0] != "João <joao@example.com>" {
+		t.Fatal("Expected header Cc decoded result:", v)
+	}
+
+	if v := mm.Header["Received"]; len(v) != 2 {
+		t.Fatal("Expected 2 headers Received result:", v)
+	}
+
+	rule := &model.Rule{Headers: map[string]string{"X-Priority": "/^1/", "To": "oncall+db@"}, Body: "/usage \\d+%/", Channels: []string{"#oncall"}}
+	if !rule.Match(mm.filterMessage()) {
+		t.Fatal("Expected rule match with headers and body")
+	}
+
+	data := mm.templateData("Disk usage 95%", "#oncall", rule)
+
+	if data.FromAddress.Name != "Monitor" || data.FromAddress.Address != "monitor@example.com" {
+		t.Fatal("Expected FromAddress Monitor <monitor@example.com> result:", data.FromAddress)
+	}
+
+	if data.To.String() != "Oncall <oncall+db@example.com>" {
+		t.Fatal("Expected To Oncall <oncall+db@example.com> result:", data.To)
+	}
+
+	if len(data.Cc) != 1 || data.Cc[0].Name != "João" {
+		t.Fatal("Expected Cc name decoded result:", data.Cc)
+	}
+
+	if data.Header.Get("x-priority") != "1 (Highest)" || data.Rule != rule || data.Channel != "#oncall" {
+		t.Fatalf("Expected header, rule and channel result:%+v", data)
+	}
+}
+
+func TestReadMailMessageTemplateData(t *testing.T) {
+	gmailbuf, err := os.Open(findDir("emltest") + "gmail.eml")
+	if err != nil {
+		t.Fatal("Error on open gmail.eml:", err)
+	}
+
+	mm, err := ReadMailMessage(gmailbuf)
+	if err != nil {
+		t.Fatal("Failed to parsing msg:", err.Error())
+	}
+
+	data := mm.templateData("", "#town-square", nil)
+
+	if want := time.Date(2017, 6, 5, 14, 8, 54, 0, time.UTC); !data.Date.Equal(want) {
+		t.Fatal("Expected Date:", want, "result:", data.Date)
+	}
+
+	if data.MessageID != "CAOHBT=axR_YGX_L3X_ZRPOn6ibTUNNj9Mch8nvhYAV2YmpmM6g@mail.gmail.com" {
+		t.Fatal("Expected MessageID result:", data.MessageID)
+	}
+
+	if data.To.String() != "Rodrigo <test@gmail.com>" {
+		t.Fatal("Expected To Rodrigo <test@gmail.com> result:", data.To)
+	}
+
+	if len(data.Attachments) != len(mm.Attachments) || len(data.Attachments) == 0 {
+		t.Fatal("Expected attachments result:", data.Attachments)
+	}
+
+	if a := data.Attachments[0]; a.Filename != mm.Attachments[0].Filename || a.Size != len(mm.Attachments[0].Content) {
+		t.Fatal("Expected attachment name and size result:", a)
+	}
+}
+
+func TestReadMailMessageMarkdown(t *testing.T) {
+	email := `From: John Doe <jdoe@machine.example>
+Subject: Report
+Content-Type: text/html; charset=utf-8
+
+<p>Totals of <b>today</b>:</p><table><tr><th>Item</th><th>Total</th></tr><tr><td>Pens</td><td>10</td></tr></table>
+`
+	mm, err := ReadMailMessage(bytes.NewBufferString(email))
+	if err != nil {
+		t.Fatal("Failed to parsing msg:", err.Error())
+	}
+
+	markdown := "Totals of **today**:\n\n| Item | Total |\n| --- | --- |\n| Pens | 10 |"
+	if mm.EmailMarkdown != markdown || !mm.HTMLOnly {
+		t.Fatalf("Expected HTML only with markdown result:%v '%v'", mm.HTMLOnly, mm.EmailMarkdown)
+	}
+
+	if text := mm.postText(model.MessageSourceText); text != markdown {
+		t.Fatal("Expected markdown of HTML only email result:", text)
+	}
+
+	// email with text part
+	gmailbuf, err := os.Open(findDir("emltest") + "gmail.eml")
+	if err != nil {
+		t.Fatal("Error on open gmail.eml:", err)
+	}
+
+	if mm, err = ReadMailMessage(gmailbuf); err != nil {
+		t.Fatal("Failed to parsing msg:", err.Error())
+	}
+
+	if mm.HTMLOnly || mm.EmailMarkdown == "" {
+		t.Fatalf("Expected text and markdown result:%v '%v'", mm.HTMLOnly, mm.EmailMarkdown)
+	}
+
+	if text := mm.postText(model.MessageSourceText); text != mm.EmailText {
+		t.Fatal("Expected text part result:", text)
+	}
+
+	if text := mm.postText(model.MessageSourceHTML); text != mm.EmailMarkdown {
+		t.Fatal("Expected markdown result:", text)
+	}
+}
+
+func TestReadMailMessageInlines(t *testing.T) {
+	thunderbuf, err := os.Open(findDir("emltest") + "thunderbird.eml")
+	if err != nil {
+		t.Fatal("Error on open thunderbird.eml:", err)
+	}
+
+	mm, err := ReadMailMessage(thunderbuf)
+	if err != nil {
+		t.Fatal("Failed to parsing msg:", err.Error())
+	}
+
+	if len(mm.Inlines) != 1 {
+		t.Fatal("Expected 1 inline part result:", len(mm.Inlines))
+	}
+
+	inline := mm.Inlines[0]
+	if inline.Filename != "icon.png" || inline.ContentID != "part1.1FDEB042.C9CA00F0@gmail.com" || inline.ContentType != "image/png" {
+		t.Fatalf("Expected icon.png result:%v %v %v", inline.Filename, inline.ContentID, inline.ContentType)
+	}
+
+	if width, height := imageDimension(inline.Content); width != 25 || height != 22 {
+		t.Fatalf("Expected dimension 25x22 result:%vx%v", width, height)
+	}
+
+	if !strings.Contains(mm.EmailBody, "cid:"+inline.ContentID) {
+		t.Fatal("Expected cid: URL in EmailBody result:", mm.EmailBody)
+	}
+}
+
+func Test_parseMessageIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{"empty", "", nil},
+		{"one", "<a@example.com>", []string{"a@example.com"}},
+		{"many", "<a@example.com> <b@example.com>\t<c@example.com>", []string{"a@example.com", "b@example.com", "c@example.com"}},
+		{"comment", "<a@example.com> (John's message)", []string{"a@example.com"}},
+		{"no brackets", "a@example.com", []string{"a@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMessageIDs(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMessageIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_removeNonUTF8(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -201,3 +417,23 @@ func Test_removeNonUTF8(t *testing.T) {
 		})
 	}
 }
+
+func Test_removeCIDImages(t *testing.T) {
+	part := &InlinePart{Attachment: Attachment{Filename: "logo.png"}, ContentID: "logo@example.com"}
+
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<p><img src="cid:logo@example.com" alt="ACME logo"></p>`, `<p>ACME logo</p>`},
+		{`<img width=10 src='cid:logo@example.com' alt='' />`, `[logo.png]`},
+		{`<IMG SRC=cid:logo@example.com>`, `[logo.png]`},
+		{`<img src="cid:logo@example.com.br">`, `<img src="cid:logo@example.com.br">`},
+		{`<div style="background:url(cid:logo@example.com)"></div>`, `<div style="background:url(cid:logo@example.com)"></div>`},
+	}
+	for _, tt := range tests {
+		if got := removeCIDImages(tt.html, part); got != tt.want {
+			t.Errorf("removeCIDImages(%v) = %v, want %v", tt.html, got, tt.want)
+		}
+	}
+}
@@ -16,8 +16,10 @@ import (
 type MailProviderImap struct {
 	imapClient *client.Client
 	cfg        *model.Email
+	mailbox    string
 	log        Logger
 	cache      UIDCache
+	tokens     OAuth2TokenSource
 	idle       bool
 	debug      bool
 }
@@ -25,13 +27,16 @@ type MailProviderImap struct {
 // MailBox default mail box
 const MailBox = "INBOX"
 
-// NewMailProviderImap creates a new MailProviderImap implementing MailProvider
-func NewMailProviderImap(cfg *model.Email, log Logger, cache UIDCache, debug bool) *MailProviderImap {
+// NewMailProviderImap creates a new MailProviderImap implementing MailProvider for the mailbox,
+// tokens is only used when OAuth2 is configured
+func NewMailProviderImap(cfg *model.Email, mailbox string, log Logger, cache UIDCache, tokens OAuth2TokenSource, debug bool) *MailProviderImap {
 	return &MailProviderImap{
-		cfg:   cfg,
-		cache: cache,
-		log:   log,
-		debug: debug,
+		cfg:     cfg,
+		mailbox: mailbox,
+		cache:   cache,
+		tokens:  tokens,
+		log:     log,
+		debug:   debug,
 	}
 }
 
@@ -189,18 +194,18 @@ func (m *MailProviderImap) WaitNewMessage(timeout int) error {
 
 func (m *MailProviderImap) selectMailBox() (*imap.MailboxStatus, error) {
 
-	if m.imapClient.Mailbox() != nil && m.imapClient.Mailbox().Name == MailBox {
+	if m.imapClient.Mailbox() != nil && m.imapClient.Mailbox().Name == m.mailbox {
 		if err := m.imapClient.Close(); err != nil {
 			m.log.Debug("MailProviderImap.selectMailBox: Error on close mailbox:", err.Error())
 		}
 	}
 
-	m.log.Debug("MailProviderImap.selectMailBox: Select mailbox:", MailBox)
+	m.log.Debug("MailProviderImap.selectMailBox: Select mailbox:", m.mailbox)
 
-	mbox, err := m.imapClient.Select(MailBox, true)
+	mbox, err := m.imapClient.Select(m.mailbox, true)
 	if err != nil {
-		m.log.Error("MailProviderImap.selectMailBox: Error on select", MailBox)
-		return nil, errors.Wrapf(err, "select mailbox '%v'", MailBox)
+		m.log.Error("MailProviderImap.selectMailBox: Error on select", m.mailbox)
+		return nil, errors.Wrapf(err, "select mailbox '%v'", m.mailbox)
 	}
 	return mbox, nil
 }
@@ -268,10 +273,17 @@ func (m *MailProviderImap) checkConnection() error {
 
 	m.log.Infof("Connected with %q\n", m.cfg.ImapServer)
 
-	err = m.imapClient.Login(m.cfg.Username, m.cfg.Password)
-	if err != nil {
-		m.log.Error("MailProviderImap.CheckConnection: Unable to login:", m.cfg.Username)
-		return errors.Wrapf(err, "unable to login username:'%v'", m.cfg.Username)
+	if m.cfg.OAuth2 != nil {
+		if err = m.authenticateOAuth2(); err != nil {
+			m.log.Error("MailProviderImap.CheckConnection: Unable to authenticate with OAuth2:", m.cfg.Username)
+			return errors.Wrapf(err, "unable to authenticate with OAuth2 username:'%v'", m.cfg.Username)
+		}
+	} else {
+		err = m.imapClient.Login(m.cfg.Username, m.cfg.Password)
+		if err != nil {
+			m.log.Error("MailProviderImap.CheckConnection: Unable to login:", m.cfg.Username)
+			return errors.Wrapf(err, "unable to login username:'%v'", m.cfg.Username)
+		}
 	}
 
 	if _, err = m.selectMailBox(); err != nil {
@@ -293,6 +305,37 @@ func (m *MailProviderImap) checkConnection() error {
 	return nil
 }
 
+// authenticateOAuth2 authenticates using the access token, the token is discarded on failure
+func (m *MailProviderImap) authenticateOAuth2() error {
+	if m.tokens == nil {
+		return errors.New("OAuth2 token source is not defined")
+	}
+
+	mechanism := *m.cfg.OAuth2.Mechanism
+
+	if ok, err := m.imapClient.SupportAuth(mechanism); err != nil {
+		return errors.Wrapf(err, "check support AUTH=%v", mechanism)
+	} else if !ok {
+		return errors.Errorf("imap server does not support AUTH=%v", mechanism)
+	}
+
+	token, err := m.tokens.Token()
+	if err != nil {
+		return errors.Wrap(err, "get access token")
+	}
+
+	m.log.Debug("MailProviderImap.authenticateOAuth2: Authenticate", mechanism)
+
+	if err := m.imapClient.Authenticate(newOAuth2SASLClient(m.cfg, token)); err != nil {
+		if ierr := m.tokens.Invalidate(); ierr != nil {
+			m.log.Error("MailProviderImap.authenticateOAuth2: Error on invalidate token:", ierr.Error())
+		}
+		return errors.Wrapf(err, "authenticate %v", mechanism)
+	}
+
+	return nil
+}
+
 // Terminate imap connection
 func (m *MailProviderImap) Terminate() error {
 	defer func() {
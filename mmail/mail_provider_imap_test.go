@@ -5,6 +5,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"os"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"github.com/emersion/go-imap/backend"
 	"github.com/emersion/go-imap/backend/memory"
 	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
 	"github.com/pkg/errors"
 	"github.com/rodcorsi/mattermail/model"
 )
@@ -40,19 +43,67 @@ func init() {
 
 	ts.Server = server.New(ts.be)
 	ts.Enable(idle.NewExtension())
+	ts.EnableAuth(model.OAuth2MechanismXOAuth2, newOAuthTestServer)
+	ts.EnableAuth(model.OAuth2MechanismOAuthBearer, newOAuthTestServer)
+
+	ts.AllowInsecureAuth = true
 
 	go ts.Serve(l)
 
 	ts.addr = l.Addr().String()
 
-	ts.AllowInsecureAuth = true
-
 	_, err = net.Dial("tcp", ts.addr)
 	if err != nil {
 		panic("Cannot connect to server:" + err.Error())
 	}
 }
 
+// oauthTestServer accepts XOAUTH2 and OAUTHBEARER with tokens access-N for user username
+type oauthTestServer struct {
+	conn   server.Conn
+	failed bool
+}
+
+func newOAuthTestServer(conn server.Conn) sasl.Server {
+	return &oauthTestServer{conn: conn}
+}
+
+func (s *oauthTestServer) Next(response []byte) ([]byte, bool, error) {
+	if s.failed {
+		return nil, true, errors.New("Invalid credentials")
+	}
+
+	if response == nil {
+		return []byte{}, false, nil
+	}
+
+	var user, token string
+	for _, field := range strings.Split(string(response), "\x01") {
+		if strings.HasPrefix(field, "user=") {
+			user = strings.TrimPrefix(field, "user=")
+		} else if strings.HasPrefix(field, "n,a=") {
+			user = strings.TrimSuffix(strings.TrimPrefix(field, "n,a="), ",")
+		} else if strings.HasPrefix(field, "auth=Bearer ") {
+			token = strings.TrimPrefix(field, "auth=Bearer ")
+		}
+	}
+
+	if user != "username" || !strings.HasPrefix(token, "access-") {
+		s.failed = true
+		return []byte(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`), false, nil
+	}
+
+	u, err := ts.be.Login("username", "password")
+	if err != nil {
+		return nil, true, err
+	}
+
+	ctx := s.conn.Context()
+	ctx.State = imap.AuthenticatedState
+	ctx.User = u
+	return nil, true, nil
+}
+
 func TestCheckNewMessage(t *testing.T) {
 	// Create a memory backend
 	user, _ := ts.be.Login("username", "password")
@@ -71,7 +122,7 @@ func TestCheckNewMessage(t *testing.T) {
 	config.Password = "password"
 	config.ImapServer = ts.addr
 
-	mP := NewMailProviderImap(config, NewLog("", debugImap), &uidCacheMem{}, debugImap)
+	mP := NewMailProviderImap(config, MailBox, NewLog("", debugImap), &uidCacheMem{}, nil, debugImap)
 
 	defer mP.Terminate()
 
@@ -94,6 +145,92 @@ func TestCheckNewMessage(t *testing.T) {
 	}
 }
 
+func TestCheckNewMessageFolder(t *testing.T) {
+	user, _ := ts.be.Login("username", "password")
+	user.CreateMailbox("Alerts")
+	alerts, _ := user.GetMailbox("Alerts")
+
+	email, _ := ioutil.ReadFile(findDir("emltest") + "roundcube.eml")
+	alerts.CreateMessage([]string{}, time.Now(), bytes.NewBuffer(email))
+
+	config := model.NewEmail()
+	config.Username = "username"
+	config.Password = "password"
+	config.ImapServer = ts.addr
+
+	mP := NewMailProviderImap(config, "Alerts", NewLog("", debugImap), &uidCacheMem{}, nil, debugImap)
+
+	defer mP.Terminate()
+
+	var count uint32
+
+	err := mP.CheckNewMessage(func(mailReader io.Reader) error {
+		atomic.AddUint32(&count, 1)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if count != 1 {
+		t.Fatal("Expected 1 message, received", count)
+	}
+
+	if name := mP.imapClient.Mailbox().Name; name != "Alerts" {
+		t.Fatal("Expected selected mailbox Alerts, result", name)
+	}
+}
+
+func TestCheckConnectionOAuth2(t *testing.T) {
+	var count uint32
+	srv := newTokenMock(t, &count)
+	defer srv.Close()
+
+	for _, mechanism := range []string{model.OAuth2MechanismXOAuth2, model.OAuth2MechanismOAuthBearer} {
+		config := model.NewEmail()
+		config.Username = "username"
+		config.ImapServer = ts.addr
+		config.OAuth2 = model.NewOAuth2()
+		config.OAuth2.ClientID = "client"
+		config.OAuth2.RefreshToken = "refresh"
+		config.OAuth2.TokenURL = srv.URL
+		*config.OAuth2.Mechanism = mechanism
+
+		tokens := NewOAuth2TokenFile(config.OAuth2, os.TempDir(), "username")
+		os.Remove(tokens.filename)
+		defer os.Remove(tokens.filename)
+
+		mP := NewMailProviderImap(config, MailBox, NewLog("", debugImap), &uidCacheMem{}, tokens, debugImap)
+
+		if err := mP.checkConnection(); err != nil {
+			t.Fatal("Mechanism:", mechanism, "error:", err.Error())
+		}
+
+		mP.Terminate()
+
+		// invalid token is discarded after authentication fails
+		tokens.token.AccessToken = "invalid"
+		mP = NewMailProviderImap(config, MailBox, NewLog("", debugImap), &uidCacheMem{}, tokens, debugImap)
+
+		if err := mP.checkConnection(); err == nil {
+			t.Fatal("Mechanism:", mechanism, "expected error with invalid token")
+		}
+
+		mP.Terminate()
+
+		if tokens.token.AccessToken != "" {
+			t.Fatal("Mechanism:", mechanism, "expected token invalidated result:", tokens.token.AccessToken)
+		}
+
+		if err := mP.checkConnection(); err != nil {
+			t.Fatal("Mechanism:", mechanism, "error after refresh:", err.Error())
+		}
+
+		mP.Terminate()
+	}
+}
+
 func TestWaitNewMessage(t *testing.T) {
 	t.Skip("Disabled bug in lib")
 	config := model.NewEmail()
@@ -102,7 +239,7 @@ func TestWaitNewMessage(t *testing.T) {
 	config.ImapServer = ts.addr
 	*config.StartTLS = true
 
-	mP := NewMailProviderImap(config, NewLog("", debugImap), &uidCacheMem{}, debugImap)
+	mP := NewMailProviderImap(config, MailBox, NewLog("", debugImap), &uidCacheMem{}, nil, debugImap)
 
 	done := make(chan error, 1)
 	go func() {
@@ -0,0 +1,190 @@
+package mmail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// MailProviderPop3 implements MailProvider using pop3, pop3 does not support idle
+// so WaitNewMessage always polls the server
+type MailProviderPop3 struct {
+	pop3Client *pop3Client
+	cfg        *model.Email
+	log        Logger
+	cache      UIDLCache
+	debug      bool
+}
+
+// NewMailProviderPop3 creates a new MailProviderPop3 implementing MailProvider
+func NewMailProviderPop3(cfg *model.Email, log Logger, cache UIDLCache, debug bool) *MailProviderPop3 {
+	return &MailProviderPop3{
+		cfg:   cfg,
+		cache: cache,
+		log:   log,
+		debug: debug,
+	}
+}
+
+// CheckNewMessage gets new email from server, the session is terminated after the check
+// to commit the deletions and release the maildrop lock
+func (m *MailProviderPop3) CheckNewMessage(handler MailHandler) error {
+	m.log.Debug("MailProviderPop3.CheckNewMessage")
+
+	if err := m.checkConnection(); err != nil {
+		return errors.Wrap(err, "checkConnection with pop3 server")
+	}
+
+	defer m.Terminate()
+
+	list, err := m.pop3Client.uidl()
+	if err != nil {
+		return errors.Wrap(err, "pop3 UIDL")
+	}
+
+	uidls := make([]string, 0, len(list))
+	for _, msg := range list {
+		uidls = append(uidls, msg.uidl)
+	}
+
+	initialized, err := m.cache.Initialized()
+	if err != nil {
+		return errors.Wrap(err, "check uidl cache")
+	}
+
+	// on the first check the emails already in the server are not posted, only the new ones
+	if !initialized {
+		m.log.Infof("First check of %v, %v emails in the server are skipped\n", m.cfg.Username, len(uidls))
+		if err := m.cache.Seed(uidls); err != nil {
+			return errors.Wrap(err, "seed processed uidls")
+		}
+		return nil
+	}
+
+	for _, msg := range list {
+
+		processed, err := m.cache.IsProcessed(msg.uidl)
+		if err != nil {
+			return errors.Wrap(err, "check processed uidl")
+		}
+
+		if processed {
+			continue
+		}
+
+		m.log.Debug("MailProviderPop3.CheckNewMessage: PostMail uidl:", msg.uidl)
+
+		data, err := m.pop3Client.retr(msg.seq)
+		if err != nil {
+			return errors.Wrapf(err, "pop3 RETR %v", msg.seq)
+		}
+
+		if err := handler(bytes.NewReader(data)); err != nil {
+			m.log.Error("MailProviderPop3.CheckNewMessage: Error handler")
+			return errors.Wrap(err, "execute MailHandler")
+		}
+
+		if err := m.cache.SaveProcessed(msg.uidl); err != nil {
+			m.log.Error("MailProviderPop3.CheckNewMessage: Error on save processed uidl")
+			return errors.Wrap(err, "save processed uidl")
+		}
+
+		if *m.cfg.DeleteAfterPost {
+			m.log.Debug("MailProviderPop3.CheckNewMessage: Delete uidl:", msg.uidl)
+			if err := m.pop3Client.dele(msg.seq); err != nil {
+				return errors.Wrapf(err, "pop3 DELE %v", msg.seq)
+			}
+		}
+	}
+
+	if err := m.cache.Retain(uidls); err != nil {
+		return errors.Wrap(err, "retain processed uidls")
+	}
+
+	return nil
+}
+
+// WaitNewMessage waits for a new message, pop3 does not support idle
+func (m *MailProviderPop3) WaitNewMessage(timeout int) error {
+	m.log.Debug("MailProviderPop3.WaitNewMessage")
+	time.Sleep(time.Second * time.Duration(timeout))
+	return nil
+}
+
+// checkConnection connects and authenticates on pop3 server
+func (m *MailProviderPop3) checkConnection() error {
+	if m.pop3Client != nil {
+		return nil
+	}
+
+	var tconfig tls.Config
+	if *m.cfg.TLSAcceptAllCerts {
+		tconfig.InsecureSkipVerify = true
+	} else {
+		host, _, err := net.SplitHostPort(m.cfg.Pop3Server)
+		if err != nil {
+			host = m.cfg.Pop3Server
+		}
+		tconfig.ServerName = host
+	}
+
+	var logger Logger
+	if m.debug {
+		logger = m.log
+	}
+
+	var err error
+
+	//Start connection with server
+	if strings.HasSuffix(m.cfg.Pop3Server, ":995") {
+		m.log.Debug("MailProviderPop3.CheckConnection: DialTLS")
+		m.pop3Client, err = dialPop3(m.cfg.Pop3Server, &tconfig, logger)
+	} else {
+		m.log.Debug("MailProviderPop3.CheckConnection: Dial")
+		m.pop3Client, err = dialPop3(m.cfg.Pop3Server, nil, logger)
+	}
+
+	if err != nil {
+		m.log.Error("MailProviderPop3.CheckConnection: Unable to connect:", m.cfg.Pop3Server)
+		return errors.Wrapf(err, "unable to connect '%v'", m.cfg.Pop3Server)
+	}
+
+	if *m.cfg.StartTLS && m.pop3Client.capabilities()["STLS"] {
+		m.log.Debug("MailProviderPop3.CheckConnection:StartTLS")
+		if err := m.pop3Client.startTLS(&tconfig); err != nil {
+			m.Terminate()
+			return errors.Wrap(err, "enable StartTLS")
+		}
+	}
+
+	m.log.Debugf("Connected with %q\n", m.cfg.Pop3Server)
+
+	if err := m.pop3Client.login(m.cfg.Username, m.cfg.Password); err != nil {
+		m.log.Error("MailProviderPop3.CheckConnection: Unable to login:", m.cfg.Username)
+		m.Terminate()
+		return errors.Wrapf(err, "unable to login username:'%v'", m.cfg.Username)
+	}
+
+	return nil
+}
+
+// Terminate pop3 connection
+func (m *MailProviderPop3) Terminate() error {
+	defer func() {
+		m.pop3Client = nil
+	}()
+	if m.pop3Client != nil {
+		m.log.Debug("MailProviderPop3.Terminate Quit")
+		if err := m.pop3Client.quit(); err != nil {
+			m.log.Error("MailProviderPop3.Terminate Error:", err.Error())
+			return errors.Wrap(err, "terminate pop3 connection")
+		}
+	}
+
+	return nil
+}
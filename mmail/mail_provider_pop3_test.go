@@ -0,0 +1,372 @@
+package mmail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// newTestTLSConfig creates a server tls config with a self-signed certificate
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error on generate key:", err.Error())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"Mattermail test"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error on create certificate:", err.Error())
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+// pop3Mock is a local pop3 server with a maildrop of messages
+type pop3Mock struct {
+	addr      string
+	tlsConfig *tls.Config
+	messages  []string
+	uidls     []string
+	tls       bool
+	lock      sync.Mutex
+}
+
+func newPop3Mock(t *testing.T) *pop3Mock {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen:", err.Error())
+	}
+
+	s := &pop3Mock{
+		addr:      l.Addr().String(),
+		tlsConfig: newTestTLSConfig(t),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *pop3Mock) add(uidl, msg string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.uidls = append(s.uidls, uidl)
+	s.messages = append(s.messages, msg)
+}
+
+func (s *pop3Mock) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.messages)
+}
+
+func (s *pop3Mock) serve(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tp := textproto.NewConn(conn)
+	defer func() { tp.Close() }()
+
+	tp.PrintfLine("+OK POP3 mock ready")
+
+	deleted := make(map[int]bool)
+	authenticated := false
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		f := strings.Fields(line)
+		cmd := strings.ToUpper(f[0])
+
+		seq := 0
+		if len(f) > 1 {
+			seq, _ = strconv.Atoi(f[1])
+		}
+
+		if !authenticated && cmd != "CAPA" && cmd != "STLS" && cmd != "USER" && cmd != "PASS" && cmd != "QUIT" {
+			tp.PrintfLine("-ERR not authenticated")
+			continue
+		}
+
+		switch cmd {
+		case "CAPA":
+			tp.PrintfLine("+OK Capability list follows")
+			tp.PrintfLine("USER")
+			tp.PrintfLine("UIDL")
+			tp.PrintfLine("STLS")
+			tp.PrintfLine(".")
+		case "STLS":
+			tp.PrintfLine("+OK Begin TLS negotiation")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.tls = true
+			conn = tlsConn
+			tp = textproto.NewConn(tlsConn)
+		case "USER":
+			tp.PrintfLine("+OK")
+		case "PASS":
+			if len(f) < 2 || f[1] != "password" {
+				tp.PrintfLine("-ERR invalid password")
+				continue
+			}
+			authenticated = true
+			tp.PrintfLine("+OK Logged in")
+		case "UIDL":
+			tp.PrintfLine("+OK")
+			for i, uidl := range s.uidls {
+				if !deleted[i+1] {
+					tp.PrintfLine("%d %s", i+1, uidl)
+				}
+			}
+			tp.PrintfLine(".")
+		case "RETR":
+			if seq < 1 || seq > len(s.messages) || deleted[seq] {
+				tp.PrintfLine("-ERR no such message")
+				continue
+			}
+			tp.PrintfLine("+OK")
+			w := tp.DotWriter()
+			io.WriteString(w, s.messages[seq-1])
+			w.Close()
+		case "DELE":
+			if seq < 1 || seq > len(s.messages) {
+				tp.PrintfLine("-ERR no such message")
+				continue
+			}
+			deleted[seq] = true
+			tp.PrintfLine("+OK")
+		case "QUIT":
+			var uidls, messages []string
+			for i := range s.messages {
+				if !deleted[i+1] {
+					uidls = append(uidls, s.uidls[i])
+					messages = append(messages, s.messages[i])
+				}
+			}
+			s.uidls, s.messages = uidls, messages
+			tp.PrintfLine("+OK Bye")
+			return
+		default:
+			tp.PrintfLine("-ERR unknown command")
+		}
+	}
+}
+
+func TestMailProviderPop3_CheckNewMessage(t *testing.T) {
+	mock := newPop3Mock(t)
+
+	email, _ := ioutil.ReadFile(findDir("emltest") + "gmail.eml")
+	mock.add("uidl-1", string(email))
+	mock.add("uidl-2", "Subject: Second\r\n\r\nSecond message\r\n")
+
+	config := model.NewEmail()
+	config.Pop3Server = mock.addr
+	config.Username = "username"
+	config.Password = "password"
+	*config.StartTLS = true
+	*config.TLSAcceptAllCerts = true
+
+	// cache of a previous check
+	cache := &uidlCacheMem{processed: map[string]bool{}}
+	mP := NewMailProviderPop3(config, NewLog("", false), cache, false)
+
+	var subjects []string
+	handler := func(mailReader io.Reader) error {
+		msg, err := ReadMailMessage(mailReader)
+		if err != nil {
+			return err
+		}
+		subjects = append(subjects, msg.Subject)
+		return nil
+	}
+
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	if len(subjects) != 2 || subjects[1] != "Second" {
+		t.Fatal("Expected 2 messages result:", subjects)
+	}
+
+	if !mock.tls {
+		t.Fatal("Expected connection upgraded with STLS")
+	}
+
+	// processed messages are not posted again
+	mock.add("uidl-3", "Subject: Third\r\n\r\nThird message\r\n")
+	subjects = nil
+
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	if len(subjects) != 1 || subjects[0] != "Third" {
+		t.Fatal("Expected only the third message result:", subjects)
+	}
+
+	if mock.count() != 3 {
+		t.Fatal("Expected messages kept on server result:", mock.count())
+	}
+
+	// delete after post
+	*config.DeleteAfterPost = true
+	mock.add("uidl-4", "Subject: Fourth\r\n\r\nFourth message\r\n")
+	subjects = nil
+
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	if len(subjects) != 1 || subjects[0] != "Fourth" {
+		t.Fatal("Expected only the fourth message result:", subjects)
+	}
+
+	if mock.count() != 3 {
+		t.Fatal("Expected the fourth message deleted result:", mock.count())
+	}
+
+	// error in handler keeps the message to try again
+	mock.add("uidl-5", "Subject: Fifth\r\n\r\nFifth message\r\n")
+
+	if err := mP.CheckNewMessage(func(io.Reader) error { return fmt.Errorf("post error") }); err == nil {
+		t.Fatal("Expected error from handler")
+	}
+
+	if ok, _ := cache.IsProcessed("uidl-5"); ok {
+		t.Fatal("Expected uidl-5 not processed after error")
+	}
+
+	if mock.count() != 4 {
+		t.Fatal("Expected the fifth message kept on server result:", mock.count())
+	}
+}
+
+func TestMailProviderPop3_FirstCheck(t *testing.T) {
+	mock := newPop3Mock(t)
+	mock.add("uidl-1", "Subject: First\r\n\r\nFirst message\r\n")
+	mock.add("uidl-2", "Subject: Second\r\n\r\nSecond message\r\n")
+
+	config := model.NewEmail()
+	config.Pop3Server = mock.addr
+	config.Username = "username"
+	config.Password = "password"
+
+	cache := &uidlCacheMem{}
+	mP := NewMailProviderPop3(config, NewLog("", false), cache, false)
+
+	var subjects []string
+	handler := func(mailReader io.Reader) error {
+		msg, err := ReadMailMessage(mailReader)
+		if err != nil {
+			return err
+		}
+		subjects = append(subjects, msg.Subject)
+		return nil
+	}
+
+	// emails already in the server are not posted on the first check
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	if len(subjects) != 0 {
+		t.Fatal("Expected no messages on first check result:", subjects)
+	}
+
+	if ok, _ := cache.IsProcessed("uidl-2"); !ok {
+		t.Fatal("Expected uidl-2 processed after first check")
+	}
+
+	mock.add("uidl-3", "Subject: Third\r\n\r\nThird message\r\n")
+
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	if len(subjects) != 1 || subjects[0] != "Third" {
+		t.Fatal("Expected only the third message result:", subjects)
+	}
+
+	// an empty maildrop on first check does not skip the next emails
+	empty := newPop3Mock(t)
+	config.Pop3Server = empty.addr
+	cache = &uidlCacheMem{}
+	mP = NewMailProviderPop3(config, NewLog("", false), cache, false)
+	subjects = nil
+
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	empty.add("uidl-1", "Subject: First\r\n\r\nFirst message\r\n")
+
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	if len(subjects) != 1 || subjects[0] != "First" {
+		t.Fatal("Expected the first message posted result:", subjects)
+	}
+}
+
+func TestMailProviderPop3_Login(t *testing.T) {
+	mock := newPop3Mock(t)
+
+	config := model.NewEmail()
+	config.Pop3Server = mock.addr
+	config.Username = "username"
+	config.Password = "invalid"
+
+	mP := NewMailProviderPop3(config, NewLog("", false), &uidlCacheMem{}, false)
+
+	if err := mP.CheckNewMessage(func(io.Reader) error { return nil }); err == nil {
+		t.Fatal("Expected error with invalid password")
+	}
+
+	if mP.pop3Client != nil {
+		t.Fatal("Expected connection terminated after login error")
+	}
+}
@@ -0,0 +1,93 @@
+package mmail
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// receivedMail message delivered by the Receiver waiting to be handled
+type receivedMail struct {
+	data []byte
+	done chan error
+}
+
+// MailProviderReceiver implements MailProvider receiving the emails delivered by the Receiver,
+// the Receiver waits the MailHandler result to accept or defer the delivery
+type MailProviderReceiver struct {
+	log     Logger
+	queue   chan *receivedMail
+	pending *receivedMail
+}
+
+// NewMailProviderReceiver creates a new MailProviderReceiver implementing MailProvider
+func NewMailProviderReceiver(log Logger) *MailProviderReceiver {
+	return &MailProviderReceiver{
+		log:   log,
+		queue: make(chan *receivedMail),
+	}
+}
+
+// CheckNewMessage handles the emails delivered by the Receiver, the errors of MailHandler are
+// returned to the Receiver that rejects or defers the delivery
+func (m *MailProviderReceiver) CheckNewMessage(handler MailHandler) error {
+	m.log.Debug("MailProviderReceiver.CheckNewMessage")
+
+	for {
+		mail := m.pending
+		m.pending = nil
+
+		if mail == nil {
+			select {
+			case mail = <-m.queue:
+			default:
+				m.log.Debug("MailProviderReceiver.CheckNewMessage: No new messages")
+				return nil
+			}
+		}
+
+		err := handler(bytes.NewReader(mail.data))
+		mail.done <- err
+
+		// the sender tries again, other emails are not delayed
+		if err != nil {
+			m.log.Error("MailProviderReceiver.CheckNewMessage: Error handler err:", err.Error())
+		}
+	}
+}
+
+// WaitNewMessage waits for an email delivered by the Receiver
+func (m *MailProviderReceiver) WaitNewMessage(timeout int) error {
+	m.log.Debug("MailProviderReceiver.WaitNewMessage")
+
+	select {
+	case m.pending = <-m.queue:
+		m.log.Debug("MailProviderReceiver.WaitNewMessage: New message")
+	case <-time.After(time.Second * time.Duration(timeout)):
+		m.log.Debug("MailProviderReceiver.WaitNewMessage: Timeout")
+	}
+	return nil
+}
+
+// Terminate does nothing, the connection is managed by the Receiver
+func (m *MailProviderReceiver) Terminate() error {
+	return nil
+}
+
+// deliver sends the email to be handled and returns the MailHandler result, returns error
+// if the email is not taken before timeout
+func (m *MailProviderReceiver) deliver(data []byte, timeout time.Duration) error {
+	mail := &receivedMail{
+		data: data,
+		done: make(chan error, 1),
+	}
+
+	select {
+	case m.queue <- mail:
+	case <-time.After(timeout):
+		return errors.New("timeout waiting the mail provider")
+	}
+
+	return <-mail.done
+}
@@ -0,0 +1,64 @@
+package mmail
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestMailProviderReceiver(t *testing.T) {
+	mP := NewMailProviderReceiver(NewLog("", false))
+
+	var received []string
+	handler := func(mailReader io.Reader) error {
+		data, _ := ioutil.ReadAll(mailReader)
+		received = append(received, string(data))
+		if string(data) == "fail" {
+			return errors.New("post error")
+		}
+		return nil
+	}
+
+	if err := mP.CheckNewMessage(handler); err != nil || len(received) != 0 {
+		t.Fatalf("Expected no messages result:%v err:%v", received, err)
+	}
+
+	if err := mP.deliver([]byte("lost"), time.Millisecond*10); err == nil {
+		t.Fatal("Expected timeout error without MatterMail waiting")
+	}
+
+	done := make(chan error, 2)
+	go func() {
+		done <- mP.deliver([]byte("hello"), time.Second*5)
+		done <- mP.deliver([]byte("fail"), time.Second*5)
+	}()
+
+	if err := mP.WaitNewMessage(5); err != nil {
+		t.Fatal("Error on wait new message:", err.Error())
+	}
+
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Error on check new message:", err.Error())
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal("Expected message delivered err:", err.Error())
+	}
+
+	mP.WaitNewMessage(5)
+
+	// handler error is returned only to deliver, MatterMail does not wait to try again
+	if err := mP.CheckNewMessage(handler); err != nil {
+		t.Fatal("Expected no error from check new message err:", err.Error())
+	}
+
+	if err := <-done; err == nil {
+		t.Fatal("Expected handler error returned to deliver")
+	}
+
+	if len(received) != 2 || received[0] != "hello" || received[1] != "fail" {
+		t.Fatal("Expected messages hello and fail result:", received)
+	}
+}
@@ -0,0 +1,7 @@
+package mmail
+
+// MailSender interface to abstract sending emails
+type MailSender interface {
+	// Send sends the message from the address to the recipients
+	Send(from string, to []string, msg []byte) error
+}
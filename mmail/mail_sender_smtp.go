@@ -0,0 +1,97 @@
+package mmail
+
+import (
+	"crypto/tls"
+	"net/smtp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// MailSenderSMTP implements MailSender using smtp
+type MailSenderSMTP struct {
+	cfg *model.SMTP
+	log Logger
+}
+
+// NewMailSenderSMTP creates a new MailSenderSMTP implementing MailSender
+func NewMailSenderSMTP(cfg *model.SMTP, log Logger) *MailSenderSMTP {
+	return &MailSenderSMTP{
+		cfg: cfg,
+		log: log,
+	}
+}
+
+// Send sends the message from the address to the recipients
+func (m *MailSenderSMTP) Send(from string, to []string, msg []byte) error {
+	m.log.Debug("MailSenderSMTP.Send")
+
+	host := strings.Split(m.cfg.Server, ":")[0]
+
+	tconfig := &tls.Config{ServerName: host}
+	if *m.cfg.TLSAcceptAllCerts {
+		tconfig.InsecureSkipVerify = true
+	}
+
+	var c *smtp.Client
+
+	if strings.HasSuffix(m.cfg.Server, ":465") {
+		m.log.Debug("MailSenderSMTP.Send: DialTLS")
+		conn, err := tls.Dial("tcp", m.cfg.Server, tconfig)
+		if err != nil {
+			return errors.Wrapf(err, "unable to connect '%v'", m.cfg.Server)
+		}
+
+		if c, err = smtp.NewClient(conn, host); err != nil {
+			return errors.Wrapf(err, "unable to start smtp session '%v'", m.cfg.Server)
+		}
+	} else {
+		m.log.Debug("MailSenderSMTP.Send: Dial")
+		var err error
+		if c, err = smtp.Dial(m.cfg.Server); err != nil {
+			return errors.Wrapf(err, "unable to connect '%v'", m.cfg.Server)
+		}
+	}
+	defer c.Close()
+
+	if *m.cfg.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			m.log.Debug("MailSenderSMTP.Send: StartTLS")
+			if err := c.StartTLS(tconfig); err != nil {
+				return errors.Wrap(err, "enable StartTLS")
+			}
+		}
+	}
+
+	if m.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)); err != nil {
+			return errors.Wrapf(err, "unable to login username:'%v'", m.cfg.Username)
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return errors.Wrapf(err, "smtp MAIL FROM:'%v'", from)
+	}
+
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return errors.Wrapf(err, "smtp RCPT TO:'%v'", rcpt)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "smtp DATA")
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return errors.Wrap(err, "write message")
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "send message")
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,110 @@
+package mmail
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// smtpMock is a local smtp server that accepts one message
+type smtpMock struct {
+	addr string
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newSMTPMock(t *testing.T) *smtpMock {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen:", err.Error())
+	}
+
+	s := &smtpMock{
+		addr: l.Addr().String(),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP mock")
+
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250 AUTH PLAIN")
+			case strings.HasPrefix(cmd, "AUTH"):
+				tp.PrintfLine("235 Authentication successful")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				s.from = strings.Trim(line[10:], "<>")
+				tp.PrintfLine("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				s.to = append(s.to, strings.Trim(line[8:], "<>"))
+				tp.PrintfLine("250 OK")
+			case cmd == "DATA":
+				tp.PrintfLine("354 Go ahead")
+				data, _ := tp.ReadDotBytes()
+				s.data = string(data)
+				tp.PrintfLine("250 OK")
+			case cmd == "QUIT":
+				tp.PrintfLine("221 Bye")
+				return
+			default:
+				tp.PrintfLine("250 OK")
+			}
+		}
+	}()
+
+	return s
+}
+
+func TestMailSenderSMTP_Send(t *testing.T) {
+	mock := newSMTPMock(t)
+
+	cfg := model.NewSMTP()
+	cfg.Server = mock.addr
+	cfg.From = "orders@example.com"
+	cfg.Username = "orders@example.com"
+	cfg.Password = "password"
+
+	sender := NewMailSenderSMTP(cfg, NewLog("", false))
+
+	msg := "Subject: Hello\r\n\r\nHello world\r\n"
+	if err := sender.Send("orders@example.com", []string{"john@example.com"}, []byte(msg)); err != nil {
+		t.Fatal("Error on send email err:", err.Error())
+	}
+
+	<-mock.done
+
+	if mock.from != "orders@example.com" {
+		t.Fatal("Expected from orders@example.com result:", mock.from)
+	}
+
+	if len(mock.to) != 1 || mock.to[0] != "john@example.com" {
+		t.Fatal("Expected to john@example.com result:", mock.to)
+	}
+
+	if mock.data != "Subject: Hello\n\nHello world\n" {
+		t.Fatalf("Expected message %q result:%q", msg, mock.data)
+	}
+}
@@ -0,0 +1,444 @@
+package mmail
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	spacesRegex = regexp.MustCompile(`[ \t\r\n\f]+`)
+	urlReplacer = strings.NewReplacer(" ", "%20", "\n", "", "\r", "", "\t", "", "(", "%28", ")", "%29")
+)
+
+// htmlToMarkdown converts the HTML of an email in Mattermost markdown keeping links, bold,
+// italic, lists and simple tables
+func htmlToMarkdown(s string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return "", errors.Wrap(err, "parse html")
+	}
+
+	w := &markdownWriter{lineStart: true}
+	w.children(doc)
+
+	lines := strings.Split(w.buf.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// linePrefix is written at start of each line of a block, first is used in the first line
+// ex: list item "- " and "  "
+type linePrefix struct {
+	first string
+	rest  string
+	used  bool
+}
+
+// markdownWriter writes the markdown collapsing the spaces and the blank lines of HTML
+type markdownWriter struct {
+	buf       strings.Builder
+	prefixes  []*linePrefix
+	newlines  int  // newlines written before the next content
+	space     bool // space written before the next content
+	lineStart bool // next content starts a line
+	lead      bool // content started with space
+	lists     int  // depth of lists, paragraphs in lists are not separated by blank lines
+	pre       int  // depth of preformatted text
+}
+
+// children converts the children of n
+func (w *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// node converts n and its children
+func (w *markdownWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.DocumentNode:
+		w.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template:
+
+	case atom.Br:
+		if w.buf.Len() > 0 {
+			w.newlines++
+		}
+
+	case atom.P:
+		w.paragraph()
+		w.children(n)
+		w.paragraph()
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		w.paragraph()
+		if s, _, _ := w.inline(n); s != "" {
+			w.write(strings.Repeat("#", level) + " " + s)
+		}
+		w.paragraph()
+
+	case atom.B, atom.Strong:
+		w.emphasis(n, "**")
+
+	case atom.I, atom.Em:
+		w.emphasis(n, "*")
+
+	case atom.S, atom.Strike, atom.Del:
+		w.emphasis(n, "~~")
+
+	case atom.Code, atom.Kbd, atom.Tt:
+		if w.pre > 0 {
+			w.children(n)
+		} else {
+			w.emphasis(n, "`")
+		}
+
+	case atom.A:
+		w.link(n)
+
+	case atom.Img:
+		if alt := attr(n, "alt"); alt != "" {
+			w.text(alt)
+		}
+
+	case atom.Ul, atom.Ol:
+		w.list(n)
+
+	case atom.Li:
+		// item without list
+		w.line()
+		w.children(n)
+		w.line()
+
+	case atom.Blockquote:
+		w.paragraph()
+		w.prefixes = append(w.prefixes, &linePrefix{first: "> ", rest: "> "})
+		w.children(n)
+		w.prefixes = w.prefixes[:len(w.prefixes)-1]
+		w.paragraph()
+
+	case atom.Pre:
+		w.paragraph()
+		w.write("```")
+		w.newlines = 1
+		w.pre++
+		w.children(n)
+		w.pre--
+		w.newlines = 1
+		w.write("```")
+		w.paragraph()
+
+	case atom.Hr:
+		w.paragraph()
+		w.write("---")
+		w.paragraph()
+
+	case atom.Table:
+		w.table(n)
+
+	case atom.Div, atom.Tr, atom.Dt, atom.Dd, atom.Address, atom.Center, atom.Section,
+		atom.Article, atom.Header, atom.Footer, atom.Form, atom.Fieldset:
+		w.line()
+		w.children(n)
+		w.line()
+
+	default:
+		w.children(n)
+	}
+}
+
+// text writes a text node collapsing the spaces
+func (w *markdownWriter) text(s string) {
+	if w.pre > 0 {
+		w.space = false
+		for i, l := range strings.Split(s, "\n") {
+			if i > 0 {
+				w.newlines++
+			}
+			if l != "" {
+				w.write(l)
+			}
+		}
+		return
+	}
+
+	s = spacesRegex.ReplaceAllString(strings.Replace(s, "\u00a0", " ", -1), " ")
+	if s == "" {
+		return
+	}
+
+	if s[0] == ' ' {
+		w.space = true
+		if w.buf.Len() == 0 {
+			w.lead = true
+		}
+	}
+
+	if t := strings.TrimSpace(s); t != "" {
+		w.write(t)
+		w.space = s[len(s)-1] == ' '
+	}
+}
+
+// write writes s after the pending newlines and space
+func (w *markdownWriter) write(s string) {
+	if w.newlines > 0 && w.buf.Len() > 0 {
+		if w.newlines > 2 {
+			w.newlines = 2
+		}
+
+		w.buf.WriteString("\n")
+		for i := 1; i < w.newlines; i++ {
+			// blank line before the first line of a block belongs to the parent
+			for _, p := range w.prefixes {
+				if p.used {
+					w.buf.WriteString(strings.TrimRight(p.rest, " "))
+				}
+			}
+			w.buf.WriteString("\n")
+		}
+		w.lineStart = true
+	}
+	w.newlines = 0
+
+	if w.lineStart {
+		for _, p := range w.prefixes {
+			if p.used {
+				w.buf.WriteString(p.rest)
+			} else {
+				w.buf.WriteString(p.first)
+				p.used = true
+			}
+		}
+		w.lineStart = false
+	} else if w.space {
+		w.buf.WriteString(" ")
+	}
+	w.space = false
+
+	w.buf.WriteString(s)
+}
+
+// paragraph starts a new paragraph, inside lists it starts a new line
+func (w *markdownWriter) paragraph() {
+	if w.lists > 0 {
+		w.line()
+		return
+	}
+	if w.buf.Len() > 0 && w.newlines < 2 {
+		w.newlines = 2
+	}
+}
+
+// line starts a new line
+func (w *markdownWriter) line() {
+	if w.buf.Len() > 0 && w.newlines < 1 {
+		w.newlines = 1
+	}
+}
+
+// inline converts the children of n in a single line, lead and trail inform if the content
+// starts or ends with space
+func (w *markdownWriter) inline(n *html.Node) (s string, lead, trail bool) {
+	sub := &markdownWriter{lineStart: true, pre: w.pre}
+	sub.children(n)
+	s = strings.Join(strings.Fields(sub.buf.String()), " ")
+	return s, sub.lead || (s == "" && sub.space), sub.space
+}
+
+// emphasis writes the content of n between marker ex: **bold**
+func (w *markdownWriter) emphasis(n *html.Node, marker string) {
+	s, lead, trail := w.inline(n)
+	if lead {
+		w.space = true
+	}
+	if s == "" {
+		return
+	}
+
+	w.write(marker + s + marker)
+	w.space = trail
+}
+
+// link writes the link in markdown, the text is used when it is the address
+func (w *markdownWriter) link(n *html.Node) {
+	s, lead, trail := w.inline(n)
+	if lead {
+		w.space = true
+	}
+	if s == "" {
+		return
+	}
+
+	href := strings.TrimSpace(attr(n, "href"))
+	lower := strings.ToLower(href)
+	switch {
+	case href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:"):
+		w.write(s)
+	case href == s || lower == "mailto:"+strings.ToLower(s):
+		w.write(s)
+	default:
+		w.write("[" + s + "](" + urlReplacer.Replace(href) + ")")
+	}
+	w.space = trail
+}
+
+// list writes the items of ul and ol, ordered lists are numbered from start attribute
+func (w *markdownWriter) list(n *html.Node) {
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		number = start
+	}
+
+	w.paragraph()
+	w.lists++
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			w.node(c)
+			continue
+		}
+
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		w.line()
+		w.prefixes = append(w.prefixes, &linePrefix{first: marker, rest: strings.Repeat(" ", len(marker))})
+		w.children(c)
+		w.prefixes = w.prefixes[:len(w.prefixes)-1]
+		w.line()
+	}
+	w.lists--
+	w.paragraph()
+}
+
+// table writes a simple table as markdown table, tables used for layout have the cells
+// written as paragraphs
+func (w *markdownWriter) table(n *html.Node) {
+	rows := tableRows(n)
+
+	var cells [][]string
+	columns := 0
+	simple := len(rows) > 0
+	for _, r := range rows {
+		var row []string
+		for _, c := range r {
+			if findElement(c, atom.Table) || findElement(c, atom.Br) || findBlock(c) {
+				simple = false
+				break
+			}
+			s, _, _ := w.inline(c)
+			row = append(row, strings.Replace(s, "|", `\|`, -1))
+		}
+		if len(row) > columns {
+			columns = len(row)
+		}
+		cells = append(cells, row)
+	}
+
+	if !simple || columns < 2 {
+		for _, r := range rows {
+			for _, c := range r {
+				w.paragraph()
+				w.children(c)
+				w.paragraph()
+			}
+		}
+		return
+	}
+
+	w.paragraph()
+	for i, row := range cells {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+
+		w.line()
+		w.write("| " + strings.Join(row, " | ") + " |")
+		if i == 0 {
+			w.line()
+			w.write(strings.TrimSuffix(strings.Repeat("| --- ", columns), " ") + " |")
+		}
+	}
+	w.paragraph()
+}
+
+// tableRows returns the cells of each row of table, rows of nested tables are not included
+func tableRows(table *html.Node) [][]*html.Node {
+	var rows [][]*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			case atom.Tr:
+				var row []*html.Node
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						row = append(row, cell)
+					}
+				}
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	walk(table)
+	return rows
+}
+
+// findElement returns true if a descendant of n is the element a
+func findElement(n *html.Node, a atom.Atom) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a || findElement(c, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// findBlock returns true if a descendant of n is written in more than one line
+func findBlock(n *html.Node) bool {
+	for _, a := range []atom.Atom{atom.P, atom.Div, atom.Ul, atom.Ol, atom.Pre, atom.Blockquote, atom.Hr} {
+		if findElement(n, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// attr returns the value of attribute of n
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package mmail
+
+import "testing"
+
+func Test_htmlToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"paragraphs", "<p>First  line\n of text</p><p>Second</p>", "First line of text\n\nSecond"},
+		{"br", "<div>line1<br>line2</div><div><br></div><div>line3</div>", "line1\nline2\n\nline3"},
+		{"emphasis", "<p>a <b>bold </b>and<i> italic</i> <s>old</s></p>", "a **bold** and *italic* ~~old~~"},
+		{"empty emphasis", "<p>a<b> </b>b<i></i></p>", "a b"},
+		{"link", `Visit <a href="https://example.com/a b">our <b>site</b></a>.`, "Visit [our **site**](https://example.com/a%20b)."},
+		{"link address", `<a href="https://example.com">https://example.com</a> <a href="mailto:Jdoe@example.com">jdoe@example.com</a>`, "https://example.com jdoe@example.com"},
+		{"link anchor", `<a href="#top">top</a><a href="https://example.com"><img src="cid:logo"></a>`, "top"},
+		{"heading", "<h1>Title</h1><h3>Sub <i>title</i></h3>text", "# Title\n\n### Sub *title*\n\ntext"},
+		{"unordered list", "<p>Items:</p><ul><li>one</li><li><p>two</p></li></ul><p>end</p>", "Items:\n\n- one\n- two\n\nend"},
+		{"ordered list", `<ol start="3"><li>three</li><li>four<ul><li>a</li><li>b</li></ul></li><li>five</li></ol>`, "3. three\n4. four\n   - a\n   - b\n5. five"},
+		{"blockquote", "<p>reply</p><blockquote><p>quoted</p><p>text</p></blockquote>", "reply\n\n> quoted\n>\n> text"},
+		{"pre", "<pre><code>func main() {\n\treturn\n}</code></pre>", "```\nfunc main() {\n\treturn\n}\n```"},
+		{"code", "run <code>go test</code> now", "run `go test` now"},
+		{"table", "<table><tr><th>Item</th><th>Price</th></tr><tr><td>Pen|Blue</td><td>$1</td></tr><tr><td>Book</td></tr></table>",
+			"| Item | Price |\n| --- | --- |\n| Pen\\|Blue | $1 |\n| Book |  |"},
+		{"layout table", "<table><tr><td><p>Header</p></td></tr><tr><td>Hello</td><td><table><tr><td>inner</td></tr></table></td></tr></table>",
+			"Header\n\nHello\n\ninner"},
+		{"ignored", "<html><head><title>t</title><style>p {}</style></head><body><script>x()</script>text&nbsp;&amp; more</body></html>", "text & more"},
+		{"image", `<p><img src="cid:logo" alt="Logo"> text</p>`, "Logo text"},
+		{"hr", "<p>a</p><hr><p>b</p>", "a\n\n---\n\nb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := htmlToMarkdown(tt.html)
+			if err != nil {
+				t.Fatal("Error on convert html err:", err)
+			}
+			if got != tt.want {
+				t.Errorf("htmlToMarkdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package mmail
 
 import (
+	"fmt"
 	"io"
+	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -19,51 +22,263 @@ const (
 // MatterMail struct with configurations, loggers and Mattemost user
 type MatterMail struct {
 	cfg          *model.Profile
+	folder       string
 	log          Logger
 	mmProvider   MattermostProvider
 	mailProvider MailProvider
+	threads      ThreadCache
+	stats        Stats
+	reported     Stats                      // last stats written in the log
+	posted       map[string]map[string]bool // channels of emails partially posted by Message-ID
+}
+
+// Stats counts the emails handled by MatterMail
+type Stats struct {
+	Posted  uint64
+	Dropped uint64
+}
+
+// Stats returns the number of emails posted and dropped
+func (m *MatterMail) Stats() Stats {
+	return Stats{
+		Posted:  atomic.LoadUint64(&m.stats.Posted),
+		Dropped: atomic.LoadUint64(&m.stats.Dropped),
+	}
+}
+
+// logStats writes in the log the number of emails posted and dropped when it changed since the
+// last time
+func (m *MatterMail) logStats() {
+	stats := m.Stats()
+	if stats == m.reported {
+		return
+	}
+
+	m.reported = stats
+	m.log.Infof("Emails posted: %v dropped: %v\n", stats.Posted, stats.Dropped)
+}
+
+// permanentError error of an email that fails every time it is posted, ex: the email can not
+// be parsed or there is no channel to post
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// IsPermanentError returns true if posting the email again will fail with the same error, the
+// other errors are temporary like Mattermost unavailable
+func IsPermanentError(err error) bool {
+	for err != nil {
+		if _, ok := err.(*permanentError); ok {
+			return true
+		}
+
+		cause, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
 }
 
 // PostNetMail read net/mail.Message and post in Mattermost
 func (m *MatterMail) PostNetMail(mailReader io.Reader) error {
 	mMsg, err := ReadMailMessage(mailReader)
 	if err != nil {
-		return errors.Wrap(err, "parse mail message")
+		return &permanentError{errors.Wrap(err, "parse mail message")}
 	}
 
+	mMsg.Folder = m.folder
 	return m.PostMailMessage(mMsg)
 }
 
 // PostMailMessage MailMessage in Mattermost
 func (m *MatterMail) PostMailMessage(msg *MailMessage) error {
+	if rule := dropRule(m.cfg, msg); rule != "" {
+		dropped := atomic.AddUint64(&m.stats.Dropped, 1)
+		m.log.Infof("Drop email from %v subject '%v' matched filter rule '%v', emails dropped: %v\n", msg.From, msg.Subject, rule, dropped)
+		return nil
+	}
+
+	// the session is kept between messages
 	if err := m.mmProvider.Login(); err != nil {
 		return errors.Wrap(err, "login on Mattermost to post mail message")
 	}
 
-	defer func() {
-		if err := m.mmProvider.Logout(); err != nil {
-			m.log.Error("Logout error err:", err)
-		}
-	}()
-
 	m.log.Info("Post new message")
 
-	mP, err := createMattermostPost(msg, m.cfg, m.log, m.mmProvider.GetChannelID)
+	mP, err := createMattermostPost(msg, m.cfg, m.mmProvider.Limits(), m.log, m.mmProvider.GetChannelID)
 
 	if err != nil {
 		return errors.Wrap(err, "create mattermost post")
 	}
 
+	var failed []string
 	for name, id := range mP.channelMap {
+		if m.alreadyPosted(msg, id) {
+			m.log.Debugf("Email already posted in %v", name)
+			continue
+		}
+
 		m.log.Debugf("Post email in %v", name)
-		if err := m.mmProvider.PostMessage(mP.message, id, mP.attachments); err != nil {
-			return errors.Wrap(err, "post message on mattermost")
+
+		rootID := m.findRootID(msg, id)
+		postID, err := m.mmProvider.PostMessage(mP.messages[name], id, rootID, mP.attachments)
+		if err != nil && rootID != "" {
+			// the root post could be removed, try to post without thread
+			m.log.Errorf("Error on post reply in thread %v, post as new message err:%v\n", rootID, err)
+			rootID = ""
+			postID, err = m.mmProvider.PostMessage(mP.messages[name], id, rootID, mP.attachments)
+		}
+
+		if err != nil {
+			// the other channels are posted, only the channels that failed are posted again
+			m.log.Errorf("Error on post message in %v err:%v\n", name, err)
+			failed = append(failed, name)
+			continue
+		}
+
+		m.savePosted(msg, id)
+		m.saveThread(msg, id, rootID, postID)
+
+		// rest of a long email is posted as replies of the thread
+		threadID := rootID
+		if threadID == "" {
+			threadID = postID
+		}
+
+		// the root post exists, posting the email again would duplicate it, so a reply that fails
+		// is only logged
+		for i, reply := range mP.replies[name] {
+			if _, err := m.mmProvider.PostMessage(reply, id, threadID, nil); err != nil {
+				m.log.Errorf("Error on post part %v of %v of message in %v err:%v\n", i+2, len(mP.replies[name])+1, name, err)
+			}
 		}
 	}
 
+	if len(failed) > 0 {
+		return errors.Errorf("post message on mattermost in %v", strings.Join(failed, ", "))
+	}
+
+	delete(m.posted, msg.MessageID)
+	atomic.AddUint64(&m.stats.Posted, 1)
 	return nil
 }
 
+// alreadyPosted returns true if the email was posted in the channel by a previous try, the
+// thread cache keeps the channels posted by other processes like deliver
+func (m *MatterMail) alreadyPosted(msg *MailMessage, channelID string) bool {
+	if msg.MessageID == "" {
+		return false
+	}
+
+	if m.posted[msg.MessageID][channelID] {
+		return true
+	}
+
+	if m.threads == nil {
+		return false
+	}
+
+	postID, err := m.threads.GetPostID(channelID, msg.MessageID)
+	if err != nil {
+		m.log.Error("Error on get thread from cache err:", err)
+		return false
+	}
+	return postID != ""
+}
+
+// savePosted stores the channel where the email was posted until it is posted in all channels
+func (m *MatterMail) savePosted(msg *MailMessage, channelID string) {
+	if msg.MessageID == "" {
+		return
+	}
+
+	if m.posted == nil {
+		m.posted = make(map[string]map[string]bool)
+	}
+
+	if m.posted[msg.MessageID] == nil {
+		m.posted[msg.MessageID] = make(map[string]bool)
+	}
+	m.posted[msg.MessageID][channelID] = true
+}
+
+// findRootID returns the post id of the thread where the email is a reply or empty string
+func (m *MatterMail) findRootID(msg *MailMessage, channelID string) string {
+	if m.threads == nil || !*m.cfg.ThreadReplies {
+		return ""
+	}
+
+	// In-Reply-To and the most recent References first
+	ids := []string{msg.InReplyTo}
+	for i := len(msg.References) - 1; i >= 0; i-- {
+		ids = append(ids, msg.References[i])
+	}
+
+	for _, messageID := range ids {
+		if messageID == "" {
+			continue
+		}
+
+		rootID, err := m.threads.GetPostID(channelID, messageID)
+		if err != nil {
+			m.log.Error("Error on get thread from cache err:", err)
+			return ""
+		}
+
+		if rootID != "" {
+			m.log.Debugf("Post email as reply of %v\n", messageID)
+			return rootID
+		}
+	}
+
+	return ""
+}
+
+// saveThread stores the root of the thread where the email was posted
+// and the email used to send replies of the thread
+func (m *MatterMail) saveThread(msg *MailMessage, channelID, rootID, postID string) {
+	if m.threads == nil || msg.MessageID == "" || postID == "" {
+		return
+	}
+
+	if rootID == "" {
+		rootID = postID
+	}
+
+	if err := m.threads.SavePostID(channelID, msg.MessageID, rootID); err != nil {
+		m.log.Error("Error on save thread in cache err:", err)
+	}
+
+	references := msg.References
+	if len(references) == 0 && msg.InReplyTo != "" {
+		references = []string{msg.InReplyTo}
+	}
+
+	replyTo := msg.ReplyTo
+	if replyTo == "" {
+		replyTo = msg.From
+	}
+
+	email := &ThreadEmail{
+		MessageID:  msg.MessageID,
+		References: references,
+		Subject:    msg.Subject,
+		ReplyTo:    replyTo,
+	}
+
+	if err := m.threads.SaveEmail(rootID, email); err != nil {
+		m.log.Error("Error on save thread email in cache err:", err)
+	}
+}
+
 // Listen starts MatterMail server
 func (m *MatterMail) Listen() {
 	m.log.Debug("Debug mode on")
@@ -71,6 +286,12 @@ func (m *MatterMail) Listen() {
 
 	defer m.mailProvider.Terminate()
 
+	defer func() {
+		if err := m.mmProvider.Logout(); err != nil {
+			m.log.Error("Logout error err:", err)
+		}
+	}()
+
 	for {
 		if err := m.checkAndWait(); err != nil {
 			m.log.Debug(err.Error())
@@ -78,20 +299,32 @@ func (m *MatterMail) Listen() {
 			m.mailProvider.Terminate()
 			m.log.Infof("Try again in %vs", tryAgainTime)
 			time.Sleep(time.Second * tryAgainTime)
-		} else {
+		} else if m.polling() {
 			time.Sleep(time.Second * 2)
 		}
 	}
 }
 
+// polling returns false when the emails are pushed by the Receiver, the emails are handled
+// without waiting
+func (m *MatterMail) polling() bool {
+	_, push := m.mailProvider.(*MailProviderReceiver)
+	return !push
+}
+
 func (m *MatterMail) checkAndWait() error {
-	if err := m.mailProvider.CheckNewMessage(m.PostNetMail); err != nil {
+	err := m.mailProvider.CheckNewMessage(m.PostNetMail)
+	m.logStats()
+
+	if err != nil {
 		m.log.Error("MatterMail.InitMatterMail Error on check new messsage:", err.Error())
 		m.mailProvider.Terminate()
 		return errors.Wrap(err, "check new message")
 	}
 
-	time.Sleep(time.Second * 2)
+	if m.polling() {
+		time.Sleep(time.Second * 2)
+	}
 
 	if err := m.mailProvider.WaitNewMessage(waitMessageTimeout); err != nil {
 		m.log.Error("MatterMail.InitMatterMail Error on wait new message:", err.Error())
@@ -101,13 +334,15 @@ func (m *MatterMail) checkAndWait() error {
 	return nil
 }
 
-// NewMatterMail creates a new MatterMail instance
-func NewMatterMail(cfg *model.Profile, log Logger, mailProvider MailProvider, mmProvider MattermostProvider) *MatterMail {
+// NewMatterMail creates a new MatterMail instance to post emails from the folder
+func NewMatterMail(cfg *model.Profile, folder string, log Logger, mailProvider MailProvider, mmProvider MattermostProvider, threads ThreadCache) *MatterMail {
 	return &MatterMail{
 		cfg:          cfg,
+		folder:       folder,
 		log:          log,
 		mailProvider: mailProvider,
 		mmProvider:   mmProvider,
+		threads:      threads,
 	}
 }
 
@@ -116,116 +351,291 @@ type channelMap map[string]string
 
 type mattermostPost struct {
 	channelMap  channelMap
-	message     string
+	messages    map[string]string   // map[channel name] = message
+	replies     map[string][]string // map[channel name] = rest of a long message
 	attachments []*Attachment
 }
 
-func createMattermostPost(msg *MailMessage, cfg *model.Profile, log Logger, getChannelID func(string) string) (*mattermostPost, error) {
+// createMattermostPost formats the email for each channel, the errors that fail every time the
+// email is posted are permanentError
+func createMattermostPost(msg *MailMessage, cfg *model.Profile, limits *PostLimits, log Logger, getChannelID func(string) (string, error)) (*mattermostPost, error) {
 	mP := &mattermostPost{}
 
+	var (
+		rule *model.Rule
+		err  error
+	)
+	if mP.channelMap, rule, err = chooseChannel(cfg, msg, log, getChannelID); err != nil {
+		return nil, errors.Wrap(err, "choose channel")
+	}
+
+	if mP.channelMap == nil {
+		return nil, &permanentError{errors.New("Did not find any channel to post")}
+	}
+
+	settings := cfg.PostSettings(rule)
+
+	fulltext := msg.EmailText
+	if cfg.MessageSource != nil {
+		fulltext = msg.postText(*cfg.MessageSource)
+	}
+
+	// preview only the new content of the email
+	text := fulltext
+	if cfg.Strip != nil {
+		text = cfg.Strip.Apply(fulltext)
+	}
+
 	// read only some lines of text
-	partmessage := readLines(msg.EmailText, *cfg.LinesToPreview)
+	partmessage := readLines(text, settings.LinesToPreview)
 
 	postedfullmessage := false
 
-	if partmessage != msg.EmailText && len(partmessage) > 0 {
+	if partmessage != text && len(partmessage) > 0 {
 		partmessage += " ..."
-	} else if partmessage == msg.EmailText {
+	} else if partmessage == text && text == fulltext {
 		postedfullmessage = true
 	}
 
-	// Apply MailTemplate to format message
-	var err error
-	mP.message, err = cfg.FormatMailTemplate(msg.From, msg.Subject, partmessage)
-	if err != nil {
-		return nil, errors.Wrap(err, "format Mail Template")
-	}
+	// Apply MailTemplate to format message of each channel
+	messages := make(map[string]string, len(mP.channelMap))
+	for name := range mP.channelMap {
+		data := msg.templateData(partmessage, name, rule)
+		if cfg.Sanitize != nil {
+			cfg.Sanitize.Apply(data)
+		}
 
-	// Mattermost post limit
-	if utf8.RuneCountInString(mP.message) > maxMattermostPostSize {
-		mP.message = string([]rune(mP.message)[:(maxMattermostPostSize-5)]) + " ..."
-		postedfullmessage = false
-		log.Info("Email has been cut because is larger than 4000 characters")
-	}
+		message, err := settings.FormatMailTemplate(data)
+		if err != nil {
+			return nil, &permanentError{errors.Wrap(err, "format Mail Template")}
+		}
 
-	mP.channelMap = chooseChannel(cfg, msg, log, getChannelID)
+		// message will be cut by Mattermost post limit
+		if !*cfg.SplitLongMessages && utf8.RuneCountInString(message) > limits.MaxPostSize {
+			postedfullmessage = false
+		}
 
-	if mP.channelMap == nil {
-		return nil, errors.New("Did not find any channel to post")
+		messages[name] = message
 	}
 
 	// Attachments
-	if !*cfg.Attachment {
-		return mP, nil
+	var note string
+	if settings.Attachment {
+		mP.attachments, note = selectAttachments(msg, postedfullmessage, cfg.InlineImages, limits, log)
+	}
+
+	mP.messages = make(map[string]string, len(messages))
+	mP.replies = make(map[string][]string)
+	for name, message := range messages {
+		mP.messages[name], mP.replies[name] = limitMessage(message, note, limits.MaxPostSize, *cfg.SplitLongMessages, log)
+	}
+
+	return mP, nil
+}
+
+// selectAttachments returns the attachments posted with the email and a note informing the files
+// skipped because they are larger than the file size limit of server, images can be nil
+func selectAttachments(msg *MailMessage, postedfullmessage bool, images *model.InlineImages, limits *PostLimits, log Logger) ([]*Attachment, string) {
+	var candidates []*Attachment
+
+	// inline images uploaded as files, the images out of the limits stay embedded in email.html
+	uploaded := make(map[*InlinePart]bool)
+	if msg.EmailType == EmailTypeHTML && images != nil && images.Upload != nil && *images.Upload {
+		slots := limits.MaxAttachments - 1 - len(msg.Attachments)
+		for _, p := range msg.inlineImages(images, log) {
+			if len(uploaded) >= slots {
+				break
+			}
+			if limits.MaxFileSize > 0 && int64(len(p.Content)) > limits.MaxFileSize {
+				continue
+			}
+			uploaded[p] = true
+		}
 	}
 
 	// Post original email
 	if msg.EmailType == EmailTypeHTML {
-		mP.attachments = append(mP.attachments, &Attachment{
+		candidates = append(candidates, &Attachment{
 			Filename: "email.html",
-			Content:  []byte(msg.EmailBody),
+			Content:  []byte(msg.htmlBody(uploaded)),
 		})
 	} else if !postedfullmessage {
-		mP.attachments = append(mP.attachments, &Attachment{
+		candidates = append(candidates, &Attachment{
 			Filename: "email.txt",
 			Content:  []byte(msg.EmailBody),
 		})
 	}
 
-	// Attachments
-	for _, a := range msg.Attachments {
-		if len(mP.attachments) >= maxMattermostAttachments {
-			log.Debugf("Max number of attachments '%v'\n", maxMattermostAttachments)
+	candidates = append(candidates, msg.Attachments...)
+
+	for _, p := range msg.Inlines {
+		if uploaded[p] {
+			candidates = append(candidates, &p.Attachment)
+		}
+	}
+
+	var attachments []*Attachment
+	var skipped []string
+	for _, a := range candidates {
+		if limits.MaxFileSize > 0 && int64(len(a.Content)) > limits.MaxFileSize {
+			log.Infof("Attachment '%v' with %v is larger than the file size limit of server\n", a.Filename, model.HumanSize(len(a.Content)))
+			skipped = append(skipped, fmt.Sprintf("%v (%v)", a.Filename, model.HumanSize(len(a.Content))))
+			continue
+		}
+
+		if len(attachments) >= limits.MaxAttachments {
+			log.Debugf("Max number of attachments '%v'\n", limits.MaxAttachments)
 			break
 		}
-		mP.attachments = append(mP.attachments, a)
+		attachments = append(attachments, a)
 	}
 
-	return mP, nil
+	if len(skipped) == 0 {
+		return attachments, ""
+	}
+
+	note := fmt.Sprintf("\n\n_Attachments larger than %v were not posted: %v_", model.HumanSize(int(limits.MaxFileSize)), strings.Join(skipped, ", "))
+	return attachments, note
 }
 
-func validateChannelNames(channelNames []string, getChannelID func(string) string) channelMap {
+// limitMessage appends the note to message and applies the post size limit of server, when split
+// is true the rest of message is returned as replies otherwise the message is cut keeping the note
+func limitMessage(message, note string, limit int, split bool, log Logger) (string, []string) {
+	if utf8.RuneCountInString(message+note) <= limit {
+		return message + note, nil
+	}
+
+	if split {
+		parts := splitMessage(message+note, limit)
+		log.Infof("Email has been split in %v posts because is larger than %v characters\n", len(parts), limit)
+		return parts[0], parts[1:]
+	}
+
+	log.Infof("Email has been cut because is larger than %v characters\n", limit)
+
+	text := []rune(message)
+	size := limit - 5 - utf8.RuneCountInString(note)
+	if size < 0 {
+		size = 0
+	}
+	if size > len(text) {
+		size = len(text)
+	}
+	return string(text[:size]) + " ..." + note, nil
+}
+
+// validateChannelNames returns the channels that exist, names of the same channel are posted once
+func validateChannelNames(channelNames []string, getChannelID func(string) (string, error)) (channelMap, error) {
 	channels := make(channelMap)
-	gotOne := false
+	ids := make(map[string]bool)
 	for _, v := range channelNames {
-		if id := getChannelID(v); id != "" {
-			gotOne = true
+		if _, ok := channels[v]; ok {
+			continue
+		}
+
+		id, err := getChannelID(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get id of channel %v", v)
+		}
+
+		if id != "" && !ids[id] {
+			ids[id] = true
 			channels[v] = id
 		}
 	}
 
-	if !gotOne {
+	if len(channels) == 0 {
+		return nil, nil
+	}
+
+	return channels, nil
+}
+
+// matchRule returns the first filter rule that matches the email, its settings override the profile
+func matchRule(cfg *model.Profile, msg *MailMessage) *model.Rule {
+	if cfg.Filter == nil {
 		return nil
 	}
+	return cfg.Filter.MatchRule(msg.filterMessage())
+}
+
+// dropRule returns the name of the filter rule that discards the email or empty
+func dropRule(cfg *model.Profile, msg *MailMessage) string {
+	if cfg.Filter == nil {
+		return ""
+	}
 
-	return channels
+	fanOut := cfg.Routing != nil && *cfg.Routing == model.RoutingAll
+	_, dropped := cfg.Filter.Match(msg.filterMessage(), fanOut)
+	return dropped
 }
 
-func chooseChannel(cfg *model.Profile, msg *MailMessage, log Logger, getChannelID func(string) string) channelMap {
-	var chMap channelMap
+// chooseChannel returns the channels where the email is posted or nil and the filter rule that
+// chose them, nil when the channels came from the subject or the default channels. An error is
+// returned when a channel could not be looked up because the choice could be other
+func chooseChannel(cfg *model.Profile, msg *MailMessage, log Logger, getChannelID func(string) (string, error)) (channelMap, *model.Rule, error) {
+	var (
+		chMap channelMap
+		err   error
+	)
+
+	if cfg.Routing != nil && *cfg.Routing == model.RoutingAll {
+		var names []string
+
+		if *cfg.RedirectBySubject {
+			names = getChannelsFromSubject(msg.Subject)
+		}
+
+		if cfg.Filter != nil {
+			names = append(names, cfg.Filter.MatchChannels(msg.filterMessage(), true)...)
+		}
+
+		log.Debugf("Look for channels/users of subject and all filters '%v'\n", names)
+		if chMap, err = validateChannelNames(names, getChannelID); chMap != nil || err != nil {
+			return chMap, ruleOfChannels(matchRule(cfg, msg), chMap), err
+		}
+	} else {
+		// Try to discovery the channel
+		// redirect email by the subject
+		if *cfg.RedirectBySubject {
+			log.Debug("Try to find channel/user by subject")
+			if chMap, err = validateChannelNames(getChannelsFromSubject(msg.Subject), getChannelID); chMap != nil || err != nil {
+				return chMap, nil, err
+			}
+		}
 
-	// Try to discovery the channel
-	// redirect email by the subject
-	if *cfg.RedirectBySubject {
-		log.Debug("Try to find channel/user by subject")
-		if chMap = validateChannelNames(getChannelsFromSubject(msg.Subject), getChannelID); chMap != nil {
-			return chMap
+		// check filters
+		if cfg.Filter != nil {
+			log.Debug("Did not find channel/user from Email Subject. Look for filter")
+			if chMap, err = validateChannelNames(cfg.Filter.MatchChannels(msg.filterMessage(), false), getChannelID); chMap != nil || err != nil {
+				return chMap, matchRule(cfg, msg), err
+			}
 		}
 	}
 
-	// check filters
-	if cfg.Filter != nil {
-		log.Debug("Did not find channel/user from Email Subject. Look for filter")
-		if chMap = validateChannelNames(cfg.Filter.GetChannels(msg.From, msg.Subject), getChannelID); chMap != nil {
-			return chMap
+	// get default Channel config of folder or profile
+	channels := cfg.Channels
+	if cfg.Email != nil {
+		if f := cfg.Email.GetFolder(msg.Folder); f != nil && len(f.Channels) > 0 {
+			channels = f.Channels
 		}
 	}
 
-	// get default Channel config
-	log.Debugf("Did not find channel/user in filters. Look for channel '%v'\n", cfg.Channels)
-	if chMap = validateChannelNames(cfg.Channels, getChannelID); chMap != nil {
-		return chMap
+	log.Debugf("Did not find channel/user in filters. Look for channel '%v'\n", channels)
+	chMap, err = validateChannelNames(channels, getChannelID)
+	return chMap, nil, err
+}
+
+// ruleOfChannels returns the rule when at least one of its channels is posted or nil
+func ruleOfChannels(rule *model.Rule, chMap channelMap) *model.Rule {
+	if rule == nil {
+		return nil
 	}
 
+	for _, channel := range rule.Channels {
+		if _, ok := chMap[channel]; ok {
+			return rule
+		}
+	}
 	return nil
 }
@@ -1,12 +1,22 @@
 package mmail
 
 import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/mail"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
+	"github.com/pkg/errors"
 	"github.com/rodcorsi/mattermail/model"
 )
 
+var defaultLimits = &PostLimits{MaxPostSize: maxMattermostPostSize, MaxAttachments: maxMattermostAttachments}
+
 func TestCreateMattermostPost(t *testing.T) {
 	cfg := model.NewProfile()
 	cfg.Name = "test"
@@ -16,8 +26,8 @@ func TestCreateMattermostPost(t *testing.T) {
 
 	log := NewLog("test", false)
 
-	getChannelID := func(channelName string) string {
-		return channelName
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
 	}
 
 	msg := &MailMessage{
@@ -31,7 +41,7 @@ func TestCreateMattermostPost(t *testing.T) {
 		}},
 	}
 
-	mP, err := createMattermostPost(msg, cfg, log, getChannelID)
+	mP, err := createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
 
 	if err != nil {
 		t.Fatalf("error on create mattermostPost %v", err)
@@ -41,8 +51,8 @@ func TestCreateMattermostPost(t *testing.T) {
 		t.Fatalf("expected #channel1 result:'%v'", mP.channelMap)
 	}
 
-	if mP.message != "jdoe@example.com|Subject|line one ..." {
-		t.Fatalf("expected 'jdoe@example.com|Subject|line one ...' result:'%v'", mP.message)
+	if mP.messages["#channel1"] != "jdoe@example.com|Subject|line one ..." {
+		t.Fatalf("expected 'jdoe@example.com|Subject|line one ...' result:'%v'", mP.messages)
 	}
 
 	if len(mP.attachments) != 2 {
@@ -52,7 +62,7 @@ func TestCreateMattermostPost(t *testing.T) {
 	// Subject
 	*cfg.LinesToPreview = 10
 	msg.Subject = "[@user2] subject 2"
-	mP, err = createMattermostPost(msg, cfg, log, getChannelID)
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
 	if err != nil {
 		t.Fatalf("error on create mattermostPost %v", err)
 	}
@@ -61,8 +71,9 @@ func TestCreateMattermostPost(t *testing.T) {
 		t.Fatalf("expected @user2 result:'%v'", mP.channelMap)
 	}
 
-	if mP.message != "jdoe@example.com|[@user2] subject 2|line one\nline two" {
-		t.Fatalf("expected 'jdoe@example.com|[@user2] subject 2|line one\nline two' result:'%v'", mP.message)
+	// the mention of subject is neutralized
+	if mP.messages["@user2"] != "jdoe@example.com|[@\u200buser2] subject 2|line one\nline two" {
+		t.Fatalf("expected 'jdoe@example.com|[@\u200buser2] subject 2|line one\nline two' result:'%v'", mP.messages)
 	}
 
 	if len(mP.attachments) != 1 {
@@ -74,7 +85,7 @@ func TestCreateMattermostPost(t *testing.T) {
 	msg.EmailType = EmailTypeHTML
 
 	cfg.Filter = &model.Filter{&model.Rule{From: "jdoe@example.com", Channels: []string{"#channel1", "#channel2"}}}
-	mP, err = createMattermostPost(msg, cfg, log, getChannelID)
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
 	if err != nil {
 		t.Fatalf("error on create mattermostPost %v", err)
 	}
@@ -90,16 +101,461 @@ func TestCreateMattermostPost(t *testing.T) {
 	if len(mP.attachments) != 2 {
 		t.Fatalf("expected 2 attachments found %v", len(mP.attachments))
 	}
+
+	// Folder
+	cfg.Filter = nil
+	cfg.Email.Folders = []*model.Folder{{Name: "INBOX"}, {Name: "Alerts", Channels: []string{"#alerts"}}}
+	*cfg.MailTemplate = "{{.Folder}}|{{.Subject}}"
+	msg.Folder = "Alerts"
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if _, ok := mP.channelMap["#alerts"]; !ok || len(mP.channelMap) != 1 {
+		t.Fatalf("expected #alerts result:'%v'", mP.channelMap)
+	}
+
+	if mP.messages["#alerts"] != "Alerts|Subject" {
+		t.Fatalf("expected 'Alerts|Subject' result:'%v'", mP.messages)
+	}
+
+	msg.Folder = "INBOX"
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if _, ok := mP.channelMap["#channel1"]; !ok || len(mP.channelMap) != 1 {
+		t.Fatalf("expected #channel1 result:'%v'", mP.channelMap)
+	}
 }
 
 type mattermostMock struct{}
 
-func (m *mattermostMock) Login() error                           { return nil }
-func (m *mattermostMock) Logout() error                          { return nil }
-func (m *mattermostMock) GetChannelID(channelName string) string { return "id1234" }
+func (m *mattermostMock) Login() error                                    { return nil }
+func (m *mattermostMock) Logout() error                                   { return nil }
+func (m *mattermostMock) GetChannelID(channelName string) (string, error) { return "id1234", nil }
+
+func (m *mattermostMock) WatchReplies(handler ReplyHandler) error { return nil }
+
+func (m *mattermostMock) Limits() *PostLimits { return defaultLimits }
+
+func (m *mattermostMock) PostMessage(message, channelID, rootID string, attachments []*Attachment) (string, error) {
+	return "post1234", nil
+}
+
+func TestCreateMattermostPostRuleSettings(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#mail"}
+	*cfg.MailTemplate = "{{.From}}|{{.Subject}}|{{.Message}}"
+
+	alert := &model.Rule{
+		From:           "monitor@",
+		Channels:       []string{"#alerts"},
+		MailTemplate:   new(string),
+		LinesToPreview: new(int),
+		Attachment:     new(bool),
+	}
+	*alert.MailTemplate = ":rotating_light: {{.Subject}}"
+	*alert.LinesToPreview = 1
+	cfg.Filter = &model.Filter{alert}
+
+	log := NewLog("test", false)
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	msg := &MailMessage{
+		From:      "monitor@example.com",
+		Subject:   "Disk usage 95%",
+		EmailText: "line one\nline two",
+		EmailBody: "<p>line one</p><p>line two</p>",
+		EmailType: EmailTypeHTML,
+	}
+
+	mP, err := createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if mP.messages["#alerts"] != ":rotating_light: Disk usage 95%" {
+		t.Fatalf("expected ':rotating_light: Disk usage 95%%' result:'%v'", mP.messages)
+	}
+
+	if len(mP.attachments) != 0 {
+		t.Fatalf("expected 0 attachments found %v", len(mP.attachments))
+	}
+
+	// email of people uses the settings of profile
+	msg.From = "jdoe@example.com"
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if mP.messages["#mail"] != "jdoe@example.com|Disk usage 95%|line one\nline two" {
+		t.Fatalf("expected full preview result:'%v'", mP.messages)
+	}
+
+	if len(mP.attachments) != 1 || mP.attachments[0].Filename != "email.html" {
+		t.Fatalf("expected email.html attachment found %v", mP.attachments)
+	}
+
+	// the rule matches but the channel came from subject
+	msg.From = "monitor@example.com"
+	msg.Subject = "[#ops] Disk usage 95%"
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if mP.messages["#ops"] != "monitor@example.com|[#ops] Disk usage 95%|line one\nline two" {
+		t.Fatalf("expected settings of profile result:'%v'", mP.messages)
+	}
+
+	// the channels of rule do not exist and the default channels are used
+	msg.Subject = "Disk usage 95%"
+	getChannelID = func(channelName string) (string, error) {
+		if channelName == "#alerts" {
+			return "", nil
+		}
+		return channelName, nil
+	}
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, log, getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
 
-func (m *mattermostMock) PostMessage(message, channelID string, attachments []*Attachment) error {
-	return nil
+	if mP.messages["#mail"] != "monitor@example.com|Disk usage 95%|line one\nline two" {
+		t.Fatalf("expected settings of profile result:'%v'", mP.messages)
+	}
+}
+
+func TestCreateMattermostPostChannelTemplate(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#channel1", "@user2"}
+	*cfg.MailTemplate = "{{.Channel}}|{{.To}}|{{len .Attachments}}"
+
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	msg := &MailMessage{
+		From:        "jdoe@example.com",
+		Subject:     "Subject",
+		To:          []*mail.Address{{Name: "Team", Address: "team@example.com"}},
+		EmailType:   EmailTypeText,
+		Attachments: []*Attachment{{Filename: "file1.txt", Content: []byte("text")}},
+	}
+
+	mP, err := createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	for _, name := range cfg.Channels {
+		if want := name + "|Team <team@example.com>|1"; mP.messages[name] != want {
+			t.Fatalf("expected '%v' result:'%v'", want, mP.messages[name])
+		}
+	}
+}
+
+func TestCreateMattermostPostSanitize(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#channel1"}
+	*cfg.MailTemplate = "{{.Subject}}|{{.Message}}"
+	*cfg.Sanitize.Links = true
+	cfg.Sanitize.AllowMentions = []string{"boss@example.com"}
+
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	msg := &MailMessage{
+		From:        "Spammer <spam@example.net>",
+		FromAddress: &mail.Address{Name: "Spammer", Address: "spam@example.net"},
+		Subject:     "Hi @all",
+		EmailText:   "visit https://example.net",
+		EmailType:   EmailTypeText,
+	}
+
+	mP, err := createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if want := "Hi @\u200ball|visit `https://example.net`"; mP.messages["#channel1"] != want {
+		t.Fatalf("expected '%v' result:'%v'", want, mP.messages["#channel1"])
+	}
+
+	msg.FromAddress = &mail.Address{Name: "Boss", Address: "boss@example.com"}
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if want := "Hi @all|visit `https://example.net`"; mP.messages["#channel1"] != want {
+		t.Fatalf("expected '%v' result:'%v'", want, mP.messages["#channel1"])
+	}
+}
+
+func TestCreateMattermostPostLimits(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#channel1"}
+	*cfg.MailTemplate = "{{.Message}}"
+
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	msg := &MailMessage{
+		Subject:   "Limits",
+		EmailText: strings.Repeat("a", 100),
+		EmailType: EmailTypeText,
+		Attachments: []*Attachment{
+			{Filename: "big.bin", Content: make([]byte, 2048)},
+			{Filename: "small.txt", Content: []byte("small")},
+		},
+	}
+
+	limits := &PostLimits{MaxPostSize: 200, MaxFileSize: 1024, MaxAttachments: 5}
+	mP, err := createMattermostPost(msg, cfg, limits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if len(mP.attachments) != 1 || mP.attachments[0].Filename != "small.txt" {
+		t.Fatalf("expected only small.txt attached result:%v", mP.attachments)
+	}
+
+	note := "\n\n_Attachments larger than 1.0 KB were not posted: big.bin (2.0 KB)_"
+	if want := strings.Repeat("a", 100) + note; mP.messages["#channel1"] != want {
+		t.Fatalf("expected '%v' result:'%v'", want, mP.messages["#channel1"])
+	}
+
+	// message cut keeping the note
+	limits.MaxPostSize = 80
+	mP, err = createMattermostPost(msg, cfg, limits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	message := mP.messages["#channel1"]
+	if utf8.RuneCountInString(message) > 80 || !strings.HasSuffix(message, " ..."+note) {
+		t.Fatalf("expected message cut with note result:'%v'", message)
+	}
+
+	if len(mP.attachments) != 2 || mP.attachments[0].Filename != "email.txt" {
+		t.Fatalf("expected email.txt and small.txt attached result:%v", mP.attachments)
+	}
+
+	limits.MaxAttachments = 1
+	mP, err = createMattermostPost(msg, cfg, limits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if len(mP.attachments) != 1 {
+		t.Fatalf("expected 1 attachment result:%v", len(mP.attachments))
+	}
+}
+
+func TestCreateMattermostPostStrip(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#channel1"}
+	*cfg.MailTemplate = "{{.Message}}"
+	*cfg.Strip.Quotes = true
+	*cfg.Strip.Signature = true
+	cfg.Strip.Disclaimers = []string{"confidentiality notice"}
+
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	tests := []struct {
+		eml    string
+		source string
+		want   string
+	}{
+		{"reply_gmail.eml", model.MessageSourceText, "The budget is approved.\n\nThanks,\nRodrigo"},
+		{"reply_gmail.eml", model.MessageSourceHTML, "The budget is approved.\n\nThanks,\nRodrigo"},
+		{"reply_outlook.eml", model.MessageSourceText, "Hi John,\n\nThe maintenance window is Saturday at 22:00.\n\nRegards,\nMary Smith\nIT Operations"},
+		{"reply_outlook.eml", model.MessageSourceHTML, "Hi John,\n\nThe maintenance window is Saturday at 22:00.\n\nRegards,\nMary Smith\nIT Operations"},
+		{"reply_thunderbird.eml", model.MessageSourceText, "The deploy finished without errors."},
+		{"reply_apple.eml", model.MessageSourceText, "Sounds good, see you there."},
+	}
+	for _, tt := range tests {
+		f, err := os.Open(findDir("emltest") + tt.eml)
+		if err != nil {
+			t.Fatal("Error on open eml:", err)
+		}
+
+		msg, err := ReadMailMessage(f)
+		f.Close()
+		if err != nil {
+			t.Fatal("Failed to parsing msg:", err)
+		}
+
+		*cfg.MessageSource = tt.source
+		mP, err := createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+		if err != nil {
+			t.Fatalf("error on create mattermostPost %v", err)
+		}
+
+		if mP.messages["#channel1"] != tt.want {
+			t.Errorf("%v %v expected '%v' result:'%v'", tt.eml, tt.source, tt.want, mP.messages["#channel1"])
+		}
+
+		// the full email is attached
+		if len(mP.attachments) == 0 {
+			t.Errorf("%v %v expected email attached", tt.eml, tt.source)
+		}
+	}
+}
+
+func TestCreateMattermostPostInlineImages(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#channel1"}
+
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	f, err := os.Open(findDir("emltest") + "thunderbird.eml")
+	if err != nil {
+		t.Fatal("Error on open thunderbird.eml:", err)
+	}
+	defer f.Close()
+
+	msg, err := ReadMailMessage(f)
+	if err != nil {
+		t.Fatal("Failed to parsing msg:", err)
+	}
+
+	// embedded by default
+	mP, err := createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if len(mP.attachments) != 2 || !strings.Contains(string(mP.attachments[0].Content), `src="data:image/png;base64,`) {
+		t.Fatalf("expected image embedded in email.html result:%v", mP.attachments)
+	}
+
+	// small image is not uploaded
+	*cfg.InlineImages.Upload = true
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if len(mP.attachments) != 2 || !strings.Contains(string(mP.attachments[0].Content), `src="data:image/png;base64,`) {
+		t.Fatalf("expected small image embedded in email.html result:%v", mP.attachments)
+	}
+
+	*cfg.InlineImages.MinSize = 0
+	*cfg.InlineImages.MinDimension = 20
+	mP, err = createMattermostPost(msg, cfg, defaultLimits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if len(mP.attachments) != 3 || mP.attachments[2].Filename != "icon.png" {
+		t.Fatalf("expected icon.png uploaded result:%v", mP.attachments)
+	}
+
+	if html := string(mP.attachments[0].Content); strings.Contains(html, "data:") || strings.Contains(html, "<img") || !strings.Contains(html, "[icon.png]") {
+		t.Fatal("expected image replaced by filename in email.html result:", html)
+	}
+
+	// no space left to upload
+	limits := &PostLimits{MaxPostSize: maxMattermostPostSize, MaxAttachments: 2}
+	mP, err = createMattermostPost(msg, cfg, limits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if len(mP.attachments) != 2 || !strings.Contains(string(mP.attachments[0].Content), "data:image/png") {
+		t.Fatalf("expected image embedded when it does not fit result:%v", mP.attachments)
+	}
+}
+
+func TestChooseChannelRouting(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#default"}
+	cfg.Filter = &model.Filter{
+		&model.Rule{From: "monitor@", Channels: []string{"#team", "#sec"}},
+		&model.Rule{Subject: "disk", Channels: []string{"#infra"}},
+		&model.Rule{From: "ghost@", Channels: []string{"#missing"}},
+	}
+
+	log := NewLog("test", false)
+
+	// #sec and #security are names of the same channel
+	ids := map[string]string{"#default": "d", "#team": "t", "#sec": "s", "#security": "s", "#infra": "i"}
+	getChannelID := func(channelName string) (string, error) {
+		return ids[channelName], nil
+	}
+
+	tests := []struct {
+		name     string
+		routing  string
+		from     string
+		subject  string
+		channels []string
+		rule     *model.Rule
+	}{
+		{"first uses subject", model.RoutingFirst, "monitor@example.com", "[#security] disk", []string{"#security"}, nil},
+		{"first uses first rule", model.RoutingFirst, "monitor@example.com", "disk", []string{"#team", "#sec"}, (*cfg.Filter)[0]},
+		{"first rule without channel uses default", model.RoutingFirst, "ghost@example.com", "lunch", []string{"#default"}, nil},
+		{"all joins subject and rules", model.RoutingAll, "monitor@example.com", "[#security] disk", []string{"#security", "#team", "#infra"}, (*cfg.Filter)[0]},
+		{"all joins rules", model.RoutingAll, "monitor@example.com", "disk", []string{"#team", "#sec", "#infra"}, (*cfg.Filter)[0]},
+		{"all without channel uses default", model.RoutingAll, "other@example.com", "[#unknown] lunch", []string{"#default"}, nil},
+		{"all rule without channel uses subject", model.RoutingAll, "ghost@example.com", "[#infra] lunch", []string{"#infra"}, nil},
+	}
+
+	for _, tt := range tests {
+		*cfg.Routing = tt.routing
+		chMap, rule, err := chooseChannel(cfg, &MailMessage{From: tt.from, Subject: tt.subject}, log, getChannelID)
+		if err != nil {
+			t.Fatalf("Test %v error on chooseChannel err:%v", tt.name, err)
+		}
+
+		if rule != tt.rule {
+			t.Fatalf("Test %v expected rule:%v result:%v", tt.name, tt.rule, rule)
+		}
+
+		if len(chMap) != len(tt.channels) {
+			t.Fatalf("Test %v expected channels:%v result:%v", tt.name, tt.channels, chMap)
+		}
+
+		for _, name := range tt.channels {
+			if _, ok := chMap[name]; !ok {
+				t.Fatalf("Test %v expected channels:%v result:%v", tt.name, tt.channels, chMap)
+			}
+		}
+	}
+
+	// the email is not posted in the default channel when the channel of subject was not looked up
+	lookupFails := func(channelName string) (string, error) {
+		if channelName == "#security" {
+			return "", errors.New("connection refused")
+		}
+		return ids[channelName], nil
+	}
+
+	*cfg.Routing = model.RoutingFirst
+	if chMap, _, err := chooseChannel(cfg, &MailMessage{From: "other@example.com", Subject: "[#security] disk"}, log, lookupFails); err == nil || chMap != nil {
+		t.Fatalf("Expected error on look up channel result:%v err:%v", chMap, err)
+	}
 }
 
 func TestMatterMail_PostNetMail(t *testing.T) {
@@ -112,9 +568,297 @@ func TestMatterMail_PostNetMail(t *testing.T) {
 	profile := model.NewProfile()
 	profile.Channels = []string{"#town-square"}
 
-	mm := NewMatterMail(profile, NewLog("", false), nil, &mattermostMock{})
+	mm := NewMatterMail(profile, MailBox, NewLog("", false), nil, &mattermostMock{}, nil)
 
 	if err := mm.PostNetMail(gmailbuf); err != nil {
 		t.Fatal("Error on PostNetMail err:", err.Error())
 	}
 }
+
+type mattermostDownMock struct {
+	mattermostMock
+}
+
+func (m *mattermostDownMock) Login() error { return errors.New("connection refused") }
+
+// mattermostLookupMock fails to look up the channels like Mattermost unavailable
+type mattermostLookupMock struct {
+	mattermostMock
+}
+
+func (m *mattermostLookupMock) GetChannelID(channelName string) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestIsPermanentError(t *testing.T) {
+	profile := model.NewProfile()
+	mm := NewMatterMail(profile, MailBox, NewLog("", false), nil, &mattermostMock{}, nil)
+
+	// no channel to post
+	err := mm.PostMailMessage(&MailMessage{Subject: "Hello"})
+	if err == nil || !IsPermanentError(errors.Wrap(err, "post")) {
+		t.Fatal("Expected permanent error without channel err:", err)
+	}
+
+	profile.Channels = []string{"#town-square"}
+	mm = NewMatterMail(profile, MailBox, NewLog("", false), nil, &mattermostDownMock{}, nil)
+
+	err = mm.PostMailMessage(&MailMessage{Subject: "Hello"})
+	if err == nil || IsPermanentError(err) {
+		t.Fatal("Expected temporary error with Mattermost unavailable err:", err)
+	}
+
+	mm = NewMatterMail(profile, MailBox, NewLog("", false), nil, &mattermostLookupMock{}, nil)
+
+	err = mm.PostMailMessage(&MailMessage{Subject: "Hello"})
+	if err == nil || IsPermanentError(err) {
+		t.Fatal("Expected temporary error on look up channel err:", err)
+	}
+
+	if IsPermanentError(nil) {
+		t.Fatal("Expected nil is not permanent error")
+	}
+}
+
+type mattermostThreadMock struct {
+	mattermostMock
+	posts    int
+	rootIDs  []string
+	messages []string
+	calls    int
+	fail     map[int]bool // calls of PostMessage that fail
+}
+
+func (m *mattermostThreadMock) PostMessage(message, channelID, rootID string, attachments []*Attachment) (string, error) {
+	m.calls++
+	if m.fail[m.calls] {
+		return "", errors.New("post failed")
+	}
+
+	m.posts++
+	m.rootIDs = append(m.rootIDs, rootID)
+	m.messages = append(m.messages, message)
+	return fmt.Sprintf("post%v", m.posts), nil
+}
+
+// mattermostChannelsMock uses the channel name as id and fails to post in the channels of fail
+type mattermostChannelsMock struct {
+	mattermostMock
+	posts map[string]int
+	fail  map[string]bool
+}
+
+func (m *mattermostChannelsMock) GetChannelID(channelName string) (string, error) {
+	return channelName, nil
+}
+
+func (m *mattermostChannelsMock) PostMessage(message, channelID, rootID string, attachments []*Attachment) (string, error) {
+	if m.fail[channelID] {
+		return "", errors.New("post failed")
+	}
+
+	m.posts[channelID]++
+	return "post" + channelID, nil
+}
+
+func TestMatterMail_PostMailMessageChannelFails(t *testing.T) {
+	profile := model.NewProfile()
+	profile.Channels = []string{"#orders", "#sales", "#billing"}
+
+	mock := &mattermostChannelsMock{posts: map[string]int{}, fail: map[string]bool{"#sales": true}}
+	mm := NewMatterMail(profile, MailBox, NewLog("", false), nil, mock, nil)
+
+	msg := &MailMessage{MessageID: "1@example.com", Subject: "Order"}
+	if err := mm.PostMailMessage(msg); err == nil || !strings.Contains(err.Error(), "#sales") || IsPermanentError(err) {
+		t.Fatal("Expected temporary error on post in #sales err:", err)
+	}
+
+	if !reflect.DeepEqual(mock.posts, map[string]int{"#orders": 1, "#billing": 1}) {
+		t.Fatal("Expected posts in the other channels result:", mock.posts)
+	}
+
+	// the email is posted again only in the channel that failed
+	mock.fail = nil
+	if err := mm.PostMailMessage(msg); err != nil {
+		t.Fatal("Error on PostMailMessage err:", err)
+	}
+
+	if !reflect.DeepEqual(mock.posts, map[string]int{"#orders": 1, "#sales": 1, "#billing": 1}) {
+		t.Fatal("Expected one post in each channel result:", mock.posts)
+	}
+
+	if len(mm.posted) != 0 {
+		t.Fatal("Expected channels posted removed after all channels result:", mm.posted)
+	}
+
+	// other process, the channels posted are in the thread cache
+	threads := &threadCacheMem{}
+	threads.SavePostID("#orders", "2@example.com", "post#orders")
+	mm = NewMatterMail(profile, MailBox, NewLog("", false), nil, mock, threads)
+
+	if err := mm.PostMailMessage(&MailMessage{MessageID: "2@example.com", Subject: "Order"}); err != nil {
+		t.Fatal("Error on PostMailMessage err:", err)
+	}
+
+	if !reflect.DeepEqual(mock.posts, map[string]int{"#orders": 1, "#sales": 2, "#billing": 2}) {
+		t.Fatal("Expected posts only in channels not in cache result:", mock.posts)
+	}
+}
+
+func TestMatterMail_PostMailMessageThread(t *testing.T) {
+	profile := model.NewProfile()
+	profile.Channels = []string{"#town-square"}
+
+	mock := &mattermostThreadMock{}
+	mm := NewMatterMail(profile, MailBox, NewLog("", false), nil, mock, &threadCacheMem{})
+
+	post := func(msg *MailMessage, expectedRootID string) {
+		if err := mm.PostMailMessage(msg); err != nil {
+			t.Fatal("Error on PostMailMessage err:", err.Error())
+		}
+
+		if rootID := mock.rootIDs[len(mock.rootIDs)-1]; rootID != expectedRootID {
+			t.Fatalf("Expected rootID '%v' result:'%v'", expectedRootID, rootID)
+		}
+	}
+
+	post(&MailMessage{MessageID: "1@example.com"}, "")
+	post(&MailMessage{MessageID: "2@example.com", InReplyTo: "1@example.com", References: []string{"1@example.com"}}, "post1")
+	post(&MailMessage{MessageID: "3@example.com", InReplyTo: "2@example.com", References: []string{"1@example.com", "2@example.com"}}, "post1")
+	post(&MailMessage{MessageID: "4@example.com", References: []string{"unknown@example.com", "2@example.com"}}, "post1")
+	post(&MailMessage{MessageID: "5@example.com", InReplyTo: "unknown@example.com"}, "")
+
+	*profile.ThreadReplies = false
+	post(&MailMessage{MessageID: "6@example.com", InReplyTo: "1@example.com"}, "")
+}
+
+func TestMatterMail_PostMailMessageDrop(t *testing.T) {
+	profile := model.NewProfile()
+	profile.Channels = []string{"#town-square"}
+	profile.Filter = &model.Filter{
+		&model.Rule{Name: "out of office", Action: model.RuleActionDrop, Headers: map[string]string{"Auto-Submitted": "auto-replied"}},
+		&model.Rule{Subject: "newsletter", Action: model.RuleActionDrop},
+		&model.Rule{From: "jdoe@", Channels: []string{"#alerts"}},
+	}
+
+	mmProvider := &mattermostThreadMock{}
+	logger := NewLog("", false)
+	buff := &bytes.Buffer{}
+	logger.info = log.New(buff, "", 0)
+	mm := NewMatterMail(profile, MailBox, logger, nil, mmProvider, nil)
+
+	tests := []struct {
+		name    string
+		msg     *MailMessage
+		posted  uint64
+		dropped uint64
+	}{
+		{"post", &MailMessage{From: "jdoe@example.com", Subject: "Hello"}, 1, 0},
+		{"drop by header", &MailMessage{From: "jdoe@example.com", Subject: "Out of office", Header: map[string][]string{"Auto-Submitted": {"auto-replied"}}}, 1, 1},
+		{"drop before subject redirect", &MailMessage{From: "news@example.com", Subject: "[#alerts] Newsletter"}, 1, 2},
+		{"post after drop", &MailMessage{From: "other@example.com", Subject: "[#alerts] Hello"}, 2, 2},
+	}
+
+	for _, tt := range tests {
+		if err := mm.PostMailMessage(tt.msg); err != nil {
+			t.Fatalf("Test %v error on PostMailMessage err:%v", tt.name, err)
+		}
+
+		if stats := mm.Stats(); stats.Posted != tt.posted || stats.Dropped != tt.dropped {
+			t.Fatalf("Test %v expected posted:%v dropped:%v result:%+v", tt.name, tt.posted, tt.dropped, stats)
+		}
+
+		if mmProvider.posts != int(tt.posted) {
+			t.Fatalf("Test %v expected %v posts result:%v", tt.name, tt.posted, mmProvider.posts)
+		}
+	}
+
+	buff.Reset()
+	mm.logStats()
+	if expected := "Emails posted: 2 dropped: 2\n"; buff.String() != expected {
+		t.Fatalf("Expected log %q result:%q", expected, buff.String())
+	}
+
+	buff.Reset()
+	mm.logStats()
+	if buff.Len() != 0 {
+		t.Fatalf("Expected no log of stats not changed result:%q", buff.String())
+	}
+}
+
+func TestMatterMail_PostMailMessageSplit(t *testing.T) {
+	profile := model.NewProfile()
+	profile.Channels = []string{"#town-square"}
+	*profile.MailTemplate = "{{.Message}}"
+	*profile.LinesToPreview = 1000
+	*profile.SplitLongMessages = true
+
+	paragraph := strings.Repeat("word ", 500)
+	var paragraphs []string
+	for i := 0; i < 5; i++ {
+		paragraphs = append(paragraphs, fmt.Sprintf("%v %v", i, paragraph))
+	}
+
+	mmProvider := &mattermostThreadMock{}
+	mm := NewMatterMail(profile, MailBox, NewLog("", false), nil, mmProvider, nil)
+
+	msg := &MailMessage{From: "jdoe@example.com", Subject: "Long", EmailText: strings.Join(paragraphs, "\n\n"), EmailType: EmailTypeText}
+	if err := mm.PostMailMessage(msg); err != nil {
+		t.Fatal("Error on PostMailMessage err:", err)
+	}
+
+	// each paragraph has 2500 characters
+	if mmProvider.posts != 5 {
+		t.Fatalf("expected 5 posts result:%v", mmProvider.posts)
+	}
+
+	if !reflect.DeepEqual(mmProvider.rootIDs, []string{"", "post1", "post1", "post1", "post1"}) {
+		t.Fatalf("expected replies of post1 result:%v", mmProvider.rootIDs)
+	}
+
+	if strings.Join(mmProvider.messages, "\n\n") != msg.EmailText {
+		t.Fatal("expected all text of email in the posts")
+	}
+
+	for i, m := range mmProvider.messages {
+		if n := utf8.RuneCountInString(m); n > maxMattermostPostSize {
+			t.Fatalf("expected post %v with at most %v characters result:%v", i, maxMattermostPostSize, n)
+		}
+	}
+}
+
+func TestMatterMail_PostMailMessageSplitReplyFails(t *testing.T) {
+	profile := model.NewProfile()
+	profile.Channels = []string{"#town-square"}
+	*profile.MailTemplate = "{{.Message}}"
+	*profile.LinesToPreview = 1000
+	*profile.SplitLongMessages = true
+
+	paragraph := strings.Repeat("word ", 500)
+	var paragraphs []string
+	for i := 0; i < 3; i++ {
+		paragraphs = append(paragraphs, fmt.Sprintf("%v %v", i, paragraph))
+	}
+
+	// the first reply fails
+	mmProvider := &mattermostThreadMock{fail: map[int]bool{2: true}}
+	threads := &threadCacheMem{}
+	mm := NewMatterMail(profile, MailBox, NewLog("", false), nil, mmProvider, threads)
+
+	msg := &MailMessage{MessageID: "long@example.com", From: "jdoe@example.com", Subject: "Long", EmailText: strings.Join(paragraphs, "\n\n"), EmailType: EmailTypeText}
+	if err := mm.PostMailMessage(msg); err != nil {
+		t.Fatal("Expected no error after root post created err:", err)
+	}
+
+	if mmProvider.calls != 3 || mmProvider.posts != 2 {
+		t.Fatalf("Expected 3 calls and 2 posts result:%v %v", mmProvider.calls, mmProvider.posts)
+	}
+
+	if !reflect.DeepEqual(mmProvider.rootIDs, []string{"", "post1"}) {
+		t.Fatalf("Expected the last reply in post1 result:%v", mmProvider.rootIDs)
+	}
+
+	if rootID, _ := threads.GetPostID("id1234", msg.MessageID); rootID != "post1" {
+		t.Fatal("Expected thread of post1 saved result:", rootID)
+	}
+}
@@ -10,15 +10,58 @@ type MattermostProvider interface {
 	// Logout terminate connection with Mattermost
 	Logout() error
 
-	// GetChannelID gets channel id by channel name return empty string if not exists
-	GetChannelID(channelName string) string
+	// GetChannelID gets channel id by channel name return empty string if not exists and error if
+	// the channel could not be looked up, ex: Mattermost unavailable
+	GetChannelID(channelName string) (string, error)
 
-	// PostMessage posts a message in Mattermost
-	PostMessage(message, channelID string, attachments []*Attachment) error
+	// PostMessage posts a message in Mattermost as reply of rootID if it is not empty and returns the post id
+	PostMessage(message, channelID, rootID string, attachments []*Attachment) (string, error)
+
+	// WatchReplies calls handler for each reply posted by other users in threads, blocks until the connection is lost
+	WatchReplies(handler ReplyHandler) error
+
+	// Limits returns the limits of posts of the server, it is updated on login
+	Limits() *PostLimits
+}
+
+// PostLimits limits of Mattermost server used to create the posts
+type PostLimits struct {
+	MaxPostSize    int   // characters of message
+	MaxFileSize    int64 // bytes of each file, 0 is unknown
+	MaxAttachments int   // files of each post
 }
 
+// configPostLimits returns the default limits using the number of attachments of configuration
+func configPostLimits(cfg *model.Mattermost) *PostLimits {
+	limits := &PostLimits{
+		MaxPostSize:    maxMattermostPostSize,
+		MaxAttachments: maxMattermostAttachments,
+	}
+
+	if cfg.MaxAttachments != nil {
+		limits.MaxAttachments = *cfg.MaxAttachments
+	}
+	return limits
+}
+
+// ThreadReply reply posted by a user in a Mattermost thread
+type ThreadReply struct {
+	PostID    string
+	RootID    string
+	ChannelID string
+	UserName  string
+	Message   string
+}
+
+// ReplyHandler function called to handle a reply posted in a thread
+type ReplyHandler func(reply *ThreadReply) error
+
 // NewMattermostProvider creates a new instance of Mattermost
 func NewMattermostProvider(cfg *model.Mattermost, log Logger) MattermostProvider {
+	if cfg.WebhookURL != "" {
+		log.Info("Using Mattermost incoming webhook")
+		return NewMattermostProviderWebhook(cfg, log)
+	}
 	if *cfg.UseAPIv3 {
 		log.Info("Using Mattermost Api Version 3")
 		return NewMattermostProviderV3(cfg, log)
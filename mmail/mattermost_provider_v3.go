@@ -1,7 +1,9 @@
 package mmail
 
 import (
+	"net/http"
 	"strings"
+	"time"
 
 	mmModel "github.com/mattermost/mattermost-server/model"
 	"github.com/pkg/errors"
@@ -15,6 +17,7 @@ type MattermostProviderV3 struct {
 	user        *mmModel.User
 	client      *mmModel.Client
 	channelList *mmModel.ChannelList
+	channelTime time.Time
 }
 
 // NewMattermostProviderV3 creates a new instance of Mattermost api V3
@@ -25,27 +28,40 @@ func NewMattermostProviderV3(cfg *model.Mattermost, log Logger) *MattermostProvi
 	}
 }
 
-// Login log in Mattermost
+// Login log in Mattermost, the session is reused while it is valid
 func (m *MattermostProviderV3) Login() error {
-	m.client = mmModel.NewClient(m.cfg.Server)
+	if m.user != nil {
+		return nil
+	}
+	return m.login()
+}
 
-	m.log.Debug("Mattermost Api V3 version:", m.client.ServerVersion)
+// login creates a new session, the current session is replaced only if the login succeeds
+func (m *MattermostProviderV3) login() error {
+	client := mmModel.NewClient(m.cfg.Server)
+
+	m.log.Debug("Mattermost Api V3 version:", client.ServerVersion)
 	m.log.Debugf("Login user:%v team:%v url:%v\n", m.cfg.User, m.cfg.Team, m.cfg.Server)
 
-	result, apperr := m.client.Login(m.cfg.User, m.cfg.Password)
+	result, apperr := client.Login(m.cfg.User, m.cfg.Password)
 	if apperr != nil {
 		return errors.Wrap(apperr, "login on Mattermost V3")
 	}
 
-	m.user = result.Data.(*mmModel.User)
+	user := result.Data.(*mmModel.User)
 
 	// Get Team
-	teams := m.client.Must(m.client.GetAllTeams()).Data.(map[string]*mmModel.Team)
+	result, apperr = client.GetAllTeams()
+	if apperr != nil {
+		return errors.Wrap(apperr, "Error on get teams")
+	}
+
+	teams := result.Data.(map[string]*mmModel.Team)
 
 	teamMatch := false
 	for _, t := range teams {
 		if t.Name == m.cfg.Team {
-			m.client.SetTeamId(t.Id)
+			client.SetTeamId(t.Id)
 			teamMatch = true
 			break
 		}
@@ -56,31 +72,79 @@ func (m *MattermostProviderV3) Login() error {
 	}
 
 	//Discover channel id by channel name
-	m.channelList = m.client.Must(m.client.GetChannels("")).Data.(*mmModel.ChannelList)
+	result, apperr = client.GetChannels("")
+	if apperr != nil {
+		return errors.Wrap(apperr, "Error on get channel list")
+	}
 
+	m.client, m.user = client, user
+	m.channelList, m.channelTime = result.Data.(*mmModel.ChannelList), time.Now()
+	return nil
+}
+
+// relogin logs in again if the error is unauthorized (session expired or revoked)
+// and returns true if the request can be retried
+func (m *MattermostProviderV3) relogin(apperr *mmModel.AppError) bool {
+	if apperr == nil || apperr.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	m.log.Info("Mattermost session is not valid, login again")
+	if err := m.login(); err != nil {
+		m.log.Error("Error on login again err:", err)
+		return false
+	}
+
+	return true
+}
+
+// refreshChannels updates the channel list of user in the team
+func (m *MattermostProviderV3) refreshChannels() error {
+	m.log.Debug("Update channel list")
+
+	result, apperr := m.client.GetChannels("")
+	if m.relogin(apperr) {
+		// login updates the channel list
+		return nil
+	}
+
+	if apperr != nil {
+		return errors.Wrap(apperr, "get channel list")
+	}
+
+	m.channelList = result.Data.(*mmModel.ChannelList)
+	m.channelTime = time.Now()
 	return nil
 }
 
 // Logout terminate connection with Mattermost
 func (m *MattermostProviderV3) Logout() (err error) {
-	if m.client != nil {
-		_, err = m.client.Logout()
+	if m.client != nil && m.user != nil {
+		if _, apperr := m.client.Logout(); apperr != nil {
+			err = apperr
+		}
 	}
+	m.user = nil
 	return
 }
 
-// GetChannelID gets channel id by channel name return empty string if not exists
-func (m *MattermostProviderV3) GetChannelID(channelName string) string {
+// GetChannelID gets channel id by channel name return empty string if not exists and error if
+// the channel could not be looked up
+func (m *MattermostProviderV3) GetChannelID(channelName string) (string, error) {
+	if m.user == nil {
+		return "", errors.Errorf("not logged in on Mattermost, could not get channel %v", channelName)
+	}
+
 	if strings.HasPrefix(channelName, "#") {
 		return m.getChannelIDByName(strings.TrimPrefix(channelName, "#"))
 	} else if strings.HasPrefix(channelName, "@") {
 		return m.getDirectChannelIDByName(strings.TrimPrefix(channelName, "@"))
 	}
-	return ""
+	return "", nil
 }
 
-// PostMessage posts a message in Mattermost
-func (m *MattermostProviderV3) PostMessage(message, channelID string, attachments []*Attachment) error {
+// PostMessage posts a message in Mattermost as reply of rootID if it is not empty and returns the post id
+func (m *MattermostProviderV3) PostMessage(message, channelID, rootID string, attachments []*Attachment) (string, error) {
 	m.log.Debugf("Post in channel id %v", channelID)
 
 	// Upload attachments
@@ -90,34 +154,80 @@ func (m *MattermostProviderV3) PostMessage(message, channelID string, attachment
 			continue
 		}
 
-		resp, err := m.client.UploadPostAttachment(a.Content, channelID, a.Filename)
-		if resp == nil {
-			return errors.Wrapf(err, "Upload Attachment on mattermost channel id:'%v' filename:'%v'", channelID, a.Filename)
+		resp, apperr := m.client.UploadPostAttachment(a.Content, channelID, a.Filename)
+		if m.relogin(apperr) {
+			resp, apperr = m.client.UploadPostAttachment(a.Content, channelID, a.Filename)
+		}
+
+		if apperr != nil {
+			return "", errors.Wrapf(apperr, "Upload Attachment on mattermost channel id:'%v' filename:'%v'", channelID, a.Filename)
 		}
 
 		if len(resp.FileInfos) != 1 {
-			return errors.Errorf("error on upload file - fileinfos len different of one %v", resp.FileInfos)
+			return "", errors.Errorf("error on upload file - fileinfos len different of one %v", resp.FileInfos)
 		}
 
 		fileIds = append(fileIds, resp.FileInfos[0].Id)
 	}
 
 	// Post message
-	post := &mmModel.Post{ChannelId: channelID, Message: message}
+	post := &mmModel.Post{ChannelId: channelID, Message: message, RootId: rootID}
 
 	if len(fileIds) > 0 {
 		post.FileIds = fileIds
 	}
 
-	res, err := m.client.CreatePost(post)
-	if res == nil {
-		return errors.Wrapf(err, "create mattermost post %v", post)
+	res, apperr := m.client.CreatePost(post)
+	if m.relogin(apperr) {
+		res, apperr = m.client.CreatePost(post)
 	}
 
-	return nil
+	if apperr != nil {
+		return "", errors.Wrapf(apperr, "create mattermost post %v", post)
+	}
+
+	return res.Data.(*mmModel.Post).Id, nil
+}
+
+// WatchReplies calls handler for each reply posted by other users in threads, blocks until the connection is lost
+func (m *MattermostProviderV3) WatchReplies(handler ReplyHandler) error {
+	return errors.New("watch replies is not supported by Mattermost Api V3, set UseAPIv3 to false")
+}
+
+// Limits returns the default limits of posts, Api V3 does not inform the limits of server
+func (m *MattermostProviderV3) Limits() *PostLimits {
+	return configPostLimits(m.cfg)
 }
 
-func (m *MattermostProviderV3) getChannelIDByName(channelName string) string {
+// getChannelIDByName finds channel id in the channel list, the list is updated
+// after channelListTimeout or when the channel is not found
+func (m *MattermostProviderV3) getChannelIDByName(channelName string) (string, error) {
+	var err error
+	if time.Since(m.channelTime) > channelListTimeout {
+		// the channels of old list still exist
+		err = m.refreshChannels()
+	}
+
+	if id := m.findChannelID(channelName); id != "" {
+		return id, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// avoid update the list for each channel not found
+	if time.Since(m.channelTime) < channelListMinUpdate {
+		return "", nil
+	}
+
+	if err := m.refreshChannels(); err != nil {
+		return "", err
+	}
+	return m.findChannelID(channelName), nil
+}
+
+func (m *MattermostProviderV3) findChannelID(channelName string) string {
 	for _, c := range *m.channelList {
 		if c.Name == channelName {
 			return c.Id
@@ -126,23 +236,27 @@ func (m *MattermostProviderV3) getChannelIDByName(channelName string) string {
 	return ""
 }
 
-func (m *MattermostProviderV3) getDirectChannelIDByName(userName string) string {
+func (m *MattermostProviderV3) getDirectChannelIDByName(userName string) (string, error) {
 
 	if m.user.Username == userName {
 		m.log.Errorf("Impossible create a Direct channel, Mattermail user (%v) equals destination user (%v)\n", m.user.Username, userName)
-		return ""
+		return "", nil
 	}
 
 	//result, err := client.GetProfilesForDirectMessageList(client.GetTeamId())
-	result, err := m.client.SearchUsers(mmModel.UserSearch{
+	search := mmModel.UserSearch{
 		AllowInactive: false,
 		TeamId:        m.client.GetTeamId(),
 		Term:          userName,
-	})
+	}
+
+	result, err := m.client.SearchUsers(search)
+	if m.relogin(err) {
+		result, err = m.client.SearchUsers(search)
+	}
 
 	if err != nil {
-		m.log.Error("Error on SearchUsers: ", err.Error())
-		return ""
+		return "", errors.Wrap(err, "search users")
 	}
 
 	profiles := result.Data.([]*mmModel.User)
@@ -157,24 +271,27 @@ func (m *MattermostProviderV3) getDirectChannelIDByName(userName string) string
 
 	if userID == "" {
 		m.log.Debug("Did not find the username:", userName)
-		return ""
+		return "", nil
 	}
 
 	dmName := mmModel.GetDMNameFromIds(m.user.Id, userID)
-	dmID := m.getChannelIDByName(dmName)
+	dmID := m.findChannelID(dmName)
 
 	if dmID != "" {
-		return dmID
+		return dmID, nil
 	}
 
 	m.log.Debug("Create direct channel to user:", userName)
 
 	result, err = m.client.CreateDirectChannel(userID)
+	if m.relogin(err) {
+		result, err = m.client.CreateDirectChannel(userID)
+	}
+
 	if err != nil {
-		m.log.Error("Error on CreateDirectChannel: ", err.Error())
-		return ""
+		return "", errors.Wrap(err, "create direct channel")
 	}
 
 	directChannel := result.Data.(*mmModel.Channel)
-	return directChannel.Id
+	return directChannel.Id, nil
 }
@@ -0,0 +1,81 @@
+package mmail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+func TestMattermostProviderV3_Session(t *testing.T) {
+	mock := newMattermostServerMock()
+	defer mock.Close()
+
+	cfg := model.NewMattermost()
+	cfg.Server = mock.URL
+	cfg.Team = "team1"
+	cfg.User = "mattermail"
+	cfg.Password = "password"
+
+	m := NewMattermostProviderV3(cfg, NewLog("", false))
+
+	if err := m.Login(); err != nil {
+		t.Fatal("Error on login err:", err.Error())
+	}
+
+	if id, _ := m.GetChannelID("#town-square"); id != "ch1" {
+		t.Fatal("Expected channel id ch1 result:", id)
+	}
+
+	// channel created after login is found when the list can be updated
+	mock.update(func() { mock.extra = true })
+	if id, _ := m.GetChannelID("#new-channel"); id != "" {
+		t.Fatal("Expected channel list not updated result:", id)
+	}
+
+	m.channelTime = time.Now().Add(-channelListMinUpdate)
+	if id, _ := m.GetChannelID("#new-channel"); id != "ch2" {
+		t.Fatal("Expected channel list updated result:", id)
+	}
+
+	if _, channels := mock.counts(); channels != 2 {
+		t.Fatal("Expected 2 channel lists result:", channels)
+	}
+
+	// session expired
+	mock.update(func() { mock.expired = true })
+	postID, err := m.PostMessage("hello", "ch1", "", nil)
+	if err != nil {
+		t.Fatal("Error on post message err:", err.Error())
+	}
+
+	if logins, _ := mock.counts(); postID != "post1" || logins != 2 {
+		t.Fatalf("Expected post1 after login again result:%v logins:%v", postID, logins)
+	}
+
+	// login again fails, the session is kept to try again
+	mock.update(func() {
+		mock.expired = true
+		mock.down = true
+	})
+	if _, err := m.PostMessage("hello", "ch1", "", nil); err == nil {
+		t.Fatal("Expected error on post with login failed")
+	}
+
+	m.channelTime = time.Now().Add(-channelListTimeout)
+	if id, _ := m.GetChannelID("#town-square"); id != "ch1" || m.user == nil {
+		t.Fatalf("Expected channel of current list result:%v user:%v", id, m.user)
+	}
+
+	mock.update(func() {
+		mock.expired = false
+		mock.down = false
+	})
+	if err := m.Logout(); err != nil {
+		t.Fatal("Error on logout err:", err.Error())
+	}
+
+	if id, _ := m.GetChannelID("#town-square"); id != "" {
+		t.Fatal("Expected no channel after logout result:", id)
+	}
+}
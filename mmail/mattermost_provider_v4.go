@@ -1,13 +1,21 @@
 package mmail
 
 import (
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	mmModel "github.com/mattermost/mattermost-server/model"
 	"github.com/pkg/errors"
 	"github.com/rodcorsi/mattermail/model"
 )
 
+const (
+	channelListTimeout   = time.Minute * 5
+	channelListMinUpdate = time.Second * 30
+)
+
 // MattermostProviderV4 default implementation of MattermostProvider
 type MattermostProviderV4 struct {
 	cfg         *model.Mattermost
@@ -16,6 +24,8 @@ type MattermostProviderV4 struct {
 	client      *mmModel.Client4
 	team        *mmModel.Team
 	channelList []*mmModel.Channel
+	channelTime time.Time
+	limits      *PostLimits
 }
 
 // NewMattermostProviderV4 creates a new instance of Mattermost api V4
@@ -26,55 +36,154 @@ func NewMattermostProviderV4(cfg *model.Mattermost, log Logger) *MattermostProvi
 	}
 }
 
-// Login log in Mattermost
+// Login log in Mattermost, the session is reused while it is valid
 func (m *MattermostProviderV4) Login() error {
-	var resp *mmModel.Response
-	m.client = mmModel.NewAPIv4Client(m.cfg.Server)
+	if m.user != nil {
+		return nil
+	}
+	return m.login()
+}
 
-	m.log.Debugf("Login user:%v team:%v url:%v\n", m.cfg.User, m.cfg.Team, m.cfg.Server)
+// login creates a new session, the current session is replaced only if the login succeeds
+func (m *MattermostProviderV4) login() error {
+	var (
+		user *mmModel.User
+		resp *mmModel.Response
+	)
+	client := mmModel.NewAPIv4Client(m.cfg.Server)
+
+	if m.cfg.Token != "" {
+		// personal access token or bot token does not need login
+		m.log.Debugf("Login with token team:%v url:%v\n", m.cfg.Team, m.cfg.Server)
+		client.SetOAuthToken(m.cfg.Token)
+		user, resp = client.GetMe("")
+	} else {
+		m.log.Debugf("Login user:%v team:%v url:%v\n", m.cfg.User, m.cfg.Team, m.cfg.Server)
+		user, resp = client.Login(m.cfg.User, m.cfg.Password)
+	}
 
-	m.user, resp = m.client.Login(m.cfg.User, m.cfg.Password)
 	m.log.Debug("Mattermost Api V4 version:", resp.ServerVersion)
 	if resp.Error != nil {
 		return errors.Wrap(resp.Error, "login on mattermost V4")
 	}
 
 	// Get Team
-	m.team, resp = m.client.GetTeamByName(m.cfg.Team, "")
+	team, resp := client.GetTeamByName(m.cfg.Team, "")
 	if resp.Error != nil {
 		return errors.Wrapf(resp.Error, "Did not find team with name '%v'. Check if the team exist or if you are not using display name instead team name", m.cfg.Team)
 	}
 
 	//Discover channel id by channel name
-	m.channelList, resp = m.client.GetChannelsForTeamForUser(m.team.Id, m.user.Id, "")
+	channelList, resp := client.GetChannelsForTeamForUser(team.Id, user.Id, "")
 	if resp.Error != nil {
 		return errors.Wrap(resp.Error, "Error on get channel list")
 	}
 
+	m.client, m.user, m.team = client, user, team
+	m.channelList, m.channelTime = channelList, time.Now()
+	m.limits = m.readLimits()
+	return nil
+}
+
+// readLimits reads the post and file size limits of client config of server, the number of
+// attachments is not informed by the server and is read only from config
+func (m *MattermostProviderV4) readLimits() *PostLimits {
+	limits := configPostLimits(m.cfg)
+	postSize, fileSize := "default", "unknown"
+
+	config, resp := m.client.GetOldClientConfig("")
+	if resp.Error != nil {
+		m.log.Error("Error on get client config, using default limits err:", resp.Error)
+		config = nil
+	}
+
+	// older servers do not inform the post size
+	if size, err := strconv.Atoi(config["MaxPostSize"]); err == nil && size > 0 {
+		limits.MaxPostSize = size
+		postSize = "server"
+	}
+
+	if size, err := strconv.ParseInt(config["MaxFileSize"], 10, 64); err == nil && size > 0 {
+		limits.MaxFileSize = size
+		fileSize = "server"
+	}
+
+	m.log.Infof("Mattermost limits post size:%v (%v) file size:%v (%v) attachments:%v (config)\n", limits.MaxPostSize, postSize, limits.MaxFileSize, fileSize, limits.MaxAttachments)
+	return limits
+}
+
+// Limits returns the limits of posts of the server, it is updated on login
+func (m *MattermostProviderV4) Limits() *PostLimits {
+	if m.limits == nil {
+		return configPostLimits(m.cfg)
+	}
+	return m.limits
+}
+
+// relogin logs in again if the response is unauthorized (session expired or revoked)
+// and returns true if the request can be retried
+func (m *MattermostProviderV4) relogin(resp *mmModel.Response) bool {
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	m.log.Info("Mattermost session is not valid, login again")
+	if err := m.login(); err != nil {
+		m.log.Error("Error on login again err:", err)
+		return false
+	}
+
+	return true
+}
+
+// refreshChannels updates the channel list of user in the team
+func (m *MattermostProviderV4) refreshChannels() error {
+	m.log.Debug("Update channel list")
+
+	channelList, resp := m.client.GetChannelsForTeamForUser(m.team.Id, m.user.Id, "")
+	if m.relogin(resp) {
+		// login updates the channel list
+		return nil
+	}
+
+	if resp.Error != nil {
+		return errors.Wrap(resp.Error, "get channel list")
+	}
+
+	m.channelList = channelList
+	m.channelTime = time.Now()
 	return nil
 }
 
 // Logout terminate connection with Mattermost
 func (m *MattermostProviderV4) Logout() (err error) {
-	if m.client != nil {
-		_, resp := m.client.Logout()
-		err = resp.Error
+	// logout revokes the session of token
+	if m.client != nil && m.user != nil && m.cfg.Token == "" {
+		if _, resp := m.client.Logout(); resp.Error != nil {
+			err = resp.Error
+		}
 	}
+	m.user = nil
 	return
 }
 
-// GetChannelID gets channel id by channel name return empty string if not exists
-func (m *MattermostProviderV4) GetChannelID(channelName string) string {
+// GetChannelID gets channel id by channel name return empty string if not exists and error if
+// the channel could not be looked up
+func (m *MattermostProviderV4) GetChannelID(channelName string) (string, error) {
+	if m.user == nil {
+		return "", errors.Errorf("not logged in on Mattermost, could not get channel %v", channelName)
+	}
+
 	if strings.HasPrefix(channelName, "#") {
 		return m.getChannelIDByName(strings.TrimPrefix(channelName, "#"))
 	} else if strings.HasPrefix(channelName, "@") {
 		return m.getDirectChannelIDByName(strings.TrimPrefix(channelName, "@"))
 	}
-	return ""
+	return "", nil
 }
 
-// PostMessage posts a message in Mattermost
-func (m *MattermostProviderV4) PostMessage(message, channelID string, attachments []*Attachment) error {
+// PostMessage posts a message in Mattermost as reply of rootID if it is not empty and returns the post id
+func (m *MattermostProviderV4) PostMessage(message, channelID, rootID string, attachments []*Attachment) (string, error) {
 	m.log.Debugf("Post in channel id %v", channelID)
 
 	// Upload attachments
@@ -85,33 +194,158 @@ func (m *MattermostProviderV4) PostMessage(message, channelID string, attachment
 		}
 
 		fileResp, resp := m.client.UploadFile(a.Content, channelID, a.Filename)
+		if m.relogin(resp) {
+			fileResp, resp = m.client.UploadFile(a.Content, channelID, a.Filename)
+		}
+
 		if resp.Error != nil {
-			return errors.Wrapf(resp.Error, "upload file to mattermost channelID:'%v', filename:'%v'", channelID, a.Filename)
+			return "", errors.Wrapf(resp.Error, "upload file to mattermost channelID:'%v', filename:'%v'", channelID, a.Filename)
 		}
 
 		if len(fileResp.FileInfos) != 1 {
-			return errors.Errorf("error on upload file - fileinfos len different of one %v", fileResp.FileInfos)
+			return "", errors.Errorf("error on upload file - fileinfos len different of one %v", fileResp.FileInfos)
 		}
 
 		fileIds = append(fileIds, fileResp.FileInfos[0].Id)
 	}
 
 	// Post message
-	post := &mmModel.Post{ChannelId: channelID, Message: message}
+	post := &mmModel.Post{ChannelId: channelID, Message: message, RootId: rootID}
 
 	if len(fileIds) > 0 {
 		post.FileIds = fileIds
 	}
 
-	post, resp := m.client.CreatePost(post)
+	created, resp := m.client.CreatePost(post)
+	if m.relogin(resp) {
+		created, resp = m.client.CreatePost(post)
+	}
+
 	if resp.Error != nil {
-		return errors.Wrapf(resp.Error, "create post %v", post)
+		return "", errors.Wrapf(resp.Error, "create post %v", post)
 	}
 
-	return nil
+	return created.Id, nil
+}
+
+// WatchReplies calls handler for each reply posted by other users in threads, blocks until the connection is lost
+func (m *MattermostProviderV4) WatchReplies(handler ReplyHandler) error {
+	for {
+		if m.user == nil {
+			return errors.New("not logged in on Mattermost to watch replies")
+		}
+
+		if err := m.watchReplies(handler); err != nil {
+			return err
+		}
+
+		// websocket keeps the token used to connect
+		m.log.Info("Mattermost session was renewed, connect websocket again")
+	}
+}
+
+// watchReplies listens the websocket until the connection is lost or the session is renewed by
+// relogin, it returns nil when the session is renewed
+func (m *MattermostProviderV4) watchReplies(handler ReplyHandler) error {
+	url := "ws" + strings.TrimPrefix(m.cfg.Server, "http")
+	token := m.client.AuthToken
+
+	m.log.Debug("Connect websocket", url)
+
+	ws, apperr := mmModel.NewWebSocketClient4(url, token)
+	if apperr != nil {
+		return errors.Wrap(apperr, "connect websocket")
+	}
+	defer ws.Close()
+
+	ws.Listen()
+
+	for {
+		select {
+		case event, ok := <-ws.EventChannel:
+			if !ok {
+				if ws.ListenError != nil {
+					return errors.Wrap(ws.ListenError, "listen websocket")
+				}
+				return errors.New("websocket closed")
+			}
+
+			if event.Event != mmModel.WEBSOCKET_EVENT_POSTED {
+				continue
+			}
+
+			data, _ := event.Data["post"].(string)
+			post := mmModel.PostFromJson(strings.NewReader(data))
+
+			// ignore root posts, system messages and posts of Mattermail user
+			if post == nil || post.RootId == "" || post.Type != "" || post.UserId == m.user.Id {
+				continue
+			}
+
+			reply := &ThreadReply{
+				PostID:    post.Id,
+				RootID:    post.RootId,
+				ChannelID: post.ChannelId,
+				Message:   post.Message,
+			}
+
+			user, resp := m.client.GetUser(post.UserId, "")
+			if m.relogin(resp) {
+				user, resp = m.client.GetUser(post.UserId, "")
+			}
+
+			if resp.Error != nil {
+				m.log.Error("Error on GetUser: ", resp.Error)
+			} else {
+				reply.UserName = user.GetDisplayName(mmModel.SHOW_NICKNAME_FULLNAME)
+			}
+
+			if err := handler(reply); err != nil {
+				m.log.Errorf("Error on handle reply post id:%v err:%v\n", post.Id, err)
+			}
+
+			if m.client.AuthToken != token {
+				return nil
+			}
+
+		case _, ok := <-ws.ResponseChannel:
+			if !ok {
+				// stop to select a closed channel
+				ws.ResponseChannel = nil
+			}
+		}
+	}
+}
+
+// getChannelIDByName finds channel id in the channel list, the list is updated
+// after channelListTimeout or when the channel is not found
+func (m *MattermostProviderV4) getChannelIDByName(channelName string) (string, error) {
+	var err error
+	if time.Since(m.channelTime) > channelListTimeout {
+		// the channels of old list still exist
+		err = m.refreshChannels()
+	}
+
+	if id := m.findChannelID(channelName); id != "" {
+		return id, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// avoid update the list for each channel not found
+	if time.Since(m.channelTime) < channelListMinUpdate {
+		return "", nil
+	}
+
+	if err := m.refreshChannels(); err != nil {
+		return "", err
+	}
+	return m.findChannelID(channelName), nil
 }
 
-func (m *MattermostProviderV4) getChannelIDByName(channelName string) string {
+func (m *MattermostProviderV4) findChannelID(channelName string) string {
 	for _, c := range m.channelList {
 		if c.Name == channelName {
 			return c.Id
@@ -120,22 +354,26 @@ func (m *MattermostProviderV4) getChannelIDByName(channelName string) string {
 	return ""
 }
 
-func (m *MattermostProviderV4) getDirectChannelIDByName(userName string) string {
+func (m *MattermostProviderV4) getDirectChannelIDByName(userName string) (string, error) {
 
 	if m.user.Username == userName {
 		m.log.Errorf("Impossible create a Direct channel, Mattermail user (%v) equals destination user (%v)\n", m.user.Username, userName)
-		return ""
+		return "", nil
 	}
 
-	users, resp := m.client.SearchUsers(&mmModel.UserSearch{
+	search := &mmModel.UserSearch{
 		AllowInactive: false,
 		TeamId:        m.team.Id,
 		Term:          userName,
-	})
+	}
+
+	users, resp := m.client.SearchUsers(search)
+	if m.relogin(resp) {
+		users, resp = m.client.SearchUsers(search)
+	}
 
 	if resp.Error != nil {
-		m.log.Error("Error on SearchUsers: ", resp.Error)
-		return ""
+		return "", errors.Wrap(resp.Error, "search users")
 	}
 
 	var userID string
@@ -148,23 +386,26 @@ func (m *MattermostProviderV4) getDirectChannelIDByName(userName string) string
 
 	if userID == "" {
 		m.log.Debug("Did not find the username:", userName)
-		return ""
+		return "", nil
 	}
 
 	dmName := mmModel.GetDMNameFromIds(m.user.Id, userID)
-	dmID := m.getChannelIDByName(dmName)
+	dmID := m.findChannelID(dmName)
 
 	if dmID != "" {
-		return dmID
+		return dmID, nil
 	}
 
 	m.log.Debug("Create direct channel to user:", userName)
 
 	directChannel, resp := m.client.CreateDirectChannel(m.user.Id, userID)
+	if m.relogin(resp) {
+		directChannel, resp = m.client.CreateDirectChannel(m.user.Id, userID)
+	}
+
 	if resp.Error != nil {
-		m.log.Error("Error on CreateDirectChannel: ", resp.Error)
-		return ""
+		return "", errors.Wrap(resp.Error, "create direct channel")
 	}
 
-	return directChannel.Id
+	return directChannel.Id, nil
 }
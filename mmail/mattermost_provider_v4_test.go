@@ -0,0 +1,321 @@
+package mmail
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// mattermostServerMock is a local server with the Mattermost Api V3 and V4 used by Mattermail, the
+// fields are guarded by lock because they are changed by the handlers
+type mattermostServerMock struct {
+	*httptest.Server
+	lock     sync.Mutex
+	logins   int
+	channels int
+	expired  bool
+	extra    bool
+	down     bool
+	noSize   bool // client config without MaxPostSize like older servers
+}
+
+// counts returns the number of logins and channel lists requested
+func (s *mattermostServerMock) counts() (logins, channels int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.logins, s.channels
+}
+
+// update changes the state of server
+func (s *mattermostServerMock) update(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f()
+}
+
+func newMattermostServerMock() *mattermostServerMock {
+	s := &mattermostServerMock{}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v4/users/login", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"id":"api.unavailable","message":"Unavailable","status_code":503}`))
+			return
+		}
+		s.logins++
+		s.expired = false
+		w.Header().Set("Token", "token1234")
+		w.Write([]byte(`{"id":"user1","username":"mattermail"}`))
+	})
+
+	mux.HandleFunc("/api/v4/users/logout", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"OK"}`))
+	})
+
+	mux.HandleFunc("/api/v4/teams/name/team1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"team1id","name":"team1"}`))
+	})
+
+	mux.HandleFunc("/api/v4/users/user1/teams/team1id/channels", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.expired {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"id":"api.context.session_expired.app_error","message":"Invalid or expired session","status_code":401}`))
+			return
+		}
+		s.channels++
+		if s.extra {
+			w.Write([]byte(`[{"id":"ch1","name":"town-square"},{"id":"ch2","name":"new-channel"}]`))
+			return
+		}
+		w.Write([]byte(`[{"id":"ch1","name":"town-square"}]`))
+	})
+
+	mux.HandleFunc("/api/v4/config/client", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.noSize {
+			w.Write([]byte(`{"MaxFileSize":"1024"}`))
+			return
+		}
+		w.Write([]byte(`{"MaxPostSize":"16383","MaxFileSize":"1024"}`))
+	})
+
+	mux.HandleFunc("/api/v4/posts", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.expired || r.Header.Get("Authorization") != "BEARER token1234" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"id":"api.context.session_expired.app_error","message":"Invalid or expired session","status_code":401}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"post1","channel_id":"ch1"}`))
+	})
+
+	mux.HandleFunc("/api/v3/users/login", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"id":"api.unavailable","message":"Unavailable","status_code":503}`))
+			return
+		}
+		s.logins++
+		s.expired = false
+		// Api V3 client checks the session cookie
+		http.SetCookie(w, &http.Cookie{Name: "MMAUTHTOKEN", Value: "token1234"})
+		w.Header().Set("Token", "token1234")
+		w.Write([]byte(`{"id":"user1","username":"mattermail"}`))
+	})
+
+	mux.HandleFunc("/api/v3/users/logout", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"user_id":"user1"}`))
+	})
+
+	mux.HandleFunc("/api/v3/teams/all", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"team1id":{"id":"team1id","name":"team1"}}`))
+	})
+
+	mux.HandleFunc("/api/v3/teams/team1id/channels/", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.expired {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"id":"api.context.session_expired.app_error","message":"Invalid or expired session","status_code":401}`))
+			return
+		}
+
+		if r.URL.Path == "/api/v3/teams/team1id/channels/ch1/posts/create" {
+			w.Write([]byte(`{"id":"post1","channel_id":"ch1"}`))
+			return
+		}
+
+		s.channels++
+		if s.extra {
+			w.Write([]byte(`[{"id":"ch1","name":"town-square"},{"id":"ch2","name":"new-channel"}]`))
+			return
+		}
+		w.Write([]byte(`[{"id":"ch1","name":"town-square"}]`))
+	})
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func TestMattermostProviderV4_Session(t *testing.T) {
+	mock := newMattermostServerMock()
+	defer mock.Close()
+
+	cfg := model.NewMattermost()
+	cfg.Server = mock.URL
+	cfg.Team = "team1"
+	cfg.User = "mattermail"
+	cfg.Password = "password"
+
+	m := NewMattermostProviderV4(cfg, NewLog("", false))
+
+	for i := 0; i < 3; i++ {
+		if err := m.Login(); err != nil {
+			t.Fatal("Error on login err:", err.Error())
+		}
+	}
+
+	if logins, channels := mock.counts(); logins != 1 || channels != 1 {
+		t.Fatalf("Expected 1 login and 1 channel list result:%v %v", logins, channels)
+	}
+
+	if id, _ := m.GetChannelID("#town-square"); id != "ch1" {
+		t.Fatal("Expected channel id ch1 result:", id)
+	}
+
+	if limits := m.Limits(); limits.MaxPostSize != 16383 || limits.MaxFileSize != 1024 || limits.MaxAttachments != 5 {
+		t.Fatalf("Expected limits of server result:%+v", limits)
+	}
+
+	// channel created after login is not found until the list can be updated
+	mock.update(func() { mock.extra = true })
+	if id, _ := m.GetChannelID("#new-channel"); id != "" {
+		t.Fatal("Expected channel list not updated result:", id)
+	}
+
+	if _, channels := mock.counts(); channels != 1 {
+		t.Fatal("Expected 1 channel list result:", channels)
+	}
+
+	m.channelTime = time.Now().Add(-channelListMinUpdate)
+	if id, _ := m.GetChannelID("#new-channel"); id != "ch2" {
+		t.Fatal("Expected channel list updated result:", id)
+	}
+
+	if _, channels := mock.counts(); channels != 2 {
+		t.Fatal("Expected 2 channel lists result:", channels)
+	}
+
+	m.channelTime = time.Now().Add(-channelListTimeout)
+	if id, _ := m.GetChannelID("#town-square"); id != "ch1" {
+		t.Fatal("Expected channel list updated after timeout result:", id)
+	}
+
+	if _, channels := mock.counts(); channels != 3 {
+		t.Fatal("Expected 3 channel lists result:", channels)
+	}
+
+	// session expired
+	mock.update(func() { mock.expired = true })
+	postID, err := m.PostMessage("hello", "ch1", "", nil)
+	if err != nil {
+		t.Fatal("Error on post message err:", err.Error())
+	}
+
+	if logins, _ := mock.counts(); postID != "post1" || logins != 2 {
+		t.Fatalf("Expected post1 after login again result:%v logins:%v", postID, logins)
+	}
+
+	// login again fails, the session is kept to try again
+	mock.update(func() {
+		mock.expired = true
+		mock.down = true
+	})
+	if _, err := m.PostMessage("hello", "ch1", "", nil); err == nil {
+		t.Fatal("Expected error on post with login failed")
+	}
+
+	m.channelTime = time.Now().Add(-channelListTimeout)
+	if id, _ := m.GetChannelID("#town-square"); id != "ch1" || m.user == nil {
+		t.Fatalf("Expected channel of current list result:%v user:%v", id, m.user)
+	}
+
+	if id, err := m.GetChannelID("@user2"); id != "" || err == nil {
+		t.Fatalf("Expected error on look up direct channel result:%v err:%v", id, err)
+	}
+
+	m.channelTime = time.Now().Add(-channelListMinUpdate)
+	if id, err := m.GetChannelID("#unknown"); id != "" || err == nil {
+		t.Fatalf("Expected error on update channel list result:%v err:%v", id, err)
+	}
+
+	mock.update(func() {
+		mock.expired = false
+		mock.down = false
+	})
+	if err := m.Logout(); err != nil {
+		t.Fatal("Error on logout err:", err.Error())
+	}
+
+	if id, err := m.GetChannelID("#town-square"); id != "" || err == nil {
+		t.Fatalf("Expected error after logout result:%v err:%v", id, err)
+	}
+
+	if err := m.Login(); err != nil {
+		t.Fatal("Error on login after logout err:", err.Error())
+	}
+
+	if logins, _ := mock.counts(); logins != 3 {
+		t.Fatal("Expected new login after logout result:", logins)
+	}
+}
+
+func TestMattermostProviderV4_Token(t *testing.T) {
+	mock := newMattermostServerMock()
+	defer mock.Close()
+
+	cfg := model.NewMattermost()
+	cfg.Server = mock.URL
+	cfg.Team = "team1"
+	cfg.Token = "token1234"
+
+	m := NewMattermostProviderV4(cfg, NewLog("", false))
+
+	// token does not use login
+	if err := m.Login(); err == nil {
+		t.Fatal("Expected error without users/me endpoint")
+	}
+
+	if logins, _ := mock.counts(); logins != 0 {
+		t.Fatal("Expected no login with token result:", logins)
+	}
+}
+
+func TestMattermostProviderV4_Limits(t *testing.T) {
+	mock := newMattermostServerMock()
+	defer mock.Close()
+	mock.update(func() { mock.noSize = true })
+
+	cfg := model.NewMattermost()
+	cfg.Server = mock.URL
+	cfg.Team = "team1"
+	cfg.User = "mattermail"
+	cfg.Password = "password"
+	*cfg.MaxAttachments = 3
+
+	logger := NewLog("", false)
+	buff := &bytes.Buffer{}
+	logger.info = log.New(buff, "", 0)
+
+	m := NewMattermostProviderV4(cfg, logger)
+	if err := m.Login(); err != nil {
+		t.Fatal("Error on login err:", err.Error())
+	}
+
+	if limits := m.Limits(); limits.MaxPostSize != maxMattermostPostSize || limits.MaxFileSize != 1024 || limits.MaxAttachments != 3 {
+		t.Fatalf("Expected default post size result:%+v", limits)
+	}
+
+	expected := fmt.Sprintf("post size:%v (default) file size:1024 (server) attachments:3 (config)", maxMattermostPostSize)
+	if !strings.Contains(buff.String(), expected) {
+		t.Fatalf("Expected log %q result:%q", expected, buff.String())
+	}
+}
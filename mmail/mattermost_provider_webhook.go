@@ -0,0 +1,130 @@
+package mmail
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// MattermostProviderWebhook implements MattermostProvider using an incoming webhook
+type MattermostProviderWebhook struct {
+	cfg    *model.Mattermost
+	log    Logger
+	client *http.Client
+}
+
+type webhookAttachment struct {
+	Fallback string `json:"fallback"`
+	Pretext  string `json:"pretext,omitempty"`
+	Title    string `json:"title"`
+	Text     string `json:"text,omitempty"`
+}
+
+type webhookRequest struct {
+	Text     string                 `json:"text"`
+	Channel  string                 `json:"channel,omitempty"`
+	Username string                 `json:"username,omitempty"`
+	IconURL  string                 `json:"icon_url,omitempty"`
+	Props    map[string]interface{} `json:"props,omitempty"`
+}
+
+// NewMattermostProviderWebhook creates a new instance of Mattermost incoming webhook
+func NewMattermostProviderWebhook(cfg *model.Mattermost, log Logger) *MattermostProviderWebhook {
+	return &MattermostProviderWebhook{
+		cfg:    cfg,
+		log:    log,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Login log in Mattermost, incoming webhook does not need login
+func (m *MattermostProviderWebhook) Login() error {
+	return nil
+}
+
+// Logout terminate connection with Mattermost, incoming webhook does not need logout
+func (m *MattermostProviderWebhook) Logout() error {
+	return nil
+}
+
+// GetChannelID gets channel id by channel name, incoming webhook accepts channel name
+// as channel id ex: #town-square => town-square, @john => @john
+func (m *MattermostProviderWebhook) GetChannelID(channelName string) (string, error) {
+	if strings.HasPrefix(channelName, "#") {
+		return strings.TrimPrefix(channelName, "#"), nil
+	} else if strings.HasPrefix(channelName, "@") {
+		return channelName, nil
+	}
+	return "", nil
+}
+
+// PostMessage posts a message in Mattermost, incoming webhook does not upload files and
+// does not support threads, the names of attachments are listed in the post as not available,
+// email.html is skipped and rootID is ignored
+func (m *MattermostProviderWebhook) PostMessage(message, channelID, rootID string, attachments []*Attachment) (string, error) {
+	m.log.Debugf("Post in channel %v using incoming webhook", channelID)
+
+	req := &webhookRequest{
+		Text:     message,
+		Channel:  channelID,
+		Username: m.cfg.WebhookUsername,
+		IconURL:  m.cfg.WebhookIconURL,
+	}
+
+	var list []*webhookAttachment
+	for _, a := range attachments {
+		// the full email is only useful as a file
+		if len(a.Content) == 0 || a.Filename == "email.html" {
+			continue
+		}
+
+		list = append(list, &webhookAttachment{
+			Fallback: a.Filename,
+			Title:    a.Filename,
+			Text:     model.HumanSize(len(a.Content)),
+		})
+	}
+
+	if len(list) > 0 {
+		list[0].Pretext = "Attachments not available using incoming webhook:"
+	}
+
+	if len(list) > 0 {
+		req.Props = map[string]interface{}{"attachments": list}
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal incoming webhook request")
+	}
+
+	resp, err := m.client.Post(m.cfg.WebhookURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return "", errors.Wrap(err, "post on incoming webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", errors.Errorf("post on incoming webhook status:%v response:%v", resp.Status, string(body))
+	}
+
+	// incoming webhook does not return the post id
+	return "", nil
+}
+
+// WatchReplies calls handler for each reply posted by other users in threads, blocks until the connection is lost
+func (m *MattermostProviderWebhook) WatchReplies(handler ReplyHandler) error {
+	return errors.New("watch replies is not supported by incoming webhook")
+}
+
+// Limits returns the default limits of posts, incoming webhook does not upload files
+func (m *MattermostProviderWebhook) Limits() *PostLimits {
+	return configPostLimits(m.cfg)
+}
@@ -0,0 +1,78 @@
+package mmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+func TestMattermostProviderWebhook_GetChannelID(t *testing.T) {
+	m := NewMattermostProviderWebhook(model.NewMattermost(), NewLog("", false))
+
+	assert := func(channelName, expected string) {
+		if id, _ := m.GetChannelID(channelName); id != expected {
+			t.Fatalf("Tested:%v expected:%v result:%v", channelName, expected, id)
+		}
+	}
+
+	assert("#town-square", "town-square")
+	assert("@john", "@john")
+	assert("john", "")
+}
+
+func TestMattermostProviderWebhook_PostMessage(t *testing.T) {
+	var req webhookRequest
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	cfg := model.NewMattermost()
+	cfg.WebhookURL = ts.URL + "/hooks/xxx"
+	cfg.WebhookUsername = "mattermail"
+	cfg.WebhookIconURL = "https://example.com/icon.png"
+
+	m := NewMattermostProvider(cfg, NewLog("", false))
+
+	attachments := []*Attachment{
+		{Filename: "email.html", Content: []byte("<b>hello</b>")},
+		{Filename: "report.pdf", Content: []byte("%PDF")},
+		{Filename: "empty.txt"},
+	}
+
+	if _, err := m.PostMessage("hello", "town-square", "", attachments); err != nil {
+		t.Fatal("Error on post message err:", err.Error())
+	}
+
+	if req.Text != "hello" || req.Channel != "town-square" || req.Username != "mattermail" || req.IconURL != cfg.WebhookIconURL {
+		t.Fatalf("Unexpected request %+v", req)
+	}
+
+	list, ok := req.Props["attachments"].([]interface{})
+	if !ok || len(list) != 1 {
+		t.Fatalf("Expected one attachment result:%v", req.Props)
+	}
+
+	item := list[0].(map[string]interface{})
+	if item["title"] != "report.pdf" {
+		t.Fatal("Expected attachment title report.pdf result:", item["title"])
+	}
+
+	if item["pretext"] == nil {
+		t.Fatal("Expected attachment with note of not available result:", item)
+	}
+
+	cfg.WebhookURL = ts.URL + "/invalid"
+	ts.Config.Handler = http.NotFoundHandler()
+	if _, err := m.PostMessage("hello", "town-square", "", nil); err == nil {
+		t.Fatal("Expected error on status not found")
+	}
+}
@@ -0,0 +1,84 @@
+package mmail
+
+import (
+	"net"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// OAuth2TokenSource provides the access token used to authenticate on IMAP server
+type OAuth2TokenSource interface {
+	// Token returns a valid access token, refreshing it if necessary
+	Token() (string, error)
+
+	// Invalidate discards the current access token, the next call of Token will refresh it
+	Invalidate() error
+}
+
+// xoauth2Client implements the XOAUTH2 mechanism answering the error challenge with
+// an empty response, the server then completes the command with the failure
+type xoauth2Client struct {
+	sasl.Client
+	answered bool
+}
+
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	if a.answered {
+		return nil, sasl.ErrUnexpectedServerChallenge
+	}
+	a.answered = true
+	return []byte{}, nil
+}
+
+// oauthBearerClient implements the OAUTHBEARER mechanism defined in RFC 7628
+type oauthBearerClient struct {
+	username string
+	token    string
+	host     string
+	port     string
+	answered bool
+}
+
+// gs2Escaper escapes the characters of the authorization identity in the gs2 header (RFC 5801)
+var gs2Escaper = strings.NewReplacer("=", "=3D", ",", "=2C")
+
+func (a *oauthBearerClient) Start() (string, []byte, error) {
+	ir := "n,a=" + gs2Escaper.Replace(a.username) + ",\x01"
+	if a.host != "" {
+		ir += "host=" + a.host + "\x01"
+	}
+	if a.port != "" {
+		ir += "port=" + a.port + "\x01"
+	}
+	ir += "auth=Bearer " + a.token + "\x01\x01"
+	return model.OAuth2MechanismOAuthBearer, []byte(ir), nil
+}
+
+// Next answers the error challenge with %x01 as required by RFC 7628, the server
+// then completes the command with the failure
+func (a *oauthBearerClient) Next(challenge []byte) ([]byte, error) {
+	if a.answered {
+		return nil, sasl.ErrUnexpectedServerChallenge
+	}
+	a.answered = true
+	return []byte{0x01}, nil
+}
+
+// newOAuth2SASLClient returns the sasl client for the mechanism configured
+func newOAuth2SASLClient(cfg *model.Email, token string) sasl.Client {
+	if *cfg.OAuth2.Mechanism == model.OAuth2MechanismOAuthBearer {
+		host, port, err := net.SplitHostPort(cfg.ImapServer)
+		if err != nil {
+			host = cfg.ImapServer
+		}
+		return &oauthBearerClient{
+			username: cfg.Username,
+			token:    token,
+			host:     host,
+			port:     port,
+		}
+	}
+	return &xoauth2Client{Client: sasl.NewXoauth2Client(cfg.Username, token)}
+}
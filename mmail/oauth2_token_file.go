@@ -0,0 +1,187 @@
+package mmail
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// expiryDelta refresh the access token before it expires
+const expiryDelta = time.Minute
+
+// OAuth2TokenFile implements OAuth2TokenSource refreshing the access token in the
+// token endpoint and using filesystem to store it
+type OAuth2TokenFile struct {
+	cfg      *model.OAuth2
+	filename string
+	client   *http.Client
+	token    *oauth2Token
+	lock     sync.Mutex
+}
+
+// oauth2Token content of the token file, Source is the refresh token of the config used to
+// discard the file when the config is changed
+type oauth2Token struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       int64
+	Source       string
+}
+
+type oauth2TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewOAuth2TokenFile return a new OAuth2TokenFile
+func NewOAuth2TokenFile(cfg *model.OAuth2, directory, account string) *OAuth2TokenFile {
+	filename := filepath.Join(directory, strings.ToLower(account+"_oauth2.json"))
+
+	return &OAuth2TokenFile{
+		cfg:      cfg,
+		filename: filename,
+		client:   &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Token returns a valid access token, refreshing it if necessary
+func (o *OAuth2TokenFile) Token() (string, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err := o.load(); err != nil {
+		return "", err
+	}
+
+	if o.token.AccessToken != "" && time.Now().Add(expiryDelta).Unix() < o.token.Expiry {
+		return o.token.AccessToken, nil
+	}
+
+	if err := o.refresh(); err != nil {
+		return "", err
+	}
+
+	return o.token.AccessToken, nil
+}
+
+// Invalidate discards the current access token, the next call of Token will refresh it
+func (o *OAuth2TokenFile) Invalidate() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err := o.load(); err != nil {
+		return err
+	}
+
+	o.token.AccessToken = ""
+	o.token.Expiry = 0
+	return o.save()
+}
+
+// refresh requests a new access token using the refresh token
+func (o *OAuth2TokenFile) refresh() error {
+	refreshToken := o.token.RefreshToken
+	if refreshToken == "" {
+		refreshToken = o.cfg.RefreshToken
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	form.Set("client_id", o.cfg.ClientID)
+	if o.cfg.ClientSecret != "" {
+		form.Set("client_secret", o.cfg.ClientSecret)
+	}
+	if o.cfg.Scope != "" {
+		form.Set("scope", o.cfg.Scope)
+	}
+
+	resp, err := o.client.PostForm(o.cfg.TokenURL, form)
+	if err != nil {
+		return errors.Wrap(err, "request oauth2 token")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "read oauth2 token response")
+	}
+
+	tr := &oauth2TokenResponse{}
+	if err := json.Unmarshal(body, tr); err != nil && resp.StatusCode == http.StatusOK {
+		return errors.Wrap(err, "oauth2 token response invalid content")
+	}
+
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		if tr.Error != "" {
+			return errors.Errorf("oauth2 token status:%v error:%v %v", resp.Status, tr.Error, tr.ErrorDescription)
+		}
+		return errors.Errorf("oauth2 token status:%v response:%v", resp.Status, string(body))
+	}
+
+	if tr.AccessToken == "" {
+		return errors.New("oauth2 token response without access_token")
+	}
+
+	o.token.AccessToken = tr.AccessToken
+	o.token.Expiry = 0
+	if tr.ExpiresIn > 0 {
+		o.token.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second).Unix()
+	}
+
+	// some providers rotate the refresh token on each refresh
+	if tr.RefreshToken != "" {
+		o.token.RefreshToken = tr.RefreshToken
+	}
+
+	return o.save()
+}
+
+// load reads the file only on first access
+func (o *OAuth2TokenFile) load() error {
+	if o.token != nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(o.filename)
+	if os.IsNotExist(err) {
+		o.token = &oauth2Token{Source: o.cfg.RefreshToken}
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "Error on read file '%v'", o.filename)
+	}
+
+	token := &oauth2Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return errors.Wrapf(err, "Error on read file '%v' invalid content", o.filename)
+	}
+
+	if token.Source != o.cfg.RefreshToken {
+		// the refresh token was changed in config, discard the tokens stored
+		token = &oauth2Token{Source: o.cfg.RefreshToken}
+	}
+
+	o.token = token
+	return nil
+}
+
+func (o *OAuth2TokenFile) save() error {
+	data, err := json.Marshal(o.token)
+	if err != nil {
+		return errors.Wrap(err, "marshal oauth2 token")
+	}
+
+	return ioutil.WriteFile(o.filename, data, 0600)
+}
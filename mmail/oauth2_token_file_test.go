@@ -0,0 +1,96 @@
+package mmail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// newTokenMock creates a token endpoint that returns access-N and rotates the refresh token
+func newTokenMock(t *testing.T, count *uint32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error("Error on parse form", err)
+		}
+
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("client_id") != "client" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+
+		refresh := r.Form.Get("refresh_token")
+		if refresh != "refresh" && refresh != "rotated" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"token revoked"}`)
+			return
+		}
+
+		n := atomic.AddUint32(count, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%v","token_type":"Bearer","expires_in":3600,"refresh_token":"rotated"}`, n)
+	}))
+}
+
+func TestOAuth2TokenFile(t *testing.T) {
+	var count uint32
+	srv := newTokenMock(t, &count)
+	defer srv.Close()
+
+	cfg := model.NewOAuth2()
+	cfg.ClientID = "client"
+	cfg.RefreshToken = "refresh"
+	cfg.TokenURL = srv.URL
+
+	tokens := NewOAuth2TokenFile(cfg, os.TempDir(), "oauth@example.com")
+	os.Remove(tokens.filename)
+	defer os.Remove(tokens.filename)
+
+	if token, err := tokens.Token(); err != nil || token != "access-1" {
+		t.Fatalf("Expected access-1 result:%v err:%v", token, err)
+	}
+
+	if token, _ := tokens.Token(); token != "access-1" {
+		t.Fatal("Expected cached access-1 result:", token)
+	}
+
+	// read from file
+	tokens = NewOAuth2TokenFile(cfg, os.TempDir(), "oauth@example.com")
+
+	if token, _ := tokens.Token(); token != "access-1" || count != 1 {
+		t.Fatalf("Expected access-1 from file result:%v requests:%v", token, count)
+	}
+
+	if err := tokens.Invalidate(); err != nil {
+		t.Fatal("Error on invalidate", err.Error())
+	}
+
+	if token, _ := tokens.Token(); token != "access-2" {
+		t.Fatal("Expected access-2 after invalidate result:", token)
+	}
+
+	if tokens.token.RefreshToken != "rotated" {
+		t.Fatal("Expected rotated refresh token result:", tokens.token.RefreshToken)
+	}
+
+	// refresh token changed in config discards the file
+	cfg.RefreshToken = "revoked"
+	tokens = NewOAuth2TokenFile(cfg, os.TempDir(), "oauth@example.com")
+
+	if _, err := tokens.Token(); err == nil {
+		t.Fatal("Expected error invalid_grant")
+	}
+
+	ioutil.WriteFile(tokens.filename, []byte("invalid"), 0600)
+	tokens = NewOAuth2TokenFile(cfg, os.TempDir(), "oauth@example.com")
+
+	if _, err := tokens.Token(); err == nil {
+		t.Fatal("Expected error invalid content")
+	}
+}
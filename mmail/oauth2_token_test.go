@@ -0,0 +1,29 @@
+package mmail
+
+import (
+	"testing"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+func TestOAuthBearerClient_Start(t *testing.T) {
+	cfg := model.NewEmail()
+	cfg.ImapServer = "imap.example.com:993"
+	cfg.Username = "orders=sales,eu@example.com"
+	cfg.OAuth2 = model.NewOAuth2()
+	*cfg.OAuth2.Mechanism = model.OAuth2MechanismOAuthBearer
+
+	mech, ir, err := newOAuth2SASLClient(cfg, "token").Start()
+	if err != nil {
+		t.Fatal("Error on start:", err.Error())
+	}
+
+	if mech != model.OAuth2MechanismOAuthBearer {
+		t.Fatal("Expected mechanism OAUTHBEARER result:", mech)
+	}
+
+	expected := "n,a=orders=3Dsales=2Ceu@example.com,\x01host=imap.example.com\x01port=993\x01auth=Bearer token\x01\x01"
+	if string(ir) != expected {
+		t.Fatalf("Expected initial response %q result:%q", expected, ir)
+	}
+}
@@ -0,0 +1,206 @@
+package mmail
+
+import (
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pop3Timeout max timeout awaiting a command
+const pop3Timeout = time.Minute * 3
+
+// pop3Client minimal pop3 client defined in RFC 1939 with STLS defined in RFC 2595
+type pop3Client struct {
+	conn net.Conn
+	text *textproto.Conn
+	log  Logger
+}
+
+// pop3Message message listed by UIDL
+type pop3Message struct {
+	seq  int
+	uidl string
+}
+
+// dialPop3 connects to pop3 server, tlsConfig not nil starts a TLS connection
+func dialPop3(addr string, tlsConfig *tls.Config, log Logger) (*pop3Client, error) {
+	var conn net.Conn
+	var err error
+
+	dialer := &net.Dialer{Timeout: time.Minute}
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &pop3Client{
+		conn: conn,
+		text: textproto.NewConn(conn),
+		log:  log,
+	}
+
+	// server greeting
+	if _, err := c.readResponse(); err != nil {
+		c.close()
+		return nil, errors.Wrap(err, "read greeting")
+	}
+
+	return c, nil
+}
+
+// readResponse reads the status line returning the text after +OK
+func (c *pop3Client) readResponse() (string, error) {
+	c.conn.SetDeadline(time.Now().Add(pop3Timeout))
+
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+
+	if c.log != nil {
+		c.log.Debug("S:", line)
+	}
+
+	if strings.HasPrefix(line, "+OK") {
+		return strings.TrimSpace(line[3:]), nil
+	} else if strings.HasPrefix(line, "-ERR") {
+		return "", errors.New(strings.TrimSpace(line[4:]))
+	}
+	return "", errors.Errorf("invalid response: %v", line)
+}
+
+// cmd sends the command and reads the status line
+func (c *pop3Client) cmd(format string, args ...interface{}) (string, error) {
+	c.conn.SetDeadline(time.Now().Add(pop3Timeout))
+
+	if c.log != nil {
+		if strings.HasPrefix(format, "PASS") {
+			c.log.Debug("C: PASS ****")
+		} else {
+			c.log.Debugf("C: "+format, args...)
+		}
+	}
+
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+
+	return c.readResponse()
+}
+
+// cmdLines sends the command and reads the multi-line response
+func (c *pop3Client) cmdLines(format string, args ...interface{}) ([]string, error) {
+	if _, err := c.cmd(format, args...); err != nil {
+		return nil, err
+	}
+
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// capabilities returns the capabilities of the server, empty if CAPA is not supported
+func (c *pop3Client) capabilities() map[string]bool {
+	caps := make(map[string]bool)
+
+	lines, err := c.cmdLines("CAPA")
+	if err != nil {
+		return caps
+	}
+
+	for _, line := range lines {
+		if f := strings.Fields(line); len(f) > 0 {
+			caps[strings.ToUpper(f[0])] = true
+		}
+	}
+	return caps
+}
+
+// startTLS upgrades the connection to TLS using STLS
+func (c *pop3Client) startTLS(tlsConfig *tls.Config) error {
+	if _, err := c.cmd("STLS"); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	return nil
+}
+
+// login authenticates with USER and PASS
+func (c *pop3Client) login(username, password string) error {
+	if _, err := c.cmd("USER %s", username); err != nil {
+		return err
+	}
+
+	_, err := c.cmd("PASS %s", password)
+	return err
+}
+
+// uidl lists the messages with their unique-id
+func (c *pop3Client) uidl() ([]*pop3Message, error) {
+	lines, err := c.cmdLines("UIDL")
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*pop3Message, 0, len(lines))
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			return nil, errors.Errorf("invalid UIDL line: %v", line)
+		}
+
+		seq, err := strconv.Atoi(f[0])
+		if err != nil {
+			return nil, errors.Errorf("invalid UIDL line: %v", line)
+		}
+
+		list = append(list, &pop3Message{seq: seq, uidl: f[1]})
+	}
+	return list, nil
+}
+
+// retr retrieves the message
+func (c *pop3Client) retr(seq int) ([]byte, error) {
+	if _, err := c.cmd("RETR %d", seq); err != nil {
+		return nil, err
+	}
+
+	return c.text.ReadDotBytes()
+}
+
+// dele marks the message as deleted, the message is removed on quit
+func (c *pop3Client) dele(seq int) error {
+	_, err := c.cmd("DELE %d", seq)
+	return err
+}
+
+// quit terminates the session committing the deletions and closes the connection
+func (c *pop3Client) quit() error {
+	defer c.close()
+
+	_, err := c.cmd("QUIT")
+	return err
+}
+
+func (c *pop3Client) close() error {
+	return c.text.Close()
+}
@@ -0,0 +1,395 @@
+package mmail
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+const (
+	receiverTimeout       = time.Minute * 5
+	receiverMaxRecipients = 100
+)
+
+// Receiver is a SMTP or LMTP server used as delivery target of a MTA, the emails are
+// delivered to the MailProviderReceiver registered for each recipient
+type Receiver struct {
+	cfg       *model.Receiver
+	log       Logger
+	debug     bool
+	hostname  string
+	timeout   time.Duration // idle time of a connection
+	providers map[string]*MailProviderReceiver
+	lock      sync.RWMutex
+}
+
+// NewReceiver creates a new Receiver instance
+func NewReceiver(cfg *model.Receiver, log Logger, debug bool) *Receiver {
+	hostname := cfg.Hostname
+	if hostname == "" {
+		if h, err := os.Hostname(); err == nil {
+			hostname = h
+		} else {
+			hostname = "localhost"
+		}
+	}
+
+	return &Receiver{
+		cfg:       cfg,
+		log:       log,
+		debug:     debug,
+		hostname:  hostname,
+		timeout:   receiverTimeout,
+		providers: make(map[string]*MailProviderReceiver),
+	}
+}
+
+// Register delivers the emails of the recipients to the provider
+func (r *Receiver) Register(recipients []string, provider *MailProviderReceiver) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, rcpt := range recipients {
+		r.providers[strings.ToLower(rcpt)] = provider
+	}
+}
+
+func (r *Receiver) getProvider(rcpt string) *MailProviderReceiver {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.providers[strings.ToLower(rcpt)]
+}
+
+// Serve accepts connections on the listener, blocks until the listener is closed
+func (r *Receiver) Serve(l net.Listener) error {
+	protocol := "SMTP"
+	if *r.cfg.LMTP {
+		protocol = "LMTP"
+	}
+	r.log.Infof("Receiving emails with %v on %v\n", protocol, l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errors.Wrap(err, "accept receiver connection")
+		}
+
+		go r.handleConn(conn)
+	}
+}
+
+// receiverSession state of a SMTP/LMTP session
+type receiverSession struct {
+	r          *Receiver
+	conn       net.Conn
+	text       *textproto.Conn
+	helo       bool
+	from       string
+	inMail     bool
+	recipients []string
+}
+
+func (r *Receiver) handleConn(conn net.Conn) {
+	s := &receiverSession{
+		r:    r,
+		conn: conn,
+		text: textproto.NewConn(conn),
+	}
+	defer s.text.Close()
+
+	r.log.Debug("Receiver.handleConn: New connection from", conn.RemoteAddr())
+
+	if *r.cfg.LMTP {
+		s.reply(220, "%v LMTP Mattermail ready", r.hostname)
+	} else {
+		s.reply(220, "%v ESMTP Mattermail ready", r.hostname)
+	}
+
+	for {
+		conn.SetDeadline(time.Now().Add(r.timeout))
+
+		line, err := s.text.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				r.log.Debug("Receiver.handleConn: Error on read command:", err.Error())
+			}
+			return
+		}
+
+		if r.debug {
+			r.log.Debug("C:", line)
+		}
+
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		if !s.handle(strings.ToUpper(cmd), arg) {
+			return
+		}
+	}
+}
+
+// handle executes the command, returns false to close the connection
+func (s *receiverSession) handle(cmd, arg string) bool {
+	switch {
+	case cmd == "LHLO" || cmd == "EHLO" || cmd == "HELO":
+		s.hello(cmd)
+	case cmd == "MAIL":
+		s.mail(arg)
+	case cmd == "RCPT":
+		s.rcpt(arg)
+	case cmd == "DATA":
+		return s.data()
+	case cmd == "RSET":
+		s.reset()
+		s.reply(250, "2.0.0 OK")
+	case cmd == "NOOP":
+		s.reply(250, "2.0.0 OK")
+	case cmd == "VRFY":
+		s.reply(252, "2.5.0 Cannot VRFY user")
+	case cmd == "QUIT":
+		s.reply(221, "2.0.0 Bye")
+		return false
+	default:
+		s.reply(502, "5.5.2 Command not recognized")
+	}
+	return true
+}
+
+// hello LMTP accepts only LHLO and SMTP only EHLO and HELO
+func (s *receiverSession) hello(cmd string) {
+	if *s.r.cfg.LMTP != (cmd == "LHLO") {
+		s.reply(500, "5.5.1 Command not accepted, use %v", map[bool]string{true: "LHLO", false: "EHLO"}[*s.r.cfg.LMTP])
+		return
+	}
+
+	s.helo = true
+	s.reset()
+
+	if cmd == "HELO" {
+		s.reply(250, "%v", s.r.hostname)
+		return
+	}
+
+	s.replyLines(250, s.r.hostname, "8BITMIME", "PIPELINING", "SIZE "+strconv.Itoa(*s.r.cfg.MaxMessageSize))
+}
+
+func (s *receiverSession) reset() {
+	s.from = ""
+	s.inMail = false
+	s.recipients = nil
+}
+
+func (s *receiverSession) reply(code int, format string, args ...interface{}) {
+	if s.r.debug {
+		s.r.log.Debugf("S: %d "+format, append([]interface{}{code}, args...)...)
+	}
+	s.text.PrintfLine("%d "+format, append([]interface{}{code}, args...)...)
+}
+
+func (s *receiverSession) replyLines(code int, lines ...string) {
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		if s.r.debug {
+			s.r.log.Debugf("S: %d%v%v", code, sep, line)
+		}
+		s.text.PrintfLine("%d%v%v", code, sep, line)
+	}
+}
+
+// parsePath returns the address of the path <address> and the parameters after it
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
+
+func (s *receiverSession) mail(arg string) {
+	if !s.helo {
+		s.reply(503, "5.5.1 Send hello first")
+		return
+	}
+
+	if s.inMail {
+		s.reply(503, "5.5.1 Sender already specified")
+		return
+	}
+
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		s.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+
+	for _, p := range params {
+		if strings.HasPrefix(strings.ToUpper(p), "SIZE=") {
+			size, err := strconv.Atoi(p[5:])
+			if err != nil {
+				s.reply(501, "5.5.4 Invalid SIZE parameter")
+				return
+			}
+
+			if size > *s.r.cfg.MaxMessageSize {
+				s.reply(552, "5.3.4 Message size exceeds fixed limit")
+				return
+			}
+		}
+	}
+
+	s.from = from
+	s.inMail = true
+	s.reply(250, "2.1.0 OK")
+}
+
+func (s *receiverSession) rcpt(arg string) {
+	if !s.inMail {
+		s.reply(503, "5.5.1 Need MAIL command")
+		return
+	}
+
+	rcpt, _, ok := parsePath(arg, "TO:")
+	if !ok {
+		s.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+
+	if len(s.recipients) >= receiverMaxRecipients {
+		s.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+
+	if addr, err := mail.ParseAddress(rcpt); err == nil {
+		rcpt = addr.Address
+	}
+
+	if s.r.getProvider(rcpt) == nil {
+		s.r.log.Infof("Reject recipient %v without profile\n", rcpt)
+		s.reply(550, "5.1.1 <%v>: Recipient address rejected", rcpt)
+		return
+	}
+
+	s.recipients = append(s.recipients, rcpt)
+	s.reply(250, "2.1.5 OK")
+}
+
+// data reads and delivers the message, returns false to close the connection when the message
+// could not be read
+func (s *receiverSession) data() bool {
+	if !s.inMail || len(s.recipients) == 0 {
+		s.reply(503, "5.5.1 Need RCPT command")
+		return true
+	}
+
+	s.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	max := int64(*s.r.cfg.MaxMessageSize)
+	buff := &bytes.Buffer{}
+	dot := s.text.DotReader()
+
+	n, err := io.CopyN(buff, dot, max+1)
+	if err != nil && err != io.EOF {
+		// the rest of message would be read as commands
+		s.r.log.Error("Receiver.data: Error on read message:", err.Error())
+		s.conn.SetWriteDeadline(time.Now().Add(s.r.timeout))
+		s.replyEach(451, "4.3.0 Error on read message")
+		return false
+	}
+
+	if n > max {
+		// discard the rest of message
+		io.Copy(ioutil.Discard, dot)
+		s.r.log.Infof("Reject message from %v with more than %v bytes\n", s.from, max)
+		s.replyEach(552, "5.3.4 Message size exceeds fixed limit")
+		s.reset()
+		return true
+	}
+
+	// deliver once for each profile
+	results := make(map[*MailProviderReceiver]error)
+	for _, rcpt := range s.recipients {
+		provider := s.r.getProvider(rcpt)
+		if _, ok := results[provider]; ok {
+			continue
+		}
+
+		s.r.log.Infof("Deliver message from %v to %v\n", s.from, rcpt)
+		results[provider] = provider.deliver(buff.Bytes(), receiverTimeout)
+		if results[provider] != nil {
+			s.r.log.Error("Receiver.data: Error on deliver message to", rcpt, "err:", results[provider].Error())
+		}
+	}
+
+	if *s.r.cfg.LMTP {
+		// LMTP replies for each recipient
+		for _, rcpt := range s.recipients {
+			switch err := results[s.r.getProvider(rcpt)]; {
+			case err == nil:
+				s.reply(250, "2.0.0 <%v>: Message accepted", rcpt)
+			case IsPermanentError(err):
+				s.reply(554, "5.6.0 <%v>: Message can not be posted", rcpt)
+			default:
+				s.reply(451, "4.3.0 <%v>: Error on post message, try again later", rcpt)
+			}
+		}
+	} else {
+		// temporary errors are tried again by the sender even if some profiles failed permanently
+		var failed, temporary bool
+		for _, err := range results {
+			failed = failed || err != nil
+			temporary = temporary || err != nil && !IsPermanentError(err)
+		}
+
+		switch {
+		case temporary:
+			s.reply(451, "4.3.0 Error on post message, try again later")
+		case failed:
+			s.reply(554, "5.6.0 Message can not be posted")
+		default:
+			s.reply(250, "2.0.0 Message accepted")
+		}
+	}
+
+	s.reset()
+	return true
+}
+
+// replyEach sends the reply for each recipient in LMTP or once in SMTP
+func (s *receiverSession) replyEach(code int, msg string) {
+	if !*s.r.cfg.LMTP {
+		s.reply(code, "%v", msg)
+		return
+	}
+
+	for range s.recipients {
+		s.reply(code, "%v", msg)
+	}
+}
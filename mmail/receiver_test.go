@@ -0,0 +1,240 @@
+package mmail
+
+import (
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// receiverMock runs the MatterMail loop of a profile saving the subjects received
+type receiverMock struct {
+	provider *MailProviderReceiver
+	subjects []string
+	lock     sync.Mutex
+}
+
+func newReceiverMock(r *Receiver, recipients ...string) *receiverMock {
+	s := &receiverMock{provider: NewMailProviderReceiver(NewLog("", false))}
+	r.Register(recipients, s.provider)
+
+	handler := func(mailReader io.Reader) error {
+		msg, err := ReadMailMessage(mailReader)
+		if err != nil {
+			return err
+		}
+
+		switch msg.Subject {
+		case "fail":
+			return io.ErrUnexpectedEOF
+		case "reject":
+			return &permanentError{errors.New("Did not find any channel to post")}
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.subjects = append(s.subjects, msg.Subject)
+		return nil
+	}
+
+	go func() {
+		for {
+			s.provider.WaitNewMessage(1)
+			s.provider.CheckNewMessage(handler)
+		}
+	}()
+
+	return s
+}
+
+func (s *receiverMock) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.subjects...)
+}
+
+func newTestReceiver(t *testing.T, lmtp bool) (*Receiver, string) {
+	cfg := model.NewReceiver()
+	cfg.Hostname = "mattermail.test"
+	*cfg.LMTP = lmtp
+	*cfg.MaxMessageSize = 1024
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen:", err.Error())
+	}
+
+	r := NewReceiver(cfg, NewLog("", false), false)
+	go r.Serve(l)
+
+	return r, l.Addr().String()
+}
+
+func TestReceiver_SMTP(t *testing.T) {
+	r, addr := newTestReceiver(t, false)
+	orders := newReceiverMock(r, "orders@example.com")
+
+	msg := "Subject: Hello\r\n\r\nHello world\r\n"
+	if err := smtp.SendMail(addr, nil, "john@example.com", []string{"Orders@Example.com"}, []byte(msg)); err != nil {
+		t.Fatal("Error on send mail:", err.Error())
+	}
+
+	if s := orders.received(); len(s) != 1 || s[0] != "Hello" {
+		t.Fatal("Expected subject Hello result:", s)
+	}
+
+	err := smtp.SendMail(addr, nil, "john@example.com", []string{"unknown@example.com"}, []byte(msg))
+	if err == nil || !strings.HasPrefix(err.Error(), "550") {
+		t.Fatal("Expected 550 for unknown recipient err:", err)
+	}
+
+	big := "Subject: Big\r\n\r\n" + strings.Repeat("0123456789abcdef\r\n", 100)
+	err = smtp.SendMail(addr, nil, "john@example.com", []string{"orders@example.com"}, []byte(big))
+	if err == nil || !strings.HasPrefix(err.Error(), "552") {
+		t.Fatal("Expected 552 for message too big err:", err)
+	}
+
+	fail := "Subject: fail\r\n\r\nError on post\r\n"
+	err = smtp.SendMail(addr, nil, "john@example.com", []string{"orders@example.com"}, []byte(fail))
+	if err == nil || !strings.HasPrefix(err.Error(), "451") {
+		t.Fatal("Expected 451 when post fails err:", err)
+	}
+
+	reject := "Subject: reject\r\n\r\nNo channel\r\n"
+	err = smtp.SendMail(addr, nil, "john@example.com", []string{"orders@example.com"}, []byte(reject))
+	if err == nil || !strings.HasPrefix(err.Error(), "554") {
+		t.Fatal("Expected 554 when post fails permanently err:", err)
+	}
+
+	if s := orders.received(); len(s) != 1 {
+		t.Fatal("Expected only the first message result:", s)
+	}
+
+	// errors do not delay the next messages
+	if err := smtp.SendMail(addr, nil, "john@example.com", []string{"orders@example.com"}, []byte(msg)); err != nil {
+		t.Fatal("Error on send mail after errors:", err.Error())
+	}
+
+	if s := orders.received(); len(s) != 2 {
+		t.Fatal("Expected message after errors result:", s)
+	}
+}
+
+func TestReceiver_LMTP(t *testing.T) {
+	r, addr := newTestReceiver(t, true)
+	orders := newReceiverMock(r, "orders@example.com", "sales@example.com")
+	billing := newReceiverMock(r, "billing@example.com")
+
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Cannot connect:", err.Error())
+	}
+	defer conn.Close()
+
+	expect := func(code int) {
+		if _, _, err := conn.ReadResponse(code); err != nil {
+			t.Fatal("Expected code", code, "err:", err)
+		}
+	}
+
+	cmd := func(code int, format string, args ...interface{}) {
+		id, err := conn.Cmd(format, args...)
+		if err != nil {
+			t.Fatal("Error on send command:", err.Error())
+		}
+		conn.StartResponse(id)
+		expect(code)
+		conn.EndResponse(id)
+	}
+
+	expect(220)
+	cmd(500, "EHLO localhost")
+	cmd(250, "LHLO localhost")
+	cmd(552, "MAIL FROM:<john@example.com> SIZE=2048")
+	cmd(250, "MAIL FROM:<john@example.com> SIZE=100")
+	cmd(250, "RCPT TO:<orders@example.com>")
+	cmd(250, "RCPT TO:<sales@example.com>")
+	cmd(550, "RCPT TO:<unknown@example.com>")
+	cmd(250, "RCPT TO:<billing@example.com>")
+	cmd(354, "DATA")
+
+	w := conn.DotWriter()
+	io.WriteString(w, "Subject: Invoice\r\n\r\nInvoice attached\r\n")
+	w.Close()
+
+	// one reply for each accepted recipient
+	expect(250)
+	expect(250)
+	expect(250)
+
+	cmd(221, "QUIT")
+
+	// delivered once for each profile
+	if s := orders.received(); len(s) != 1 || s[0] != "Invoice" {
+		t.Fatal("Expected orders subject Invoice result:", s)
+	}
+
+	if s := billing.received(); len(s) != 1 || s[0] != "Invoice" {
+		t.Fatal("Expected billing subject Invoice result:", s)
+	}
+}
+
+func TestReceiver_DataReadError(t *testing.T) {
+	cfg := model.NewReceiver()
+	*cfg.LMTP = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Cannot listen:", err.Error())
+	}
+
+	r := NewReceiver(cfg, NewLog("", false), false)
+	r.timeout = time.Millisecond * 200
+	go r.Serve(l)
+
+	orders := newReceiverMock(r, "orders@example.com", "sales@example.com")
+
+	conn, err := textproto.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("Cannot connect:", err.Error())
+	}
+	defer conn.Close()
+
+	expect := func(code int) {
+		if _, _, err := conn.ReadResponse(code); err != nil {
+			t.Fatal("Expected code", code, "err:", err)
+		}
+	}
+
+	expect(220)
+	for _, c := range []string{"LHLO localhost", "MAIL FROM:<john@example.com>", "RCPT TO:<orders@example.com>", "RCPT TO:<sales@example.com>"} {
+		conn.PrintfLine("%v", c)
+		expect(250)
+	}
+
+	conn.PrintfLine("DATA")
+	expect(354)
+
+	// the message is not finished until the connection times out
+	conn.PrintfLine("Subject: Lost")
+	conn.PrintfLine("")
+	conn.PrintfLine("RSET")
+
+	expect(451)
+	expect(451)
+
+	if line, err := conn.ReadLine(); err == nil {
+		t.Fatal("Expected connection closed result:", line)
+	}
+
+	if s := orders.received(); len(s) != 0 {
+		t.Fatal("Expected no message delivered result:", s)
+	}
+}
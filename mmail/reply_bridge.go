@@ -0,0 +1,171 @@
+package mmail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	mmModel "github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+	"github.com/rodcorsi/mattermail/model"
+)
+
+// ReplyBridge sends the replies posted in Mattermost threads of emails back as email
+type ReplyBridge struct {
+	cfg        *model.Profile
+	log        Logger
+	mmProvider MattermostProvider
+	sender     MailSender
+	threads    ThreadCache
+}
+
+// NewReplyBridge creates a new ReplyBridge instance
+func NewReplyBridge(cfg *model.Profile, log Logger, mmProvider MattermostProvider, sender MailSender, threads ThreadCache) *ReplyBridge {
+	return &ReplyBridge{
+		cfg:        cfg,
+		log:        log,
+		mmProvider: mmProvider,
+		sender:     sender,
+		threads:    threads,
+	}
+}
+
+// Listen starts to watch replies in Mattermost
+func (r *ReplyBridge) Listen() {
+	r.log.Info("Watching replies in Mattermost threads")
+
+	for {
+		if err := r.watch(); err != nil {
+			r.log.Error("ReplyBridge.Listen Error on watch replies:", err.Error())
+		}
+		r.log.Infof("Try again in %vs", tryAgainTime)
+		time.Sleep(time.Second * tryAgainTime)
+	}
+}
+
+func (r *ReplyBridge) watch() error {
+	if err := r.mmProvider.Login(); err != nil {
+		return errors.Wrap(err, "login on Mattermost to watch replies")
+	}
+
+	defer func() {
+		if err := r.mmProvider.Logout(); err != nil {
+			r.log.Error("Logout error err:", err)
+		}
+	}()
+
+	return r.mmProvider.WatchReplies(r.SendReply)
+}
+
+// SendReply sends the reply as email to the sender of the last email in the thread, or to all
+// addresses of its Reply-To
+func (r *ReplyBridge) SendReply(reply *ThreadReply) error {
+	email, err := r.threads.GetEmail(reply.RootID)
+	if err != nil {
+		return errors.Wrap(err, "get thread email")
+	}
+
+	if email == nil {
+		// thread was not created by Mattermail
+		return nil
+	}
+
+	to, err := mail.ParseAddressList(email.ReplyTo)
+	if err != nil {
+		return errors.Wrapf(err, "parse reply address '%v'", email.ReplyTo)
+	}
+
+	rcpts := make([]string, len(to))
+	for i, a := range to {
+		rcpts[i] = a.Address
+	}
+
+	from, err := mail.ParseAddress(r.cfg.SMTP.From)
+	if err != nil {
+		return errors.Wrapf(err, "parse from address '%v'", r.cfg.SMTP.From)
+	}
+
+	if reply.UserName != "" {
+		from.Name = reply.UserName
+	}
+
+	messageID := newMessageID(from.Address)
+	references := append(append([]string{}, email.References...), email.MessageID)
+
+	r.log.Infof("Send reply of post %v to %v\n", reply.PostID, strings.Join(rcpts, ", "))
+
+	msg := composeReplyEmail(from, to, email.Subject, messageID, email.MessageID, references, reply.Message)
+	if err := r.sender.Send(from.Address, rcpts, msg); err != nil {
+		return errors.Wrap(err, "send reply email")
+	}
+
+	// replies of this email will be posted in the same thread
+	if err := r.threads.SavePostID(reply.ChannelID, messageID, reply.RootID); err != nil {
+		r.log.Error("Error on save thread in cache err:", err)
+	}
+
+	next := &ThreadEmail{
+		MessageID:  messageID,
+		References: references,
+		Subject:    email.Subject,
+		ReplyTo:    email.ReplyTo,
+	}
+
+	if err := r.threads.SaveEmail(reply.RootID, next); err != nil {
+		r.log.Error("Error on save thread email in cache err:", err)
+	}
+
+	return nil
+}
+
+func newMessageID(from string) string {
+	domain := "mattermail"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return mmModel.NewId() + "@" + domain
+}
+
+func composeReplyEmail(from *mail.Address, to []*mail.Address, subject, messageID, inReplyTo string, references []string, message string) []byte {
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+
+	refs := make([]string, len(references))
+	for i, ref := range references {
+		refs[i] = "<" + ref + ">"
+	}
+
+	addresses := make([]string, len(to))
+	for i, a := range to {
+		addresses[i] = a.String()
+	}
+
+	buff := &bytes.Buffer{}
+	header := func(key, value string) {
+		fmt.Fprintf(buff, "%v: %v\r\n", key, value)
+	}
+
+	header("From", from.String())
+	header("To", strings.Join(addresses, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+messageID+">")
+	header("In-Reply-To", "<"+inReplyTo+">")
+	header("References", strings.Join(refs, " "))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buff.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(buff)
+	message = strings.Replace(message, "\r\n", "\n", -1)
+	w.Write([]byte(strings.Replace(message, "\n", "\r\n", -1)))
+	w.Close()
+
+	return buff.Bytes()
+}
@@ -0,0 +1,137 @@
+package mmail
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+func TestReplyBridge_SendReply(t *testing.T) {
+	mock := newSMTPMock(t)
+
+	profile := model.NewProfile()
+	profile.Channels = []string{"#town-square"}
+	profile.SMTP = model.NewSMTP()
+	profile.SMTP.Server = mock.addr
+	profile.SMTP.From = "Orders <orders@example.com>"
+
+	threads := &threadCacheMem{}
+	log := NewLog("", false)
+
+	// email posted by Mattermail
+	mm := NewMatterMail(profile, MailBox, log, nil, &mattermostThreadMock{}, threads)
+	msg := &MailMessage{
+		From:       "John Doe <jdoe@example.com>",
+		Subject:    "Order 1234",
+		MessageID:  "2@example.com",
+		InReplyTo:  "1@example.com",
+		References: []string{"1@example.com"},
+	}
+
+	if err := mm.PostMailMessage(msg); err != nil {
+		t.Fatal("Error on PostMailMessage err:", err.Error())
+	}
+
+	bridge := NewReplyBridge(profile, log, &mattermostMock{}, NewMailSenderSMTP(profile.SMTP, log), threads)
+
+	// reply in a thread that is not an email
+	if err := bridge.SendReply(&ThreadReply{RootID: "other", ChannelID: "id1234", Message: "Hi"}); err != nil {
+		t.Fatal("Error on SendReply err:", err.Error())
+	}
+
+	reply := &ThreadReply{
+		PostID:    "reply1",
+		RootID:    "post1",
+		ChannelID: "id1234",
+		UserName:  "Mary",
+		Message:   "Your order was shipped",
+	}
+
+	if err := bridge.SendReply(reply); err != nil {
+		t.Fatal("Error on SendReply err:", err.Error())
+	}
+
+	<-mock.done
+
+	if len(mock.to) != 1 || mock.to[0] != "jdoe@example.com" {
+		t.Fatal("Expected to jdoe@example.com result:", mock.to)
+	}
+
+	email, err := mail.ReadMessage(strings.NewReader(mock.data))
+	if err != nil {
+		t.Fatal("Error on read sent email err:", err.Error())
+	}
+
+	assertHeader := func(key, expected string) {
+		if value := email.Header.Get(key); value != expected {
+			t.Fatalf("Expected header %v '%v' result:'%v'", key, expected, value)
+		}
+	}
+
+	assertHeader("From", `"Mary" <orders@example.com>`)
+	assertHeader("Subject", "Re: Order 1234")
+	assertHeader("In-Reply-To", "<2@example.com>")
+	assertHeader("References", "<1@example.com> <2@example.com>")
+
+	// answer of the reply email is posted in the same thread
+	messageID := strings.Trim(email.Header.Get("Message-ID"), "<>")
+	if rootID, _ := threads.GetPostID("id1234", messageID); rootID != "post1" {
+		t.Fatal("Expected post1 as root of reply email result:", rootID)
+	}
+
+	last, _ := threads.GetEmail("post1")
+	if last == nil || last.MessageID != messageID || last.ReplyTo != msg.From {
+		t.Fatal("Expected last email of thread updated result:", last)
+	}
+}
+
+func TestReplyBridge_SendReplyAll(t *testing.T) {
+	mock := newSMTPMock(t)
+
+	profile := model.NewProfile()
+	profile.SMTP = model.NewSMTP()
+	profile.SMTP.Server = mock.addr
+	profile.SMTP.From = "orders@example.com"
+
+	threads := &threadCacheMem{}
+	threads.SaveEmail("post1", &ThreadEmail{MessageID: "1@example.com", Subject: "Order", ReplyTo: "Sales <sales@example.com>, support@example.com"})
+
+	log := NewLog("", false)
+	bridge := NewReplyBridge(profile, log, &mattermostMock{}, NewMailSenderSMTP(profile.SMTP, log), threads)
+
+	if err := bridge.SendReply(&ThreadReply{PostID: "reply1", RootID: "post1", ChannelID: "id1234", Message: "Shipped"}); err != nil {
+		t.Fatal("Error on SendReply err:", err.Error())
+	}
+
+	<-mock.done
+
+	if len(mock.to) != 2 || mock.to[0] != "sales@example.com" || mock.to[1] != "support@example.com" {
+		t.Fatal("Expected reply to all addresses of Reply-To result:", mock.to)
+	}
+}
+
+func Test_composeReplyEmail(t *testing.T) {
+	from := &mail.Address{Name: "Mary", Address: "orders@example.com"}
+	to := []*mail.Address{{Address: "jdoe@example.com"}, {Name: "Sales", Address: "sales@example.com"}}
+
+	data := composeReplyEmail(from, to, "RE: Hello", "3@example.com", "2@example.com", []string{"2@example.com"}, "line 1\nline 2")
+
+	email, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal("Error on read email err:", err.Error())
+	}
+
+	if subject := email.Header.Get("Subject"); subject != "RE: Hello" {
+		t.Fatal("Expected subject 'RE: Hello' result:", subject)
+	}
+
+	if list, err := email.Header.AddressList("To"); err != nil || len(list) != 2 || list[1].Address != "sales@example.com" {
+		t.Fatal("Expected two addresses in To result:", email.Header.Get("To"))
+	}
+
+	if !strings.Contains(string(data), "line 1\r\nline 2") {
+		t.Fatalf("Expected body with CRLF result:%q", string(data))
+	}
+}
@@ -0,0 +1,174 @@
+package mmail
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxThreadCacheEntries = 5000
+	maxThreadCacheAge     = time.Hour * 24 * 90
+)
+
+// ThreadCache is a cache of Message-ID to Mattermost post id for each channel
+// and of the last email posted in each thread
+type ThreadCache interface {
+	// GetPostID returns the post id of the thread root for the Message-ID posted in the channel, empty string if not exists
+	GetPostID(channelID, messageID string) (string, error)
+
+	// SavePostID stores the post id of the thread root for the Message-ID posted in the channel
+	SavePostID(channelID, messageID, postID string) error
+
+	// GetEmail returns the last email of the thread with root post id, nil if not exists
+	GetEmail(rootID string) (*ThreadEmail, error)
+
+	// SaveEmail stores the last email of the thread with root post id
+	SaveEmail(rootID string, email *ThreadEmail) error
+}
+
+// ThreadEmail fields of an email used to reply it
+type ThreadEmail struct {
+	MessageID  string
+	References []string `json:",omitempty"`
+	Subject    string
+	ReplyTo    string
+	Time       int64
+}
+
+type threadEntry struct {
+	PostID string
+	Time   int64
+}
+
+// threadMap map[channel id + message id] = threadEntry
+type threadMap map[string]*threadEntry
+
+// threadStore content of ThreadCache
+type threadStore struct {
+	Posts  threadMap
+	Emails map[string]*ThreadEmail
+}
+
+func newThreadStore() *threadStore {
+	return &threadStore{
+		Posts:  make(threadMap),
+		Emails: make(map[string]*ThreadEmail),
+	}
+}
+
+func threadKey(channelID, messageID string) string {
+	return channelID + " " + messageID
+}
+
+func (t *threadStore) getPostID(channelID, messageID string) string {
+	if e, ok := t.Posts[threadKey(channelID, messageID)]; ok {
+		return e.PostID
+	}
+	return ""
+}
+
+func (t *threadStore) savePostID(channelID, messageID, postID string) {
+	now := time.Now()
+	t.Posts[threadKey(channelID, messageID)] = &threadEntry{PostID: postID, Time: now.Unix()}
+	t.prune(now)
+}
+
+func (t *threadStore) saveEmail(rootID string, email *ThreadEmail) {
+	now := time.Now()
+	e := *email
+	e.Time = now.Unix()
+	t.Emails[rootID] = &e
+	t.prune(now)
+}
+
+// prune removes entries older than maxThreadCacheAge and the oldest entries
+// when there are more than maxThreadCacheEntries
+func (t *threadStore) prune(now time.Time) {
+	times := make(map[string]int64, len(t.Posts))
+	for k, e := range t.Posts {
+		times[k] = e.Time
+	}
+	for _, k := range pruneKeys(times, now) {
+		delete(t.Posts, k)
+	}
+
+	times = make(map[string]int64, len(t.Emails))
+	for k, e := range t.Emails {
+		times[k] = e.Time
+	}
+	for _, k := range pruneKeys(times, now) {
+		delete(t.Emails, k)
+	}
+}
+
+// pruneKeys returns the keys that need to be removed to respect the cache bounds
+func pruneKeys(times map[string]int64, now time.Time) []string {
+	limit := now.Add(-maxThreadCacheAge).Unix()
+	keys := make([]string, 0, len(times))
+	for k := range times {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return times[keys[i]] < times[keys[j]]
+	})
+
+	n := 0
+	for n < len(keys) && (times[keys[n]] < limit || len(keys)-n > maxThreadCacheEntries) {
+		n++
+	}
+
+	return keys[:n]
+}
+
+type threadCacheMem struct {
+	store *threadStore
+	lock  sync.RWMutex
+}
+
+// GetPostID returns the post id of the thread root for the Message-ID posted in the channel, empty string if not exists
+func (c *threadCacheMem) GetPostID(channelID, messageID string) (string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.store == nil {
+		return "", nil
+	}
+	return c.store.getPostID(channelID, messageID), nil
+}
+
+// SavePostID stores the post id of the thread root for the Message-ID posted in the channel
+func (c *threadCacheMem) SavePostID(channelID, messageID, postID string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.store == nil {
+		c.store = newThreadStore()
+	}
+	c.store.savePostID(channelID, messageID, postID)
+	return nil
+}
+
+// GetEmail returns the last email of the thread with root post id, nil if not exists
+func (c *threadCacheMem) GetEmail(rootID string) (*ThreadEmail, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.store == nil {
+		return nil, nil
+	}
+	return c.store.Emails[rootID], nil
+}
+
+// SaveEmail stores the last email of the thread with root post id
+func (c *threadCacheMem) SaveEmail(rootID string, email *ThreadEmail) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.store == nil {
+		c.store = newThreadStore()
+	}
+	c.store.saveEmail(rootID, email)
+	return nil
+}
@@ -0,0 +1,214 @@
+package mmail
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cacheLockTimeout = time.Second * 10
+	cacheLockStale   = time.Minute
+	cacheLockRetry   = time.Millisecond * 20
+)
+
+// ThreadCacheFile implements ThreadCache using filesystem to store, the file is shared by the
+// daemon and the processes of deliver command, each change reads the file again holding a lock
+// file and each read reloads the file when it was changed by other process
+type ThreadCacheFile struct {
+	filename string
+	store    *threadStore
+	info     os.FileInfo
+	lock     sync.Mutex
+}
+
+// NewThreadCacheFile return a new ThreadCacheFile
+func NewThreadCacheFile(directory, account string) *ThreadCacheFile {
+	filename := filepath.Join(directory, strings.ToLower(account+"_threads.json"))
+
+	return &ThreadCacheFile{
+		filename: filename,
+	}
+}
+
+// GetPostID returns the post id of the thread root for the Message-ID posted in the channel, empty string if not exists
+func (c *ThreadCacheFile) GetPostID(channelID, messageID string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.load(); err != nil {
+		return "", err
+	}
+
+	return c.store.getPostID(channelID, messageID), nil
+}
+
+// SavePostID stores the post id of the thread root for the Message-ID posted in the channel
+func (c *ThreadCacheFile) SavePostID(channelID, messageID, postID string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.update(func(store *threadStore) {
+		store.savePostID(channelID, messageID, postID)
+	})
+}
+
+// GetEmail returns the last email of the thread with root post id, nil if not exists
+func (c *ThreadCacheFile) GetEmail(rootID string) (*ThreadEmail, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c.store.Emails[rootID], nil
+}
+
+// SaveEmail stores the last email of the thread with root post id
+func (c *ThreadCacheFile) SaveEmail(rootID string, email *ThreadEmail) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.update(func(store *threadStore) {
+		store.saveEmail(rootID, email)
+	})
+}
+
+// load reads the file on first access and again when its modification time changes, the file
+// is replaced on each save so a new file is also reloaded
+func (c *ThreadCacheFile) load() error {
+	info, err := os.Stat(c.filename)
+	if os.IsNotExist(err) {
+		if c.store == nil || c.info != nil {
+			c.store = newThreadStore()
+			c.info = nil
+		}
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "Error on read file '%v'", c.filename)
+	}
+
+	if c.store != nil && c.info != nil && os.SameFile(c.info, info) && c.info.ModTime().Equal(info.ModTime()) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(c.filename)
+	if err != nil {
+		return errors.Wrapf(err, "Error on read file '%v'", c.filename)
+	}
+
+	store := newThreadStore()
+	if err := json.Unmarshal(data, store); err != nil {
+		return errors.Wrapf(err, "Error on read file '%v' invalid content", c.filename)
+	}
+
+	if store.Posts == nil {
+		store.Posts = make(threadMap)
+	}
+
+	if store.Emails == nil {
+		store.Emails = make(map[string]*ThreadEmail)
+	}
+
+	c.store = store
+	c.info = info
+	return nil
+}
+
+// update reads the file again and saves the change holding the lock file, the changes of other
+// processes are kept
+func (c *ThreadCacheFile) update(change func(store *threadStore)) error {
+	unlock, err := lockFile(c.filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c.store = nil
+	if err := c.load(); err != nil {
+		return err
+	}
+
+	change(c.store)
+	return c.save()
+}
+
+func (c *ThreadCacheFile) save() error {
+	data, err := json.Marshal(c.store)
+	if err != nil {
+		return errors.Wrap(err, "marshal threads")
+	}
+
+	if err := writeFileAtomic(c.filename, data, 0640); err != nil {
+		return err
+	}
+
+	// the file written is the current store, it is not read again
+	c.info, _ = os.Stat(c.filename)
+	return nil
+}
+
+// lockFile creates the lock file of filename and returns the function to remove it, waits
+// while other process holds the lock. The lock file older than cacheLockStale was left by a
+// process killed and is removed
+func lockFile(filename string) (func(), error) {
+	lock := filename + ".lock"
+	deadline := time.Now().Add(cacheLockTimeout)
+
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, errors.Wrapf(err, "Error on create lock file '%v'", lock)
+		}
+
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > cacheLockStale {
+			os.Remove(lock)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("Timeout waiting lock file '%v'", lock)
+		}
+		time.Sleep(cacheLockRetry)
+	}
+}
+
+// writeFileAtomic writes data in a temporary file renamed to filename, the file is never read
+// partially written
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "Error on create temporary file of '%v'", filename)
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "Error on write file '%v'", filename)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package mmail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThreadCacheFile(t *testing.T) {
+	cache := NewThreadCacheFile(os.TempDir(), "test@example.com")
+	defer os.Remove(cache.filename)
+
+	if id, err := cache.GetPostID("channel1", "1@example.com"); err != nil || id != "" {
+		t.Fatalf("Expected empty post id result:%v err:%v", id, err)
+	}
+
+	if err := cache.SavePostID("channel1", "1@example.com", "post1"); err != nil {
+		t.Fatal("Error on save post id", err.Error())
+	}
+
+	if err := cache.SaveEmail("post1", &ThreadEmail{MessageID: "1@example.com", Subject: "Hello"}); err != nil {
+		t.Fatal("Error on save email", err.Error())
+	}
+
+	// read from file
+	cache = NewThreadCacheFile(os.TempDir(), "test@example.com")
+
+	if id, err := cache.GetPostID("channel1", "1@example.com"); err != nil || id != "post1" {
+		t.Fatalf("Expected post1 result:%v err:%v", id, err)
+	}
+
+	if id, _ := cache.GetPostID("channel2", "1@example.com"); id != "" {
+		t.Fatal("Expected empty post id for other channel result:", id)
+	}
+
+	if email, err := cache.GetEmail("post1"); err != nil || email == nil || email.Subject != "Hello" {
+		t.Fatalf("Expected email with subject Hello result:%v err:%v", email, err)
+	}
+
+	// changes of other process are read by the cache already loaded
+	other := NewThreadCacheFile(os.TempDir(), "test@example.com")
+	if err := other.SavePostID("channel1", "2@example.com", "post2"); err != nil {
+		t.Fatal("Error on save post id", err.Error())
+	}
+
+	if id, err := cache.GetPostID("channel1", "2@example.com"); err != nil || id != "post2" {
+		t.Fatalf("Expected post2 saved by other process result:%v err:%v", id, err)
+	}
+
+	if err := other.SaveEmail("post2", &ThreadEmail{MessageID: "2@example.com", Subject: "Again"}); err != nil {
+		t.Fatal("Error on save email", err.Error())
+	}
+
+	if email, err := cache.GetEmail("post2"); err != nil || email == nil || email.Subject != "Again" {
+		t.Fatalf("Expected email saved by other process result:%v err:%v", email, err)
+	}
+
+	ioutil.WriteFile(cache.filename, []byte("invalid"), 0640)
+	cache = NewThreadCacheFile(os.TempDir(), "test@example.com")
+
+	if _, err := cache.GetPostID("channel1", "1@example.com"); err == nil {
+		t.Fatal("Expected error invalid content")
+	}
+}
+
+func TestThreadCacheFile_Processes(t *testing.T) {
+	filename := NewThreadCacheFile(os.TempDir(), "processes@example.com").filename
+	defer os.Remove(filename)
+
+	// each deliver process has its own cache
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache := NewThreadCacheFile(os.TempDir(), "processes@example.com")
+			if err := cache.SavePostID("channel1", fmt.Sprintf("%v@example.com", i), fmt.Sprintf("post%v", i)); err != nil {
+				t.Error("Error on save post id", err.Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cache := NewThreadCacheFile(os.TempDir(), "processes@example.com")
+	for i := 0; i < 10; i++ {
+		if id, err := cache.GetPostID("channel1", fmt.Sprintf("%v@example.com", i)); err != nil || id != fmt.Sprintf("post%v", i) {
+			t.Fatalf("Expected post%v result:%v err:%v", i, id, err)
+		}
+	}
+
+	// lock file left by a killed process
+	lock := filename + ".lock"
+	ioutil.WriteFile(lock, nil, 0640)
+	old := time.Now().Add(-cacheLockStale * 2)
+	os.Chtimes(lock, old, old)
+
+	if err := cache.SavePostID("channel1", "stale@example.com", "post10"); err != nil {
+		t.Fatal("Expected stale lock file removed err:", err)
+	}
+
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Fatal("Expected lock file removed after save err:", err)
+	}
+}
@@ -0,0 +1,77 @@
+package mmail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_threadCacheMem(t *testing.T) {
+	cache := &threadCacheMem{}
+
+	if id, err := cache.GetPostID("channel1", "1@example.com"); err != nil || id != "" {
+		t.Fatalf("Expected empty post id result:%v err:%v", id, err)
+	}
+
+	if err := cache.SavePostID("channel1", "1@example.com", "post1"); err != nil {
+		t.Fatal("Error on save post id", err.Error())
+	}
+
+	if id, err := cache.GetPostID("channel1", "1@example.com"); err != nil || id != "post1" {
+		t.Fatalf("Expected post1 result:%v err:%v", id, err)
+	}
+
+	if id, _ := cache.GetPostID("channel2", "1@example.com"); id != "" {
+		t.Fatal("Expected empty post id for other channel result:", id)
+	}
+
+	if email, err := cache.GetEmail("post1"); err != nil || email != nil {
+		t.Fatalf("Expected nil email result:%v err:%v", email, err)
+	}
+
+	if err := cache.SaveEmail("post1", &ThreadEmail{MessageID: "1@example.com", Subject: "Hello"}); err != nil {
+		t.Fatal("Error on save email", err.Error())
+	}
+
+	if email, err := cache.GetEmail("post1"); err != nil || email == nil || email.MessageID != "1@example.com" {
+		t.Fatalf("Expected email 1@example.com result:%v err:%v", email, err)
+	}
+}
+
+func Test_threadStore_prune(t *testing.T) {
+	now := time.Now()
+	store := newThreadStore()
+
+	store.Posts[threadKey("c", "old")] = &threadEntry{PostID: "old", Time: now.Add(-maxThreadCacheAge - time.Hour).Unix()}
+	store.Emails["old"] = &ThreadEmail{Time: now.Add(-maxThreadCacheAge - time.Hour).Unix()}
+	store.Emails["new"] = &ThreadEmail{Time: now.Unix()}
+	for i := 0; i < maxThreadCacheEntries+10; i++ {
+		store.Posts[threadKey("c", fmt.Sprint(i))] = &threadEntry{PostID: fmt.Sprint(i), Time: now.Unix() - int64(maxThreadCacheEntries+10-i)}
+	}
+
+	store.prune(now)
+
+	if len(store.Posts) != maxThreadCacheEntries {
+		t.Fatalf("Expected %v entries result:%v", maxThreadCacheEntries, len(store.Posts))
+	}
+
+	if _, ok := store.Posts[threadKey("c", "old")]; ok {
+		t.Fatal("Expected old entry removed")
+	}
+
+	if _, ok := store.Posts[threadKey("c", "9")]; ok {
+		t.Fatal("Expected oldest entries removed")
+	}
+
+	if _, ok := store.Posts[threadKey("c", "10")]; !ok {
+		t.Fatal("Expected newest entries kept")
+	}
+
+	if _, ok := store.Emails["old"]; ok {
+		t.Fatal("Expected old email removed")
+	}
+
+	if _, ok := store.Emails["new"]; !ok {
+		t.Fatal("Expected new email kept")
+	}
+}
@@ -0,0 +1,611 @@
+package mmail
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// TNEF (Transport Neutral Encapsulation Format) is used by Outlook to send the attachments and
+// the formatted body of emails in winmail.dat, see [MS-OXTNEF], [MS-OXRTFCP] and [MS-OXRTFEX]
+const (
+	tnefSignature = 0x223e9f78
+
+	tnefLevelMessage    = 1
+	tnefLevelAttachment = 2
+
+	tnefAttBody           = 0x0002800c
+	tnefAttMsgProps       = 0x00069003
+	tnefAttAttachRendData = 0x00069002
+	tnefAttAttachTitle    = 0x00018010
+	tnefAttAttachData     = 0x0006800f
+	tnefAttAttachment     = 0x00069005
+)
+
+// MAPI properties and types used in TNEF
+const (
+	mapiBody               = 0x1000
+	mapiRTFCompressed      = 0x1009
+	mapiBodyHTML           = 0x1013
+	mapiAttachDataObj      = 0x3701
+	mapiAttachFilename     = 0x3704
+	mapiAttachLongFilename = 0x3707
+
+	mapiTypeString8  = 0x001e
+	mapiTypeUnicode  = 0x001f
+	mapiTypeBinary   = 0x0102
+	mapiTypeObject   = 0x000d
+	mapiTypeMultiple = 0x1000
+)
+
+var errTNEFShort = errors.New("unexpected end of TNEF data")
+
+// tnefMessage attachments and body of winmail.dat, HTML is read from the HTML body or from the
+// HTML encapsulated in RTF body
+type tnefMessage struct {
+	Text        string
+	HTML        string
+	Attachments []*Attachment
+}
+
+// tnefAttachment fields of an attachment read from attributes and MAPI properties
+type tnefAttachment struct {
+	Title string
+	Data  []byte
+	Props map[uint16]*mapiProperty
+}
+
+// mapiProperty type and first value of a MAPI property
+type mapiProperty struct {
+	Type  uint16
+	Value []byte
+}
+
+// String decodes the value of string properties
+func (p *mapiProperty) String() string {
+	switch p.Type &^ mapiTypeMultiple {
+	case mapiTypeUnicode:
+		return utf16String(p.Value)
+	case mapiTypeString8:
+		return ansiString(p.Value)
+	}
+	return string(p.Value)
+}
+
+// tnefBuffer reads the little-endian values of TNEF
+type tnefBuffer struct {
+	data []byte
+}
+
+func (b *tnefBuffer) next(n uint32) ([]byte, error) {
+	if n > uint32(len(b.data)) {
+		return nil, errTNEFShort
+	}
+	v := b.data[:n]
+	b.data = b.data[n:]
+	return v, nil
+}
+
+func (b *tnefBuffer) uint16() (uint16, error) {
+	v, err := b.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(v), nil
+}
+
+func (b *tnefBuffer) uint32() (uint32, error) {
+	v, err := b.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(v), nil
+}
+
+// isTNEFPart returns true for winmail.dat attachments
+func isTNEFPart(p *enmime.Part) bool {
+	return p.ContentType == "application/ms-tnef" || p.ContentType == "application/vnd.ms-tnef" ||
+		strings.EqualFold(p.FileName, "winmail.dat")
+}
+
+// readTNEF decodes the attachments and body of winmail.dat, attachments without data like
+// embedded messages are ignored
+func readTNEF(data []byte) (*tnefMessage, error) {
+	b := &tnefBuffer{data}
+
+	if sig, err := b.uint32(); err != nil || sig != tnefSignature {
+		return nil, errors.New("invalid TNEF signature")
+	}
+
+	// legacy key
+	if _, err := b.next(2); err != nil {
+		return nil, err
+	}
+
+	var (
+		msg         = &tnefMessage{}
+		msgProps    map[uint16]*mapiProperty
+		attachments []*tnefAttachment
+	)
+
+	for len(b.data) > 0 {
+		level, err := b.next(1)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := b.uint32()
+		if err != nil {
+			return nil, err
+		}
+
+		length, err := b.uint32()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := b.next(length)
+		if err != nil {
+			return nil, err
+		}
+
+		// checksum
+		if _, err := b.next(2); err != nil {
+			return nil, err
+		}
+
+		if id == tnefAttAttachRendData {
+			attachments = append(attachments, &tnefAttachment{})
+			continue
+		}
+
+		switch level[0] {
+		case tnefLevelMessage:
+			switch id {
+			case tnefAttBody:
+				msg.Text = ansiString(value)
+			case tnefAttMsgProps:
+				if msgProps, err = readMAPIProperties(value); err != nil {
+					return nil, errors.Wrap(err, "read message properties")
+				}
+			}
+
+		case tnefLevelAttachment:
+			if len(attachments) == 0 {
+				continue
+			}
+
+			a := attachments[len(attachments)-1]
+			switch id {
+			case tnefAttAttachTitle:
+				a.Title = ansiString(value)
+			case tnefAttAttachData:
+				a.Data = value
+			case tnefAttAttachment:
+				if a.Props, err = readMAPIProperties(value); err != nil {
+					return nil, errors.Wrap(err, "read attachment properties")
+				}
+			}
+		}
+	}
+
+	for i, a := range attachments {
+		if attachment := a.attachment(i + 1); attachment != nil {
+			msg.Attachments = append(msg.Attachments, attachment)
+		}
+	}
+
+	if p := msgProps[mapiBody]; p != nil && strings.TrimSpace(msg.Text) == "" {
+		msg.Text = p.String()
+	}
+
+	if p := msgProps[mapiBodyHTML]; p != nil {
+		msg.HTML = p.String()
+	}
+
+	if p := msgProps[mapiRTFCompressed]; p != nil && (msg.HTML == "" || strings.TrimSpace(msg.Text) == "") {
+		rtf, err := decompressRTF(p.Value)
+		if err != nil {
+			return nil, errors.Wrap(err, "decompress RTF body")
+		}
+
+		text, html := rtfBody(rtf)
+		if msg.HTML == "" {
+			msg.HTML = html
+		}
+		if strings.TrimSpace(msg.Text) == "" {
+			msg.Text = text
+		}
+	}
+
+	return msg, nil
+}
+
+// attachment returns the attachment with the long filename of MAPI properties or nil if it has
+// no data, n is used to name attachments without filename
+func (a *tnefAttachment) attachment(n int) *Attachment {
+	data := a.Data
+	if p := a.Props[mapiAttachDataObj]; p != nil && p.Type == mapiTypeBinary {
+		data = p.Value
+	}
+
+	if data == nil {
+		return nil
+	}
+
+	filename := a.Title
+	for _, id := range []uint16{mapiAttachFilename, mapiAttachLongFilename} {
+		if p := a.Props[id]; p != nil && p.String() != "" {
+			filename = p.String()
+		}
+	}
+
+	if filename == "" {
+		filename = fmt.Sprintf("attachment%d", n)
+	}
+
+	return &Attachment{Filename: removeNonUTF8(filename), Content: data}
+}
+
+// readMAPIProperties returns the first value of MAPI properties by id, named properties are
+// ignored
+func readMAPIProperties(data []byte) (map[uint16]*mapiProperty, error) {
+	b := &tnefBuffer{data}
+	count, err := b.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	props := make(map[uint16]*mapiProperty)
+	for i := uint32(0); i < count; i++ {
+		typ, err := b.uint16()
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := b.uint16()
+		if err != nil {
+			return nil, err
+		}
+
+		if id >= 0x8000 {
+			if err := skipMAPIName(b); err != nil {
+				return nil, err
+			}
+		}
+
+		values, err := readMAPIValues(b, typ)
+		if err != nil {
+			return nil, err
+		}
+
+		if id < 0x8000 && len(values) > 0 {
+			props[id] = &mapiProperty{Type: typ, Value: values[0]}
+		}
+	}
+	return props, nil
+}
+
+// skipMAPIName skips the GUID and the id or name of named property
+func skipMAPIName(b *tnefBuffer) error {
+	if _, err := b.next(16); err != nil {
+		return err
+	}
+
+	kind, err := b.uint32()
+	if err != nil {
+		return err
+	}
+
+	if kind == 0 {
+		_, err = b.next(4)
+		return err
+	}
+
+	length, err := b.uint32()
+	if err != nil {
+		return err
+	}
+	_, err = b.next(padding4(length))
+	return err
+}
+
+// readMAPIValues reads the values of property, variable length and multiple values have a
+// count and the values are padded to 4 bytes
+func readMAPIValues(b *tnefBuffer, typ uint16) ([][]byte, error) {
+	base := typ &^ mapiTypeMultiple
+
+	var size uint32
+	switch base {
+	case 0x0002, 0x0003, 0x0004, 0x000a, 0x000b:
+		size = 4
+	case 0x0005, 0x0006, 0x0007, 0x0014, 0x0040:
+		size = 8
+	case 0x0048:
+		size = 16
+	case mapiTypeString8, mapiTypeUnicode, mapiTypeBinary, mapiTypeObject:
+	default:
+		return nil, fmt.Errorf("unknown MAPI property type 0x%04x", typ)
+	}
+
+	count := uint32(1)
+	if size == 0 || typ&mapiTypeMultiple != 0 {
+		var err error
+		if count, err = b.uint32(); err != nil {
+			return nil, err
+		}
+	}
+
+	var values [][]byte
+	for i := uint32(0); i < count; i++ {
+		if size > 0 {
+			v, err := b.next(size)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			continue
+		}
+
+		length, err := b.uint32()
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := b.next(length)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := b.next(padding4(length) - length); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func padding4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
+
+// utf16String decodes UTF-16LE string terminated by null
+func utf16String(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+}
+
+// ansiString decodes 8-bit string terminated by null, Outlook uses windows-1252 by default
+func ansiString(b []byte) string {
+	s, err := charmap.Windows1252.NewDecoder().Bytes(b)
+	if err != nil {
+		return strings.TrimRight(string(b), "\x00")
+	}
+	return strings.TrimRight(string(s), "\x00")
+}
+
+// rtfPrebuf initial dictionary of compressed RTF
+const rtfPrebuf = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}{\\f0\\fnil \\froman \\fswiss \\fmodern " +
+	"\\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier{\\colortbl\\red0\\green0\\blue0" +
+	"\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+// decompressRTF decodes the RTF body of Outlook compressed with LZFu
+func decompressRTF(data []byte) ([]byte, error) {
+	b := &tnefBuffer{data}
+	header := make([]uint32, 4) // compressed size, raw size, type, crc
+	for i := range header {
+		v, err := b.uint32()
+		if err != nil {
+			return nil, err
+		}
+		header[i] = v
+	}
+
+	switch header[2] {
+	case 0x414c454d: // MELA, uncompressed
+		return b.next(header[1])
+	case 0x75465a4c: // LZFu
+	default:
+		return nil, errors.New("unknown compression of RTF")
+	}
+
+	in, err := b.next(header[0] - 12)
+	if err != nil {
+		return nil, err
+	}
+
+	var dict [4096]byte
+	copy(dict[:], rtfPrebuf)
+	pos := len(rtfPrebuf)
+
+	var out []byte
+	write := func(c byte) {
+		out = append(out, c)
+		dict[pos] = c
+		pos = (pos + 1) % len(dict)
+	}
+
+	for i := 0; i < len(in); {
+		control := in[i]
+		i++
+		for bit := uint(0); bit < 8 && i < len(in); bit++ {
+			if control&(1<<bit) == 0 {
+				write(in[i])
+				i++
+				continue
+			}
+
+			if i+1 >= len(in) {
+				return nil, errTNEFShort
+			}
+
+			ref := int(in[i])<<8 | int(in[i+1])
+			i += 2
+
+			offset, length := ref>>4, ref&0xf+2
+			if offset == pos {
+				return out, nil
+			}
+
+			for j := 0; j < length; j++ {
+				write(dict[(offset+j)%len(dict)])
+			}
+		}
+	}
+	return out, nil
+}
+
+// rtfSkipDestinations groups of RTF without text of the body
+var rtfSkipDestinations = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true, "pict": true,
+	"header": true, "footer": true, "object": true, "listtable": true, "listoverridetable": true,
+	"rsidtbl": true, "generator": true, "xmlnstbl": true, "themedata": true, "latentstyles": true,
+	"datastore": true, "colorschememapping": true, "mhtmltag": true,
+}
+
+// rtfSymbols control words with the text of them
+var rtfSymbols = map[string]string{
+	"par": "\n", "line": "\n", "tab": "\t", "emdash": "\u2014", "endash": "\u2013",
+	"bullet": "\u2022", "lquote": "\u2018", "rquote": "\u2019", "ldblquote": "\u201c",
+	"rdblquote": "\u201d",
+}
+
+// rtfGroup state of RTF group
+type rtfGroup struct {
+	skip    bool // destination ignored
+	htmlrtf bool // RTF only content of encapsulated HTML
+	uc      int  // characters after \u to skip
+}
+
+// rtfBody returns the text of RTF or the HTML encapsulated by Outlook (\fromhtml), only one of
+// them is returned
+func rtfBody(rtf []byte) (string, string) {
+	var (
+		out      []rune
+		html     bool
+		group    = rtfGroup{uc: 1}
+		stack    []rtfGroup
+		skipNext int // fallback characters of \u
+		ignoring bool
+	)
+
+	emit := func(s string) {
+		if group.skip || (html && group.htmlrtf) {
+			return
+		}
+		out = append(out, []rune(s)...)
+	}
+
+	char := func(s string) {
+		if skipNext > 0 {
+			skipNext--
+			return
+		}
+		emit(s)
+	}
+
+	for i := 0; i < len(rtf); i++ {
+		c := rtf[i]
+		switch c {
+		case '{':
+			stack = append(stack, group)
+			skipNext = 0
+		case '}':
+			if len(stack) > 0 {
+				group = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			skipNext = 0
+		case '\r', '\n':
+		case '\\':
+			if i+1 >= len(rtf) {
+				break
+			}
+
+			i++
+			c = rtf[i]
+			switch {
+			case c == '\\' || c == '{' || c == '}':
+				char(string(c))
+			case c == '\'':
+				if i+2 < len(rtf) {
+					if v, err := strconv.ParseUint(string(rtf[i+1:i+3]), 16, 8); err == nil {
+						char(ansiString([]byte{byte(v)}))
+					}
+					i += 2
+				}
+			case c == '*':
+				ignoring = true
+			case c == '~':
+				char(" ")
+			case c == '\r' || c == '\n':
+				emit("\n")
+			case isRTFLetter(c):
+				start := i
+				for i < len(rtf) && isRTFLetter(rtf[i]) {
+					i++
+				}
+				word := string(rtf[start:i])
+
+				pstart := i
+				if i < len(rtf) && rtf[i] == '-' {
+					i++
+				}
+				for i < len(rtf) && rtf[i] >= '0' && rtf[i] <= '9' {
+					i++
+				}
+				param, hasParam := 0, i > pstart
+				if hasParam {
+					param, _ = strconv.Atoi(string(rtf[pstart:i]))
+				}
+
+				// space delimiter is part of control word
+				if i >= len(rtf) || rtf[i] != ' ' {
+					i--
+				}
+
+				switch {
+				case word == "fromhtml":
+					html = true
+				case word == "htmltag":
+					group.htmlrtf = false
+				case word == "htmlrtf":
+					group.htmlrtf = !hasParam || param != 0
+				case word == "uc":
+					group.uc = param
+				case word == "u":
+					if param < 0 {
+						param += 65536
+					}
+					char(string(rune(param)))
+					skipNext = group.uc
+				case rtfSkipDestinations[word] || ignoring:
+					group.skip = true
+				case rtfSymbols[word] != "":
+					char(rtfSymbols[word])
+				}
+				ignoring = false
+			}
+		default:
+			char(string(c))
+		}
+	}
+
+	if html {
+		return "", strings.TrimSpace(string(out))
+	}
+	return strings.TrimSpace(string(out)), ""
+}
+
+func isRTFLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
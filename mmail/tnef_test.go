@@ -0,0 +1,129 @@
+package mmail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rodcorsi/mattermail/model"
+)
+
+func TestReadMailMessageTNEF(t *testing.T) {
+	mm := readEml(t, "winmail.eml")
+
+	if len(mm.Attachments) != 2 {
+		t.Fatalf("Expected 2 attachments of winmail.dat result:%+v", mm.Attachments)
+	}
+
+	// long filename of MAPI properties is used, embedded message is ignored
+	if a := mm.Attachments[0]; a.Filename != "report 2017.txt" || string(a.Content) != "quarterly numbers\n" {
+		t.Fatalf("Expected report 2017.txt result:%v %q", a.Filename, a.Content)
+	}
+
+	if a := mm.Attachments[1]; a.Filename != "café.csv" || string(a.Content) != "a;b\n1;2\n" {
+		t.Fatalf("Expected café.csv result:%v %q", a.Filename, a.Content)
+	}
+
+	// email without text uses the HTML encapsulated in RTF body
+	if mm.EmailType != EmailTypeHTML || !mm.HTMLOnly {
+		t.Fatalf("Expected HTML body of winmail.dat type:%v htmlOnly:%v", mm.EmailType, mm.HTMLOnly)
+	}
+
+	if !strings.Contains(mm.EmailBody, "<p>The <b>report</b> is attached, café € 10</p>") {
+		t.Fatal("Expected HTML body result:", mm.EmailBody)
+	}
+
+	if mm.EmailMarkdown != "Hi team,\n\nThe **report** is attached, café € 10" {
+		t.Fatalf("Expected markdown of HTML body result:%q", mm.EmailMarkdown)
+	}
+
+	if !strings.Contains(mm.EmailText, "Hi team,") {
+		t.Fatal("Expected text of HTML body result:", mm.EmailText)
+	}
+}
+
+func TestCreateMattermostPostTNEF(t *testing.T) {
+	cfg := model.NewProfile()
+	cfg.Name = "test"
+	cfg.Channels = []string{"#channel1"}
+
+	getChannelID := func(channelName string) (string, error) {
+		return channelName, nil
+	}
+
+	msg := readEml(t, "winmail.eml")
+
+	limits := *defaultLimits
+	limits.MaxAttachments = 2
+	mP, err := createMattermostPost(msg, cfg, &limits, NewLog("test", false), getChannelID)
+	if err != nil {
+		t.Fatalf("error on create mattermostPost %v", err)
+	}
+
+	if len(mP.attachments) != 2 || mP.attachments[0].Filename != "email.html" || mP.attachments[1].Filename != "report 2017.txt" {
+		t.Fatalf("expected email.html and report 2017.txt found %v", mP.attachments)
+	}
+}
+
+func Test_readTNEF(t *testing.T) {
+	if _, err := readTNEF([]byte("not tnef")); err == nil {
+		t.Fatal("Expected error for invalid signature")
+	}
+
+	// attribute larger than data
+	data := []byte{0x78, 0x9f, 0x3e, 0x22, 0, 0, 1, 0x06, 0x90, 0x08, 0, 0xff, 0, 0, 0}
+	if _, err := readTNEF(data); err == nil {
+		t.Fatal("Expected error for truncated data")
+	}
+
+	// empty winmail.dat
+	msg, err := readTNEF(data[:6])
+	if err != nil || len(msg.Attachments) != 0 || msg.Text != "" || msg.HTML != "" {
+		t.Fatalf("Expected empty message result:%+v %v", msg, err)
+	}
+}
+
+func Test_decompressRTF(t *testing.T) {
+	// example of [MS-OXRTFCP]
+	data := []byte{
+		0x2d, 0x00, 0x00, 0x00, 0x2b, 0x00, 0x00, 0x00, 0x4c, 0x5a, 0x46, 0x75, 0xf1, 0xc5, 0xc7, 0xa7,
+		0x03, 0x00, 0x0a, 0x00, 0x72, 0x63, 0x70, 0x67, 0x31, 0x32, 0x35, 0x42, 0x32, 0x0a, 0xf3, 0x20,
+		0x68, 0x65, 0x6c, 0x09, 0x00, 0x20, 0x62, 0x77, 0x05, 0xb0, 0x6c, 0x64, 0x7d, 0x0a, 0x80, 0x0f,
+		0xa0,
+	}
+
+	rtf, err := decompressRTF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n"; string(rtf) != want {
+		t.Fatalf("Expected %q result:%q", want, rtf)
+	}
+
+	data[8] = 0
+	if _, err := decompressRTF(data); err == nil {
+		t.Fatal("Expected error for unknown compression")
+	}
+}
+
+func Test_rtfBody(t *testing.T) {
+	tests := []struct {
+		name string
+		rtf  string
+		text string
+		html string
+	}{
+		{"text", `{\rtf1\ansi{\fonttbl{\f0 Arial;}}\f0 Hello\par world}`, "Hello\nworld", ""},
+		{"escapes", `{\rtf1 caf\'e9 \{x\} \u8364? \ldblquote ok\rdblquote}`, "café {x} € “ok”", ""},
+		{"ignored destination", `{\rtf1{\*\generator Riched20;}text}`, "text", ""},
+		{"html", `{\rtf1\fromhtml1{\*\htmltag64 <p>}a\htmlrtf \par \htmlrtf0 {\*\htmltag72 </p>}}`, "", "<p>a</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, html := rtfBody([]byte(tt.rtf))
+			if text != tt.text || html != tt.html {
+				t.Errorf("rtfBody() = %q %q, want %q %q", text, html, tt.text, tt.html)
+			}
+		})
+	}
+}
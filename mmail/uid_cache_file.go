@@ -1,7 +1,9 @@
 package mmail
 
 import (
+	"crypto/sha1"
 	"encoding/binary"
+	"encoding/hex"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -19,13 +21,26 @@ type UIDCacheFile struct {
 
 // NewUIDCacheFile return a new UIDCacheFile
 func NewUIDCacheFile(directory, account, mailbox string) *UIDCacheFile {
-	filename := filepath.Join(directory, strings.ToLower(account+"_"+mailbox+".dat"))
+	filename := filepath.Join(directory, strings.ToLower(account)+"_"+mailboxFilename(mailbox)+".dat")
 
 	return &UIDCacheFile{
 		filename: filename,
 	}
 }
 
+// mailboxFilename returns the name of mailbox used in the cache filename, the names are case
+// sensitive and can be a hierarchy like Alerts/Prod, so a hash of the name is added to the
+// readable name. INBOX is case insensitive and keeps the name of previous versions
+func mailboxFilename(mailbox string) string {
+	if strings.EqualFold(mailbox, MailBox) {
+		return "inbox"
+	}
+
+	hash := sha1.Sum([]byte(mailbox))
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(strings.ToLower(mailbox))
+	return name + "_" + hex.EncodeToString(hash[:6])
+}
+
 // GetNextUID returns the next uid for the uidvalidity, if empty or is an invalid uidvalidity returns ErrEmptyUID
 func (u *UIDCacheFile) GetNextUID(uidvalidity uint32) (uint32, error) {
 	u.lock.RLock()
@@ -3,6 +3,8 @@ package mmail
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -48,3 +50,26 @@ func TestUIDCacheFile_SaveNextUID(t *testing.T) {
 		t.Fatal("Error on save next uid", err.Error())
 	}
 }
+
+func TestNewUIDCacheFile(t *testing.T) {
+	cache := NewUIDCacheFile(os.TempDir(), "Test3@example.com", "Inbox")
+
+	if filepath.Base(cache.filename) != "test3@example.com_inbox.dat" {
+		t.Fatal("Expected file test3@example.com_inbox.dat result:", cache.filename)
+	}
+
+	cache = NewUIDCacheFile(os.TempDir(), "test3@example.com", "Alerts/Prod")
+
+	if !strings.HasPrefix(filepath.Base(cache.filename), "test3@example.com_alerts_prod_") {
+		t.Fatal("Expected file test3@example.com_alerts_prod_<hash>.dat result:", cache.filename)
+	}
+
+	filenames := map[string]string{}
+	for _, mailbox := range []string{"INBOX", "Alerts/Prod", "Alerts_Prod", "alerts/prod", "Alerts\\Prod"} {
+		filename := NewUIDCacheFile(os.TempDir(), "test3@example.com", mailbox).filename
+		if other, ok := filenames[strings.ToLower(filename)]; ok {
+			t.Fatalf("Expected different files to %q and %q result: %v", mailbox, other, filename)
+		}
+		filenames[strings.ToLower(filename)] = mailbox
+	}
+}
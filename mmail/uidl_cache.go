@@ -0,0 +1,90 @@
+package mmail
+
+import (
+	"sync"
+)
+
+// UIDLCache is a cache of the pop3 unique-id listing (UIDL) of the messages processed for account
+type UIDLCache interface {
+	// IsProcessed returns true if the message with the uidl was processed
+	IsProcessed(uidl string) (bool, error)
+
+	// SaveProcessed stores the uidl as processed
+	SaveProcessed(uidl string) error
+
+	// Retain removes the uidls that are not in the list, used to forget the messages removed from server
+	Retain(uidls []string) error
+
+	// Initialized returns false if nothing was stored for the account yet, on the first check
+	Initialized() (bool, error)
+
+	// Seed stores the uidls as processed replacing the cache, used on the first check to skip
+	// the messages that are already in the server
+	Seed(uidls []string) error
+}
+
+// retainUIDLs returns the processed uidls that are in the list
+func retainUIDLs(processed map[string]bool, uidls []string) map[string]bool {
+	retained := make(map[string]bool)
+	for _, uidl := range uidls {
+		if processed[uidl] {
+			retained[uidl] = true
+		}
+	}
+	return retained
+}
+
+type uidlCacheMem struct {
+	processed map[string]bool
+	lock      sync.RWMutex
+}
+
+// IsProcessed returns true if the message with the uidl was processed
+func (u *uidlCacheMem) IsProcessed(uidl string) (bool, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.processed[uidl], nil
+}
+
+// SaveProcessed stores the uidl as processed
+func (u *uidlCacheMem) SaveProcessed(uidl string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.processed == nil {
+		u.processed = make(map[string]bool)
+	}
+	u.processed[uidl] = true
+	return nil
+}
+
+// Retain removes the uidls that are not in the list, used to forget the messages removed from server
+func (u *uidlCacheMem) Retain(uidls []string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.processed = retainUIDLs(u.processed, uidls)
+	return nil
+}
+
+// Initialized returns false if nothing was stored for the account yet, on the first check
+func (u *uidlCacheMem) Initialized() (bool, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.processed != nil, nil
+}
+
+// Seed stores the uidls as processed replacing the cache, used on the first check to skip
+// the messages that are already in the server
+func (u *uidlCacheMem) Seed(uidls []string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.processed = make(map[string]bool)
+	for _, uidl := range uidls {
+		u.processed[uidl] = true
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package mmail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// UIDLCacheFile implements UIDLCache using filesystem to store, one uidl per line
+type UIDLCacheFile struct {
+	filename  string
+	processed map[string]bool
+	stored    bool
+	lock      sync.Mutex
+}
+
+// NewUIDLCacheFile return a new UIDLCacheFile
+func NewUIDLCacheFile(directory, account string) *UIDLCacheFile {
+	filename := filepath.Join(directory, strings.ToLower(account+"_pop3.uidl"))
+
+	return &UIDLCacheFile{
+		filename: filename,
+	}
+}
+
+// IsProcessed returns true if the message with the uidl was processed
+func (u *UIDLCacheFile) IsProcessed(uidl string) (bool, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if err := u.load(); err != nil {
+		return false, err
+	}
+
+	return u.processed[uidl], nil
+}
+
+// SaveProcessed stores the uidl as processed
+func (u *UIDLCacheFile) SaveProcessed(uidl string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if err := u.load(); err != nil {
+		return err
+	}
+
+	u.processed[uidl] = true
+	return u.save()
+}
+
+// Retain removes the uidls that are not in the list, used to forget the messages removed from server
+func (u *UIDLCacheFile) Retain(uidls []string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if err := u.load(); err != nil {
+		return err
+	}
+
+	retained := retainUIDLs(u.processed, uidls)
+	if len(retained) == len(u.processed) {
+		return nil
+	}
+
+	u.processed = retained
+	return u.save()
+}
+
+// Initialized returns false if the file was not created yet, on the first check
+func (u *UIDLCacheFile) Initialized() (bool, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if err := u.load(); err != nil {
+		return false, err
+	}
+
+	return u.stored, nil
+}
+
+// Seed stores the uidls as processed replacing the cache, the file is created even if the
+// list is empty so the next check is not taken as the first one
+func (u *UIDLCacheFile) Seed(uidls []string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.processed = make(map[string]bool)
+	for _, uidl := range uidls {
+		u.processed[uidl] = true
+	}
+	return u.save()
+}
+
+// load reads the file only on first access
+func (u *UIDLCacheFile) load() error {
+	if u.processed != nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(u.filename)
+	if os.IsNotExist(err) {
+		u.processed = make(map[string]bool)
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "Error on read file '%v'", u.filename)
+	}
+
+	u.stored = true
+	u.processed = make(map[string]bool)
+	for _, uidl := range strings.Split(string(data), "\n") {
+		if uidl != "" {
+			u.processed[uidl] = true
+		}
+	}
+	return nil
+}
+
+func (u *UIDLCacheFile) save() error {
+	uidls := make([]string, 0, len(u.processed))
+	for uidl := range u.processed {
+		uidls = append(uidls, uidl)
+	}
+	sort.Strings(uidls)
+
+	data := strings.Join(uidls, "\n")
+	if data != "" {
+		data += "\n"
+	}
+
+	if err := ioutil.WriteFile(u.filename, []byte(data), 0640); err != nil {
+		return err
+	}
+	u.stored = true
+	return nil
+}
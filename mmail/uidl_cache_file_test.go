@@ -0,0 +1,61 @@
+package mmail
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUIDLCacheFile(t *testing.T) {
+	cache := NewUIDLCacheFile(os.TempDir(), "test@example.com")
+	os.Remove(cache.filename)
+	defer os.Remove(cache.filename)
+
+	if ok, err := cache.Initialized(); err != nil || ok {
+		t.Fatalf("Expected cache not initialized result:%v err:%v", ok, err)
+	}
+
+	if ok, err := cache.IsProcessed("a1"); err != nil || ok {
+		t.Fatalf("Expected a1 not processed result:%v err:%v", ok, err)
+	}
+
+	if err := cache.SaveProcessed("a1"); err != nil {
+		t.Fatal("Error on save processed", err.Error())
+	}
+
+	if err := cache.SaveProcessed("a2"); err != nil {
+		t.Fatal("Error on save processed", err.Error())
+	}
+
+	// read from file
+	cache = NewUIDLCacheFile(os.TempDir(), "test@example.com")
+
+	if ok, err := cache.IsProcessed("a1"); err != nil || !ok {
+		t.Fatalf("Expected a1 processed result:%v err:%v", ok, err)
+	}
+
+	if err := cache.Retain([]string{"a2"}); err != nil {
+		t.Fatal("Error on retain", err.Error())
+	}
+
+	cache = NewUIDLCacheFile(os.TempDir(), "test@example.com")
+
+	if ok, _ := cache.IsProcessed("a1"); ok {
+		t.Fatal("Expected a1 removed after retain")
+	}
+
+	if ok, _ := cache.IsProcessed("a2"); !ok {
+		t.Fatal("Expected a2 processed after retain")
+	}
+
+	// an empty seed creates the file
+	os.Remove(cache.filename)
+	if err := cache.Seed(nil); err != nil {
+		t.Fatal("Error on seed", err.Error())
+	}
+
+	cache = NewUIDLCacheFile(os.TempDir(), "test@example.com")
+
+	if ok, err := cache.Initialized(); err != nil || !ok {
+		t.Fatalf("Expected cache initialized after seed result:%v err:%v", ok, err)
+	}
+}
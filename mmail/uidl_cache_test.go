@@ -0,0 +1,53 @@
+package mmail
+
+import (
+	"testing"
+)
+
+func Test_uidlCacheMem(t *testing.T) {
+	cache := &uidlCacheMem{}
+
+	if ok, err := cache.IsProcessed("a1"); err != nil || ok {
+		t.Fatalf("Expected a1 not processed result:%v err:%v", ok, err)
+	}
+
+	if err := cache.SaveProcessed("a1"); err != nil {
+		t.Fatal("Error on save processed", err.Error())
+	}
+
+	cache.SaveProcessed("a2")
+
+	if ok, err := cache.IsProcessed("a1"); err != nil || !ok {
+		t.Fatalf("Expected a1 processed result:%v err:%v", ok, err)
+	}
+
+	if err := cache.Retain([]string{"a2", "a3"}); err != nil {
+		t.Fatal("Error on retain", err.Error())
+	}
+
+	if ok, _ := cache.IsProcessed("a1"); ok {
+		t.Fatal("Expected a1 removed after retain")
+	}
+
+	if ok, _ := cache.IsProcessed("a2"); !ok {
+		t.Fatal("Expected a2 processed after retain")
+	}
+
+	if ok, _ := cache.IsProcessed("a3"); ok {
+		t.Fatal("Expected a3 not processed after retain")
+	}
+
+	if ok, _ := cache.Initialized(); !ok {
+		t.Fatal("Expected cache initialized")
+	}
+
+	cache = &uidlCacheMem{}
+	if ok, _ := cache.Initialized(); ok {
+		t.Fatal("Expected new cache not initialized")
+	}
+
+	cache.Seed([]string{"b1"})
+	if ok, _ := cache.IsProcessed("b1"); !ok {
+		t.Fatal("Expected b1 processed after seed")
+	}
+}
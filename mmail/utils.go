@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	_ "github.com/paulrosania/go-charset/data" //initiate go-charset data
 )
@@ -80,3 +81,172 @@ func findDir(dir string) string {
 
 	return fileName + "/"
 }
+
+// messageBlock paragraph or code block of a message
+type messageBlock struct {
+	lines []string
+	fence string // opening fence line of code block or empty
+}
+
+// isFence check if line opens or closes a markdown code block
+func isFence(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// isClosingFence check if line closes the code block opened by fence
+func isClosingFence(line, fence string) bool {
+	line = strings.TrimSpace(line)
+	return isFence(line) && line[:3] == strings.TrimSpace(fence)[:3] && strings.Trim(line, line[:1]) == ""
+}
+
+// messageBlocks splits the message in paragraphs and code blocks
+func messageBlocks(message string) []*messageBlock {
+	var blocks []*messageBlock
+	var cur *messageBlock
+
+	for _, line := range strings.Split(strings.Replace(message, "\r\n", "\n", -1), "\n") {
+		switch {
+		case cur != nil && cur.fence != "":
+			// inside of code block, only the closing fence ends it
+			cur.lines = append(cur.lines, line)
+			if isClosingFence(line, cur.fence) {
+				cur = nil
+			}
+		case isFence(line):
+			cur = &messageBlock{lines: []string{line}, fence: line}
+			blocks = append(blocks, cur)
+		case strings.TrimSpace(line) == "":
+			cur = nil
+		default:
+			if cur == nil {
+				cur = &messageBlock{}
+				blocks = append(blocks, cur)
+			}
+			cur.lines = append(cur.lines, line)
+		}
+	}
+	return blocks
+}
+
+// splitRunes splits s in parts with at most limit characters
+func splitRunes(s string, limit int) []string {
+	if limit < 1 {
+		limit = 1
+	}
+
+	var parts []string
+	r := []rune(s)
+	for len(r) > limit {
+		parts = append(parts, string(r[:limit]))
+		r = r[limit:]
+	}
+	return append(parts, string(r))
+}
+
+// splitBlock splits a block larger than limit by lines, each part of a code block is closed
+// and opened again to keep the code formatted. A fence line longer than a quarter of limit opens
+// only the first part, the other parts are opened with the marker, and a fence line that
+// does not fit in limit is split as text
+func splitBlock(b *messageBlock, limit int) []string {
+	lines := b.lines
+	openFence, closeFence := "", ""
+	openFirst := true
+	if b.fence != "" {
+		marker := strings.TrimSpace(b.fence)[:3]
+		fence := utf8.RuneCountInString(b.fence)
+		reserved := 2*utf8.RuneCountInString(marker) + 2
+
+		switch {
+		case fence <= limit/4:
+			openFence = b.fence + "\n"
+			lines = lines[1:]
+			reserved += fence - utf8.RuneCountInString(marker)
+		case fence+reserved <= limit:
+			// the fence line stays in the first part
+			openFence = marker + "\n"
+			openFirst = false
+		default:
+			reserved = 0
+		}
+
+		if reserved > 0 {
+			closeFence = "\n" + marker
+			if n := len(lines); n > 1 && isClosingFence(lines[n-1], b.fence) {
+				lines = lines[:n-1]
+			}
+			limit -= reserved
+		}
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	var parts []string
+	cur := ""
+	flush := func() {
+		if cur != "" {
+			if len(parts) > 0 || openFirst {
+				cur = openFence + cur
+			}
+			parts = append(parts, cur+closeFence)
+			cur = ""
+		}
+	}
+
+	for _, line := range lines {
+		for _, l := range splitRunes(line, limit) {
+			switch {
+			case cur == "":
+				cur = l
+			case utf8.RuneCountInString(cur)+1+utf8.RuneCountInString(l) <= limit:
+				cur += "\n" + l
+			default:
+				flush()
+				cur = l
+			}
+		}
+	}
+	flush()
+	return parts
+}
+
+// splitMessage splits the message in parts with at most limit characters, the parts are split
+// on paragraphs or lines and code blocks are kept formatted
+func splitMessage(message string, limit int) []string {
+	if utf8.RuneCountInString(message) <= limit {
+		return []string{message}
+	}
+
+	var parts []string
+	cur := ""
+	add := func(text string) {
+		switch {
+		case cur == "":
+			cur = text
+		case utf8.RuneCountInString(cur)+2+utf8.RuneCountInString(text) <= limit:
+			cur += "\n\n" + text
+		default:
+			parts = append(parts, cur)
+			cur = text
+		}
+	}
+
+	for _, b := range messageBlocks(message) {
+		text := strings.Join(b.lines, "\n")
+		if utf8.RuneCountInString(text) <= limit {
+			add(text)
+			continue
+		}
+
+		for _, p := range splitBlock(b, limit) {
+			add(p)
+		}
+	}
+
+	if cur != "" {
+		parts = append(parts, cur)
+	}
+	return parts
+}
@@ -2,7 +2,9 @@ package mmail
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestGetChannelsFromSubject(t *testing.T) {
@@ -88,3 +90,42 @@ func TestReadLines(t *testing.T) {
 	assert(lines, 1, lines)
 	assert(lines, 2, lines)
 }
+
+func Test_splitMessage(t *testing.T) {
+	code := "```go\nline1\n\nline2\nline3\n```"
+
+	tests := []struct {
+		name    string
+		message string
+		limit   int
+		want    []string
+	}{
+		{"short", "hello\n\nworld", 20, []string{"hello\n\nworld"}},
+		{"paragraphs", "first paragraph\n\nsecond paragraph\n\nthird", 35, []string{"first paragraph\n\nsecond paragraph", "third"}},
+		{"lines", "line one\nline two\nline three", 18, []string{"line one\nline two", "line three"}},
+		{"long line", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"crlf", "line one\r\nline two\r\n\r\nend", 17, []string{"line one\nline two", "end"}},
+		{"code block is not split", "text\n\n" + code + "\n\nend", 30, []string{"text", code, "end"}},
+		{"code block in paragraph", "text\n" + code, 30, []string{"text", code}},
+		{"code block larger than limit", code, 20, []string{"```go\nline1\n\n```", "```go\nline2\n```", "```go\nline3\n```"}},
+		{"tilde fence", "~~~\na\n```\nb\n~~~\n\nend", 15, []string{"~~~\na\n```\nb\n~~~", "end"}},
+		{"long info string", "```aaaaaaaaaaaa\nline1\nline2\nline3\n```", 30, []string{"```aaaaaaaaaaaa\nline1\n```", "```\nline2\nline3\n```"}},
+		{"fence larger than limit", "```aaaaaaaaaa\ncode\n```", 8, []string{"```aaaaa", "aaaaa", "code\n```"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitMessage(tt.message, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("Test %v expected:%q result:%q", tt.name, tt.want, got)
+		}
+	}
+
+	// fence line larger than the post size
+	message := "```" + strings.Repeat("a", 5000) + "\ncode\n```\n"
+	for _, limit := range []int{4000, 10, 1} {
+		for _, p := range splitMessage(message, limit) {
+			if n := utf8.RuneCountInString(p); n > limit {
+				t.Fatalf("Expected parts with at most %v characters result:%v", limit, n)
+			}
+		}
+	}
+}
@@ -12,12 +12,16 @@ import (
 const (
 	defaultDebug     = true
 	defaultDirectory = "./data/"
+
+	// default MailTemplate of configuration v1 converted
+	defaultMailTemplateV1 = ":incoming_envelope: _From: **{{.From}}**_\n>_{{.Subject}}_\n\n{{.Message}}"
 )
 
 // Config type to parse config.json
 type Config struct {
 	Directory string
-	Debug     *bool `json:",omitempty"`
+	Debug     *bool     `json:",omitempty"`
+	Receiver  *Receiver `json:",omitempty"`
 	Profiles  []*Profile
 }
 
@@ -50,11 +54,8 @@ func NewConfigFromFile(file string) (*Config, error) {
 
 // Validate set default value for config and check if valid return err
 func (c *Config) Validate() error {
-	if _, err := os.Stat(c.Directory); err != nil {
-		if os.IsNotExist(err) {
-			return errors.Errorf("Directory %v does not exists. please create the directory first", c.Directory)
-		}
-		return errors.Wrapf(err, "Field 'Directory':'%v' is not a valid path", c.Directory)
+	if err := c.validateDirectory(); err != nil {
+		return err
 	}
 
 	if c.Profiles == nil || len(c.Profiles) == 0 {
@@ -67,6 +68,50 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.Receiver != nil {
+		if err := c.Receiver.Validate(); err != nil {
+			return errors.Wrap(err, "Error in Receiver")
+		}
+	}
+
+	// each recipient is delivered to only one profile
+	recipients := make(map[string]string)
+	for _, p := range c.Profiles {
+		if p.Email.IsReceiver() && c.Receiver == nil {
+			return errors.Errorf("Field 'Receiver' is empty set Receiver configuration used by profile '%v'", p.Name)
+		}
+
+		for _, r := range p.Email.Recipients {
+			if name, ok := recipients[r]; ok {
+				return errors.Errorf("Recipient '%v' is used by profiles '%v' and '%v'", r, name, p.Name)
+			}
+			recipients[r] = p.Name
+		}
+	}
+
+	return nil
+}
+
+// ValidateProfile checks only the config used by the profile, a broken profile does not affect
+// the others, used when one profile posts a message
+func (c *Config) ValidateProfile(p *Profile) error {
+	if err := c.validateDirectory(); err != nil {
+		return err
+	}
+
+	if err := p.Validate(); err != nil {
+		return errors.Wrapf(err, "Validate profile '%v'", p.Name)
+	}
+	return nil
+}
+
+func (c *Config) validateDirectory() error {
+	if _, err := os.Stat(c.Directory); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("Directory %v does not exists. please create the directory first", c.Directory)
+		}
+		return errors.Wrapf(err, "Field 'Directory':'%v' is not a valid path", c.Directory)
+	}
 	return nil
 }
 
@@ -81,6 +126,10 @@ func (c *Config) Fix() {
 		c.Debug = &x
 	}
 
+	if c.Receiver != nil {
+		c.Receiver.Fix()
+	}
+
 	for _, p := range c.Profiles {
 		p.Fix()
 	}
@@ -140,7 +189,7 @@ func MigrateFromV1(v1 ConfigV1) *Config {
 		mailtemplate = strings.Replace(mailtemplate, "%v", "{{.Subject}}", 1)
 		mailtemplate = strings.Replace(mailtemplate, "%v", "{{.Message}}", 1)
 
-		if mailtemplate != defaultMailTemplate {
+		if mailtemplate != defaultMailTemplateV1 && mailtemplate != defaultMailTemplate {
 			profile.MailTemplate = &mailtemplate
 		}
 
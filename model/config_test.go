@@ -29,6 +29,84 @@ func TestConfig_Validate(t *testing.T) {
 	valid(3)
 }
 
+func TestConfig_ValidateProfile(t *testing.T) {
+	profile := NewProfile()
+	profile.Name = "Orders"
+	profile.Channels = []string{"#town-square"}
+	profile.Email.ImapServer = "imap.example.com:143"
+	profile.Email.Username = "orders@example.com"
+	profile.Email.Password = "password"
+	profile.Mattermost.Server = "https://mattermost.example.com"
+	profile.Mattermost.Team = "team1"
+	profile.Mattermost.User = "mattermail"
+	profile.Mattermost.Password = "password"
+
+	config := NewConfig()
+	config.Directory = os.TempDir()
+	config.Profiles = []*Profile{profile, NewProfile()}
+
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected config invalid with a broken profile")
+	}
+
+	if err := config.ValidateProfile(profile); err != nil {
+		t.Fatal("Expected profile valid err:", err)
+	}
+
+	if err := config.ValidateProfile(config.Profiles[1]); err == nil {
+		t.Fatal("Expected broken profile invalid")
+	}
+
+	config.Directory = filepath.Join(os.TempDir(), "mattermail-not-found")
+	if err := config.ValidateProfile(profile); err == nil {
+		t.Fatal("Expected error without directory")
+	}
+}
+
+func TestConfig_ValidateReceiver(t *testing.T) {
+	newReceiverProfile := func(name string, recipients ...string) *Profile {
+		p := NewProfile()
+		p.Name = name
+		p.Channels = []string{"#" + name}
+		p.Email.Recipients = recipients
+		p.Mattermost.Server = "https://mattermost.example.com"
+		p.Mattermost.Team = "team1"
+		p.Mattermost.User = "mattermail@example.com"
+		p.Mattermost.Password = "password"
+		return p
+	}
+
+	config := NewConfig()
+	config.Directory = os.TempDir()
+	config.Profiles = []*Profile{
+		newReceiverProfile("orders", "orders@example.com"),
+		newReceiverProfile("billing", "billing@example.com", "invoices@example.com"),
+	}
+
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected error without Receiver")
+	}
+
+	config.Receiver = NewReceiver()
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Profiles[1].Email.Recipients = []string{"orders@example.com"}
+
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected error with recipient in two profiles")
+	}
+
+	config.Profiles[1].Email.Recipients = []string{"billing@example.com"}
+	config.Receiver.Address = "2525"
+
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected error with invalid Receiver")
+	}
+}
+
 func TestConfig_Fix(t *testing.T) {
 	c := &Config{}
 	c.Profiles = append(c.Profiles, NewProfile())
@@ -1,6 +1,9 @@
 package model
 
 import (
+	"net/mail"
+	"strings"
+
 	"github.com/pkg/errors"
 )
 
@@ -8,16 +11,22 @@ const (
 	defaultStartTLS          = false
 	defaultTLSAcceptAllCerts = false
 	defaultDisableIdle       = false
+	defaultDeleteAfterPost   = false
 )
 
 // Email type with email settings
 type Email struct {
-	ImapServer        string
-	Username          string
-	Password          string
-	StartTLS          *bool `json:",omitempty"`
-	TLSAcceptAllCerts *bool `json:",omitempty"`
-	DisableIdle       *bool `json:",omitempty"`
+	ImapServer        string    `json:",omitempty"`
+	Pop3Server        string    `json:",omitempty"`
+	Recipients        []string  `json:",omitempty"`
+	Username          string    `json:",omitempty"`
+	Password          string    `json:",omitempty"`
+	OAuth2            *OAuth2   `json:",omitempty"`
+	StartTLS          *bool     `json:",omitempty"`
+	TLSAcceptAllCerts *bool     `json:",omitempty"`
+	DisableIdle       *bool     `json:",omitempty"`
+	DeleteAfterPost   *bool     `json:",omitempty"`
+	Folders           []*Folder `json:",omitempty"`
 }
 
 // NewEmail creates new Email with default values
@@ -26,31 +35,124 @@ func NewEmail() *Email {
 		StartTLS:          new(bool),
 		TLSAcceptAllCerts: new(bool),
 		DisableIdle:       new(bool),
+		DeleteAfterPost:   new(bool),
 	}
 	*email.StartTLS = defaultStartTLS
 	*email.TLSAcceptAllCerts = defaultTLSAcceptAllCerts
 	*email.DisableIdle = defaultDisableIdle
+	*email.DeleteAfterPost = defaultDeleteAfterPost
+	email.Folders = []*Folder{{Name: defaultFolder}}
 	return email
 }
 
 // Validate set default value for email and check if valid return err
 func (c *Email) Validate() error {
-	if c.ImapServer == "" {
-		return errors.New("Field 'ImapServer' is empty set imap server address eg.: imap.example.com:143")
+	if c.IsReceiver() {
+		// emails are delivered by the receiver, there is no login
+		return c.validateRecipients()
 	}
 
-	if !validateImap(c.ImapServer) {
-		return errors.Errorf("Field 'ImapServer' need to be a valid url: %v", c.ImapServer)
+	if c.Pop3Server != "" {
+		if err := c.validatePop3(); err != nil {
+			return err
+		}
+	} else {
+		if c.ImapServer == "" {
+			return errors.New("Field 'ImapServer' is empty set imap server address eg.: imap.example.com:143")
+		}
+
+		if !validateImap(c.ImapServer) {
+			return errors.Errorf("Field 'ImapServer' need to be a valid url: %v", c.ImapServer)
+		}
+
+		if c.DeleteAfterPost != nil && *c.DeleteAfterPost {
+			return errors.New("Field 'DeleteAfterPost' is only supported with 'Pop3Server'")
+		}
 	}
 
 	if c.Username == "" {
 		return errors.New("Field 'Username' is empty, set email address or username eg.: test@example.com")
 	}
 
-	if c.Password == "" {
+	if c.OAuth2 != nil {
+		if err := c.OAuth2.Validate(); err != nil {
+			return errors.Wrap(err, "Error in OAuth2")
+		}
+	} else if c.Password == "" {
 		return errors.New("Field 'Password' is empty")
 	}
 
+	return c.validateFolders()
+}
+
+// validateFolders check the folders and if there are duplicated names
+func (c *Email) validateFolders() error {
+	names := make(map[string]bool)
+	for _, f := range c.Folders {
+		if err := f.Validate(); err != nil {
+			return errors.Wrap(err, "Error in Folders")
+		}
+
+		if names[f.Name] {
+			return errors.Errorf("Field 'Folders' contains the folder '%v' more than once", f.Name)
+		}
+		names[f.Name] = true
+	}
+
+	return nil
+}
+
+// validateRecipients check the fields used with the receiver
+func (c *Email) validateRecipients() error {
+	if c.ImapServer != "" || c.Pop3Server != "" {
+		return errors.New("Field 'Recipients' can not be used with 'ImapServer' or 'Pop3Server'")
+	}
+
+	for _, r := range c.Recipients {
+		if addr, err := mail.ParseAddress(r); err != nil || addr.Address != r {
+			return errors.Errorf("Field 'Recipients' need to contain only email addresses eg.: orders@example.com: %v", r)
+		}
+	}
+
+	if c.OAuth2 != nil {
+		return errors.New("Field 'OAuth2' is not supported with 'Recipients'")
+	}
+
+	if c.DeleteAfterPost != nil && *c.DeleteAfterPost {
+		return errors.New("Field 'DeleteAfterPost' is only supported with 'Pop3Server'")
+	}
+
+	// the receiver has only one mailbox
+	for _, f := range c.Folders {
+		if f.Name != defaultFolder {
+			return errors.Errorf("Field 'Folders' with 'Recipients' supports only %v: %v", defaultFolder, f.Name)
+		}
+	}
+
+	return c.validateFolders()
+}
+
+// validatePop3 check the fields used with pop3 server
+func (c *Email) validatePop3() error {
+	if c.ImapServer != "" {
+		return errors.New("Fields 'ImapServer' and 'Pop3Server' can not be used together")
+	}
+
+	if !validateImap(c.Pop3Server) {
+		return errors.Errorf("Field 'Pop3Server' need to be a valid url: %v", c.Pop3Server)
+	}
+
+	if c.OAuth2 != nil {
+		return errors.New("Field 'OAuth2' is not supported with 'Pop3Server'")
+	}
+
+	// pop3 has only one mailbox
+	for _, f := range c.Folders {
+		if f.Name != defaultFolder {
+			return errors.Errorf("Field 'Folders' with 'Pop3Server' supports only %v: %v", defaultFolder, f.Name)
+		}
+	}
+
 	return nil
 }
 
@@ -68,4 +170,35 @@ func (c *Email) Fix() {
 		x := defaultDisableIdle
 		c.DisableIdle = &x
 	}
+	for i, r := range c.Recipients {
+		c.Recipients[i] = strings.ToLower(strings.TrimSpace(r))
+	}
+	if c.DeleteAfterPost == nil {
+		x := defaultDeleteAfterPost
+		c.DeleteAfterPost = &x
+	}
+	if c.OAuth2 != nil {
+		c.OAuth2.Fix()
+	}
+	if len(c.Folders) == 0 {
+		c.Folders = []*Folder{{Name: defaultFolder}}
+	}
+	for _, f := range c.Folders {
+		f.Fix()
+	}
+}
+
+// IsReceiver returns true if the emails are delivered by the receiver instead of read from a server
+func (c *Email) IsReceiver() bool {
+	return len(c.Recipients) > 0
+}
+
+// GetFolder returns the folder with the name or nil if it is not watched
+func (c *Email) GetFolder(name string) *Folder {
+	for _, f := range c.Folders {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
 }
@@ -28,12 +28,116 @@ func TestEmail_Validate(t *testing.T) {
 	if err := config.Validate(); err != nil {
 		t.Fatal(err)
 	}
+
+	config.Password = ""
+	config.OAuth2 = &OAuth2{ClientID: "client", RefreshToken: "refresh"}
+	valid(5)
+
+	config.OAuth2.TokenURL = "https://oauth2.googleapis.com/token"
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.OAuth2 = nil
+	config.Password = "1234"
+
+	config.Folders = []*Folder{{Name: "INBOX"}, {Name: ""}}
+	valid(6)
+
+	config.Folders = []*Folder{{Name: "INBOX"}, {Name: "INBOX"}}
+	valid(7)
+
+	config.Folders = []*Folder{{Name: "INBOX"}, {Name: "Alerts", Channels: []string{"#alerts"}}}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmail_ValidatePop3(t *testing.T) {
+	config := NewEmail()
+	config.Username = "foo@blah.hh"
+	config.Password = "1234"
+	valid := func(n int) {
+		if err := config.Validate(); err == nil {
+			t.Fatal("Test:", n, "this config need to be invalid")
+		}
+	}
+
+	config.Pop3Server = "pop.ssj.com:995"
+	*config.DeleteAfterPost = true
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.ImapServer = "imap.ssj.com"
+	valid(0)
+
+	config.Pop3Server = ""
+	valid(1)
+
+	*config.DeleteAfterPost = false
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.ImapServer = ""
+	config.Pop3Server = "Z"
+	valid(2)
+
+	config.Pop3Server = "pop.ssj.com:995"
+	config.Folders = []*Folder{{Name: "INBOX"}, {Name: "Alerts"}}
+	valid(3)
+
+	config.Folders = []*Folder{{Name: "INBOX"}}
+	config.OAuth2 = &OAuth2{ClientID: "client", RefreshToken: "refresh", TokenURL: "https://oauth2.googleapis.com/token"}
+	valid(4)
+}
+
+func TestEmail_ValidateRecipients(t *testing.T) {
+	config := NewEmail()
+	valid := func(n int) {
+		if err := config.Validate(); err == nil {
+			t.Fatal("Test:", n, "this config need to be invalid")
+		}
+	}
+
+	config.Recipients = []string{"orders@example.com", "billing@example.com"}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Recipients = []string{"Orders <orders@example.com>"}
+	valid(0)
+
+	config.Recipients = []string{"orders"}
+	valid(1)
+
+	config.Recipients = []string{"orders@example.com"}
+	config.ImapServer = "imap.ssj.com"
+	valid(2)
+
+	config.ImapServer = ""
+	config.Folders = []*Folder{{Name: "Alerts"}}
+	valid(3)
+
+	config.Folders = []*Folder{{Name: "INBOX"}}
+	*config.DeleteAfterPost = true
+	valid(4)
 }
 
 func TestEmail_Fix(t *testing.T) {
-	e := Email{}
+	e := Email{Recipients: []string{" Orders@Example.com "}}
 	e.Fix()
 
+	if e.Recipients[0] != "orders@example.com" {
+		t.Fatal("Expected Recipients: orders@example.com result:", e.Recipients[0])
+	}
+
 	if *e.StartTLS != defaultStartTLS {
 		t.Fatal("Expected StartTLS:", defaultStartTLS, " result:", *e.StartTLS)
 	}
@@ -41,4 +145,24 @@ func TestEmail_Fix(t *testing.T) {
 	if *e.TLSAcceptAllCerts != defaultTLSAcceptAllCerts {
 		t.Fatal("Expected TLSAcceptAllCerts:", defaultTLSAcceptAllCerts, " result:", *e.TLSAcceptAllCerts)
 	}
+
+	if *e.DeleteAfterPost != defaultDeleteAfterPost {
+		t.Fatal("Expected DeleteAfterPost:", defaultDeleteAfterPost, " result:", *e.DeleteAfterPost)
+	}
+
+	if len(e.Folders) != 1 || e.Folders[0].Name != defaultFolder {
+		t.Fatal("Expected Folders:", defaultFolder, " result:", e.Folders)
+	}
+}
+
+func TestEmail_GetFolder(t *testing.T) {
+	e := Email{Folders: []*Folder{{Name: "INBOX"}, {Name: "Alerts"}}}
+
+	if f := e.GetFolder("Alerts"); f == nil || f.Name != "Alerts" {
+		t.Fatal("Expected folder Alerts result:", f)
+	}
+
+	if f := e.GetFolder("Billing"); f != nil {
+		t.Fatal("Expected nil for folder Billing result:", f)
+	}
 }
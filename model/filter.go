@@ -1,24 +1,345 @@
 package model
 
 import (
-	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
 	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// FilterMessage fields of the email used to match the rules
+type FilterMessage struct {
+	Folder  string
+	From    string
+	Subject string
+	Header  map[string][]string
+	Body    string
+}
+
+// Condition of a rule, the patterns of From, Subject, Headers and Body are case insensitive
+// substrings or regular expressions between slashes eg.: /^\[PROD\]/
+// All fields set need to match, All needs every condition, Any needs at least one
+// condition and Not needs the condition do not match
+type Condition struct {
+	Folder  string            `json:",omitempty"`
+	From    string            `json:",omitempty"`
+	Subject string            `json:",omitempty"`
+	Headers map[string]string `json:",omitempty"`
+	Body    string            `json:",omitempty"`
+	All     []*Condition      `json:",omitempty"`
+	Any     []*Condition      `json:",omitempty"`
+	Not     *Condition        `json:",omitempty"`
+
+	// regular expressions compiled in Validate
+	regexps map[string]*regexp.Regexp
+}
+
+const (
+	// RuleActionPost posts the email in the channels of the rule
+	RuleActionPost = "post"
+
+	// RuleActionDrop discards the email, it is marked as processed without post
+	RuleActionDrop = "drop"
 )
 
-// Rule for filter
+// Rule for filter, the email is posted in Channels when the conditions of the rule match.
+// Continue informs if the next rules are evaluated after this rule matches, when it is not
+// set the routing mode of the profile is used. A rule with Action drop discards the email
+// and stops the evaluation. MailTemplate, LinesToPreview and Attachment override the
+// settings of the profile
 type Rule struct {
+	Name     string `json:",omitempty"`
+	Action   string `json:",omitempty"`
+	Folder   string `json:",omitempty"`
 	From     string
 	Subject  string
+	Headers  map[string]string `json:",omitempty"`
+	Body     string            `json:",omitempty"`
+	All      []*Condition      `json:",omitempty"`
+	Any      []*Condition      `json:",omitempty"`
+	Not      *Condition        `json:",omitempty"`
 	Channels []string
+	Continue *bool `json:",omitempty"`
+
+	MailTemplate   *string `json:",omitempty"`
+	LinesToPreview *int    `json:",omitempty"`
+	Attachment     *bool   `json:",omitempty"`
+
+	// root condition created in Validate
+	cond *Condition
+
+	// MailTemplate parsed in Validate
+	mailTemplate *template.Template
 }
 
 // Filter has an array of rules
 type Filter []*Rule
 
+// isRegexPattern returns true if the pattern is a regular expression between slashes
+func isRegexPattern(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// compilePattern compiles the regular expression of the pattern
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(pattern[1 : len(pattern)-1])
+}
+
+// fixPattern remove spaces and convert to lower case the substring pattern
+func fixPattern(pattern string) string {
+	pattern = strings.TrimSpace(pattern)
+	if isRegexPattern(pattern) {
+		return pattern
+	}
+	return strings.ToLower(pattern)
+}
+
+// fixHeaders canonicalize the header names and fix the patterns
+func fixHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+
+	fixed := make(map[string]string, len(headers))
+	for name, pattern := range headers {
+		fixed[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))] = fixPattern(pattern)
+	}
+	return fixed
+}
+
+// joinPath returns the path of the field in the condition tree
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// Fix remove spaces and convert to lower case the condition and its groups
+func (c *Condition) Fix() {
+	c.Folder = strings.TrimSpace(c.Folder)
+	c.From = fixPattern(c.From)
+	c.Subject = fixPattern(c.Subject)
+	c.Body = fixPattern(c.Body)
+	c.Headers = fixHeaders(c.Headers)
+
+	for _, sub := range c.All {
+		sub.Fix()
+	}
+
+	for _, sub := range c.Any {
+		sub.Fix()
+	}
+
+	if c.Not != nil {
+		c.Not.Fix()
+	}
+}
+
+// isEmpty returns true if nothing is set in the condition
+func (c *Condition) isEmpty() bool {
+	return len(c.Folder) == 0 && len(c.From) == 0 && len(c.Subject) == 0 && len(c.Headers) == 0 &&
+		len(c.Body) == 0 && c.All == nil && c.Any == nil && c.Not == nil
+}
+
+// Validate check if this condition is valid and compiles the regular expressions
+func (c *Condition) Validate() error {
+	return c.validate("")
+}
+
+// validate check the condition using path to report where the error is
+func (c *Condition) validate(path string) error {
+	if c.isEmpty() {
+		if path == "" {
+			return errors.New("Need to set Folder, From, Subject, Headers, Body, All, Any or Not")
+		}
+		return errors.Errorf("Condition '%v' is empty, need to set Folder, From, Subject, Headers, Body, All, Any or Not", path)
+	}
+
+	fields := map[string]string{"From": c.From, "Subject": c.Subject, "Body": c.Body}
+	for name, pattern := range c.Headers {
+		if name == "" {
+			return errors.Errorf("Field '%v' contains an empty header name", joinPath(path, "Headers"))
+		}
+		fields["Headers."+name] = pattern
+	}
+
+	regexps := make(map[string]*regexp.Regexp)
+	for field, pattern := range fields {
+		if !isRegexPattern(pattern) {
+			continue
+		}
+
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "Field '%v' has an invalid regular expression %v", joinPath(path, field), pattern)
+		}
+		regexps[pattern] = re
+	}
+	c.regexps = regexps
+
+	if err := validateGroup(c.All, joinPath(path, "All")); err != nil {
+		return err
+	}
+
+	if err := validateGroup(c.Any, joinPath(path, "Any")); err != nil {
+		return err
+	}
+
+	if c.Not != nil {
+		return c.Not.validate(joinPath(path, "Not"))
+	}
+	return nil
+}
+
+// validateGroup check all conditions of the group All or Any
+func validateGroup(group []*Condition, path string) error {
+	if group == nil {
+		return nil
+	}
+
+	if len(group) == 0 {
+		return errors.Errorf("Field '%v' need to be at least one condition", path)
+	}
+
+	for i, sub := range group {
+		subPath := fmt.Sprintf("%v[%v]", path, i)
+		if sub == nil {
+			return errors.Errorf("Condition '%v' is empty", subPath)
+		}
+
+		if err := sub.validate(subPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchPattern check if the text meets the pattern, empty pattern meets any text
+func (c *Condition) matchPattern(pattern, text string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+
+	if !isRegexPattern(pattern) {
+		return strings.Contains(strings.ToLower(text), pattern)
+	}
+
+	re := c.regexps[pattern]
+	if re == nil {
+		// condition was not validated
+		var err error
+		if re, err = compilePattern(pattern); err != nil {
+			return false
+		}
+	}
+	return re.MatchString(text)
+}
+
+func (c *Condition) matchFolder(folder string) bool {
+	if len(c.Folder) == 0 {
+		return true
+	}
+	return strings.EqualFold(folder, c.Folder)
+}
+
+// matchHeaders check if all headers meet the condition, at least one value of each header needs to meet
+func (c *Condition) matchHeaders(header map[string][]string) bool {
+	for name, pattern := range c.Headers {
+		values := header[textproto.CanonicalMIMEHeaderKey(name)]
+		if len(values) == 0 {
+			return false
+		}
+
+		matched := false
+		for _, v := range values {
+			if c.matchPattern(pattern, v) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAll check if all conditions of the group match
+func matchAll(group []*Condition, msg *FilterMessage) bool {
+	for _, sub := range group {
+		if !sub.Match(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny check if at least one condition of the group matches, empty group matches any message
+func matchAny(group []*Condition, msg *FilterMessage) bool {
+	if len(group) == 0 {
+		return true
+	}
+
+	for _, sub := range group {
+		if sub.Match(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match check if the message meets this condition
+func (c *Condition) Match(msg *FilterMessage) bool {
+	return c.matchFolder(msg.Folder) &&
+		c.matchPattern(c.From, msg.From) &&
+		c.matchPattern(c.Subject, msg.Subject) &&
+		c.matchHeaders(msg.Header) &&
+		c.matchPattern(c.Body, msg.Body) &&
+		matchAll(c.All, msg) &&
+		matchAny(c.Any, msg) &&
+		(c.Not == nil || !c.Not.Match(msg))
+}
+
+// condition returns the root condition of the rule
+func (r *Rule) condition() *Condition {
+	return &Condition{
+		Folder:  r.Folder,
+		From:    r.From,
+		Subject: r.Subject,
+		Headers: r.Headers,
+		Body:    r.Body,
+		All:     r.All,
+		Any:     r.Any,
+		Not:     r.Not,
+	}
+}
+
 // Fix remove spaces and convert to lower case the rules
 func (r *Rule) Fix() {
-	r.From = strings.TrimSpace(strings.ToLower(r.From))
-	r.Subject = strings.TrimSpace(strings.ToLower(r.Subject))
+	r.Name = strings.TrimSpace(r.Name)
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	r.Folder = strings.TrimSpace(r.Folder)
+	r.From = fixPattern(r.From)
+	r.Subject = fixPattern(r.Subject)
+	r.Body = fixPattern(r.Body)
+	r.Headers = fixHeaders(r.Headers)
+
+	for _, sub := range r.All {
+		sub.Fix()
+	}
+
+	for _, sub := range r.Any {
+		sub.Fix()
+	}
+
+	if r.Not != nil {
+		r.Not.Fix()
+	}
 
 	for i, channel := range r.Channels {
 		channel = strings.TrimSpace(channel)
@@ -31,14 +352,36 @@ func (r *Rule) Fix() {
 	}
 }
 
-// Validate check if this rule is valid
+// Validate check if this rule is valid and compiles the regular expressions
 func (r *Rule) Validate() error {
-	if len(r.From) == 0 && len(r.Subject) == 0 {
-		return errors.New("Need to set From or Subject")
+	cond := r.condition()
+	if err := cond.Validate(); err != nil {
+		return err
+	}
+
+	switch r.Action {
+	case "", RuleActionPost:
+		if len(r.Channels) == 0 {
+			return errors.New("Need to set at least one channel or user for destination")
+		}
+	case RuleActionDrop:
+		if len(r.Channels) > 0 {
+			return errors.Errorf("Field 'Channels' can not be used with Action '%v'", RuleActionDrop)
+		}
+	default:
+		return errors.Errorf("Field 'Action' need to be '%v' or '%v'", RuleActionPost, RuleActionDrop)
 	}
 
-	if len(r.Channels) == 0 {
-		return errors.New("Need to set at least one channel or user for destination")
+	if r.LinesToPreview != nil && *r.LinesToPreview <= 0 {
+		return errors.New("Field 'LinesToPreview' need to be greater than 0")
+	}
+
+	if r.MailTemplate != nil {
+		t, err := parseMailTemplate(*r.MailTemplate)
+		if err != nil {
+			return errors.Wrap(err, "Field 'MailTemplate' has an invalid template")
+		}
+		r.mailTemplate = t
 	}
 
 	for _, channel := range r.Channels {
@@ -47,37 +390,81 @@ func (r *Rule) Validate() error {
 		}
 	}
 
+	r.cond = cond
 	return nil
 }
 
-func (r *Rule) matchFrom(from string) bool {
-	from = strings.ToLower(from)
-	if len(r.From) == 0 {
-		return true
+// Match check if the message meets this rule
+func (r *Rule) Match(msg *FilterMessage) bool {
+	if r.cond == nil {
+		// rule was not validated
+		return r.condition().Match(msg)
 	}
-	return strings.Contains(from, r.From)
+	return r.cond.Match(msg)
 }
 
-func (r *Rule) matchSubject(subject string) bool {
-	subject = strings.ToLower(subject)
-	if len(r.Subject) == 0 {
-		return true
+// stop check if the rules after this one are ignored when it matches
+func (r *Rule) stop(fanOut bool) bool {
+	if r.Continue != nil {
+		return !*r.Continue
 	}
-	return strings.Contains(subject, r.Subject)
+	return !fanOut
 }
 
-// Match check if from and subject meets this rule
-func (r *Rule) Match(from, subject string) bool {
-	return r.matchFrom(from) && r.matchSubject(subject)
+// Match return the channels of the rules that match without duplicates, when fanOut is
+// true all rules are evaluated otherwise stops at the first rule that matches, the option
+// Continue of the rule overrides this behavior. When a rule with Action drop matches the
+// email is discarded, no channel is returned and dropped informs the name of the rule
+func (f *Filter) Match(msg *FilterMessage, fanOut bool) (channels []string, dropped string) {
+	seen := make(map[string]bool)
+
+	for i, r := range *f {
+		if !r.Match(msg) {
+			continue
+		}
+
+		if r.Action == RuleActionDrop {
+			if r.Name != "" {
+				return nil, r.Name
+			}
+			return nil, fmt.Sprintf("Rule[%v]", i)
+		}
+
+		for _, channel := range r.Channels {
+			if !seen[channel] {
+				seen[channel] = true
+				channels = append(channels, channel)
+			}
+		}
+
+		if r.stop(fanOut) {
+			break
+		}
+	}
+	return channels, ""
 }
 
-// GetChannels return the first channels with attempt the rules
-func (f *Filter) GetChannels(from, subject string) []string {
+// MatchRule return the first rule with Action post that matches the message or nil
+func (f *Filter) MatchRule(msg *FilterMessage) *Rule {
 	for _, r := range *f {
-		if r.Match(from, subject) {
-			return r.Channels
+		if r.Action != RuleActionDrop && r.Match(msg) {
+			return r
 		}
 	}
+	return nil
+}
+
+// MatchChannels return the channels of the rules that match, see Match
+func (f *Filter) MatchChannels(msg *FilterMessage, fanOut bool) []string {
+	channels, _ := f.Match(msg, fanOut)
+	return channels
+}
+
+// GetChannels return the first channels with attempt the rules
+func (f *Filter) GetChannels(msg *FilterMessage) []string {
+	if channels := f.MatchChannels(msg, false); len(channels) > 0 {
+		return channels
+	}
 	return []string{""}
 }
 
@@ -87,9 +474,9 @@ func (f *Filter) Validate() error {
 		return errors.New("Filter need to be at least one rule to be valid")
 	}
 
-	for _, r := range *f {
+	for i, r := range *f {
 		if err := r.Validate(); err != nil {
-			return err
+			return errors.Wrapf(err, "Rule[%v]", i)
 		}
 	}
 	return nil
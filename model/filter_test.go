@@ -1,9 +1,16 @@
 package model
 
 import (
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 )
 
+func matchRule(r *Rule, folder, from, subject string) bool {
+	return r.Match(&FilterMessage{Folder: folder, From: from, Subject: subject})
+}
+
 func TestRule_Validate(t *testing.T) {
 	rule := &Rule{}
 	valid := func() {
@@ -32,75 +39,474 @@ func TestRule_Match(t *testing.T) {
 	rule.Channels = []string{"#test"}
 	rule.From = "test@test.com"
 
-	if rule.Match("", "") {
+	if matchRule(rule, "INBOX", "", "") {
 		t.Fatal("Do not attempt from rule")
 	}
 
-	if !rule.Match("test@test.com", "") {
+	if !matchRule(rule, "INBOX", "test@test.com", "") {
 		t.Fatal("Attempt from rule")
 	}
 
-	if rule.Match("other@test.com", "") {
+	if matchRule(rule, "INBOX", "other@test.com", "") {
 		t.Fatal("Do not attempt from rule")
 	}
 
-	if !rule.Match("test@test.com", "ansdkjfhad") {
+	if !matchRule(rule, "INBOX", "test@test.com", "ansdkjfhad") {
 		t.Fatal("Attempt from rule subject need to be ignored")
 	}
 
-	if rule.Match("", "ansdkjfhad") {
+	if matchRule(rule, "INBOX", "", "ansdkjfhad") {
 		t.Fatal("Do not attempt from rule subject need to be ignored")
 	}
 
 	rule.Subject = "subject"
 	rule.From = ""
 
-	if rule.Match("", "") {
+	if matchRule(rule, "INBOX", "", "") {
 		t.Fatal("Do not attempt subject rule")
 	}
 
-	if rule.Match("test@test.com", "") {
+	if matchRule(rule, "INBOX", "test@test.com", "") {
 		t.Fatal("Do not attempt subject rule, from need to be ignored")
 	}
 
-	if !rule.Match("test@test.com", "ansdf subject dfad") {
+	if !matchRule(rule, "INBOX", "test@test.com", "ansdf subject dfad") {
 		t.Fatal("Attempt subject rule from need to be ignored")
 	}
 
-	if rule.Match("", "ansdkjfhad") {
+	if matchRule(rule, "INBOX", "", "ansdkjfhad") {
 		t.Fatal("Do not attempt subject rule")
 	}
 
 	rule.Subject = "subject"
 	rule.From = "test@test.com"
 
-	if rule.Match("", "") {
+	if matchRule(rule, "INBOX", "", "") {
 		t.Fatal("Do not attempt rules")
 	}
 
-	if rule.Match("dfadf", "sdfsafa") {
+	if matchRule(rule, "INBOX", "dfadf", "sdfsafa") {
 		t.Fatal("Do not attempt rules")
 	}
 
-	if rule.Match("test@test.com", "") {
+	if matchRule(rule, "INBOX", "test@test.com", "") {
 		t.Fatal("Do not attempt all rules")
 	}
 
-	if rule.Match("", "subject") {
+	if matchRule(rule, "INBOX", "", "subject") {
 		t.Fatal("Do not attempt rules")
 	}
 
-	if !rule.Match("test@test.com", "asdH subject assdhj") {
+	if !matchRule(rule, "INBOX", "test@test.com", "asdH subject assdhj") {
 		t.Fatal("Attempt all rules")
 	}
 }
 
+func TestRule_MatchFolder(t *testing.T) {
+	rule := &Rule{Folder: "Alerts", Channels: []string{"#alerts"}}
+
+	if matchRule(rule, "INBOX", "test@test.com", "subject") {
+		t.Fatal("Do not attempt folder rule")
+	}
+
+	if !matchRule(rule, "alerts", "test@test.com", "subject") {
+		t.Fatal("Attempt folder rule ignoring case")
+	}
+
+	rule.From = "test@test.com"
+
+	if matchRule(rule, "Alerts", "other@test.com", "subject") {
+		t.Fatal("Do not attempt from rule")
+	}
+
+	if !matchRule(rule, "Alerts", "test@test.com", "subject") {
+		t.Fatal("Attempt folder and from rules")
+	}
+}
+
+func TestRule_ValidateRegex(t *testing.T) {
+	rule := &Rule{Channels: []string{"#prod"}}
+
+	tests := []struct {
+		name  string
+		set   func()
+		valid bool
+	}{
+		{"subject regex", func() { rule.Subject = `/^\[PROD\]/` }, true},
+		{"invalid subject regex", func() { rule.Subject = `/^[PROD/` }, false},
+		{"invalid from regex", func() { rule.Subject = ""; rule.From = `/(/` }, false},
+		{"header substring", func() { rule.From = ""; rule.Headers = map[string]string{"To": "oncall+db@"} }, true},
+		{"invalid header regex", func() { rule.Headers = map[string]string{"X-Priority": "/[/"} }, false},
+		{"empty header name", func() { rule.Headers = map[string]string{"": "x"} }, false},
+		{"body regex", func() { rule.Headers = nil; rule.Body = `/(?i)disk usage \d+%/` }, true},
+		{"invalid body regex", func() { rule.Body = `/\/` }, false},
+	}
+
+	for _, tt := range tests {
+		tt.set()
+		if err := rule.Validate(); (err == nil) != tt.valid {
+			t.Fatalf("Test %v expected valid:%v err:%v", tt.name, tt.valid, err)
+		}
+	}
+}
+
+func TestRule_MatchRegexHeadersBody(t *testing.T) {
+	msg := &FilterMessage{
+		Folder:  "INBOX",
+		From:    "Monitor <monitor@example.com>",
+		Subject: "[PROD] Disk usage 95%",
+		Header: map[string][]string{
+			"To":         {"Oncall+DB@example.com", "team@example.com"},
+			"X-Priority": {"1 (Highest)"},
+			"List-Id":    {"Alerts <alerts.example.com>"},
+		},
+		Body: "Disk usage of db1 is 95%\nPlease check",
+	}
+
+	tests := []struct {
+		name  string
+		rule  *Rule
+		match bool
+	}{
+		{"subject starts with", &Rule{Subject: `/^\[PROD\]/`}, true},
+		{"subject does not start with", &Rule{Subject: `/^\[DEV\]/`}, false},
+		{"regex is case sensitive", &Rule{Subject: `/^\[prod\]/`}, false},
+		{"regex ignoring case", &Rule{Subject: `/(?i)^\[prod\]/`}, true},
+		{"substring keeps working", &Rule{Subject: "disk usage"}, true},
+		{"header substring ignoring case", &Rule{Headers: map[string]string{"To": "oncall+db@"}}, true},
+		{"header name ignoring case", &Rule{Headers: map[string]string{"x-priority": "/^1/"}}, true},
+		{"header does not match", &Rule{Headers: map[string]string{"X-Priority": "/^5/"}}, false},
+		{"header not present", &Rule{Headers: map[string]string{"Cc": "oncall"}}, false},
+		{"all headers need to match", &Rule{Headers: map[string]string{"To": "oncall", "List-Id": "billing"}}, false},
+		{"custom header", &Rule{Headers: map[string]string{"List-Id": "<alerts.example.com>"}}, true},
+		{"body substring", &Rule{Body: "please check"}, true},
+		{"body regex", &Rule{Body: `/usage of \w+ is 9\d%/`}, true},
+		{"body does not match", &Rule{Body: "ignore"}, false},
+		{"from and header", &Rule{From: "monitor@", Headers: map[string]string{"To": "team@"}}, true},
+		{"from and body", &Rule{From: "other@", Body: "disk"}, false},
+	}
+
+	for _, tt := range tests {
+		tt.rule.Channels = []string{"#alerts"}
+		tt.rule.Fix()
+
+		if err := tt.rule.Validate(); err != nil {
+			t.Fatalf("Test %v invalid rule err:%v", tt.name, err)
+		}
+
+		if tt.rule.Match(msg) != tt.match {
+			t.Fatalf("Test %v expected match:%v", tt.name, tt.match)
+		}
+	}
+
+	// rule without Validate compiles the regular expression on match
+	if !(&Rule{Subject: `/^\[PROD\]/`}).Match(msg) {
+		t.Fatal("Expected match without Validate")
+	}
+}
+
+func TestRule_MatchGroups(t *testing.T) {
+	alert := &FilterMessage{
+		Folder:  "INBOX",
+		From:    "Monitor <monitor@example.com>",
+		Subject: "[PROD] Disk usage 95%",
+		Header:  map[string][]string{"X-Priority": {"1"}},
+		Body:    "Disk usage of db1 is 95%",
+	}
+
+	resolved := &FilterMessage{
+		Folder:  "INBOX",
+		From:    "Pager <pager@example.com>",
+		Subject: "RESOLVED: [PROD] Disk usage",
+		Header:  map[string][]string{"X-Priority": {"3"}},
+		Body:    "Disk usage of db1 is 40%",
+	}
+
+	other := &FilterMessage{
+		Folder:  "INBOX",
+		From:    "John <john@example.com>",
+		Subject: "Lunch",
+	}
+
+	tests := []struct {
+		name  string
+		rule  *Rule
+		match []bool // alert, resolved, other
+	}{
+		{
+			"any from",
+			&Rule{Any: []*Condition{{From: "monitor@"}, {From: "pager@"}}},
+			[]bool{true, true, false},
+		},
+		{
+			"any from and not resolved",
+			&Rule{Any: []*Condition{{From: "monitor@"}, {From: "pager@"}}, Not: &Condition{Subject: "resolved"}},
+			[]bool{true, false, false},
+		},
+		{
+			"not",
+			&Rule{Not: &Condition{Subject: "/^RESOLVED/"}},
+			[]bool{true, false, true},
+		},
+		{
+			"all",
+			&Rule{All: []*Condition{{Subject: "[prod]"}, {Headers: map[string]string{"X-Priority": "/^1/"}}}},
+			[]bool{true, false, false},
+		},
+		{
+			"fields and groups",
+			&Rule{Body: "disk usage", Any: []*Condition{{From: "john@"}, {From: "pager@"}}},
+			[]bool{false, true, false},
+		},
+		{
+			"nested",
+			&Rule{Any: []*Condition{
+				{All: []*Condition{{From: "monitor@"}, {Not: &Condition{Body: "/ [0-8]\\d%/"}}}},
+				{Subject: "lunch"},
+			}},
+			[]bool{true, false, true},
+		},
+		{
+			"not any",
+			&Rule{Not: &Condition{Any: []*Condition{{From: "monitor@"}, {From: "pager@"}}}},
+			[]bool{false, false, true},
+		},
+		{
+			"double not",
+			&Rule{Not: &Condition{Not: &Condition{From: "john@"}}},
+			[]bool{false, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		tt.rule.Channels = []string{"#alerts"}
+		tt.rule.Fix()
+
+		if err := tt.rule.Validate(); err != nil {
+			t.Fatalf("Test %v invalid rule err:%v", tt.name, err)
+		}
+
+		for i, msg := range []*FilterMessage{alert, resolved, other} {
+			if tt.rule.Match(msg) != tt.match[i] {
+				t.Fatalf("Test %v expected match:%v for %v", tt.name, tt.match[i], msg.Subject)
+			}
+		}
+	}
+}
+
+func TestRule_ValidateGroups(t *testing.T) {
+	tests := []struct {
+		name string
+		rule *Rule
+		path string // empty for valid rules
+	}{
+		{"any", &Rule{Any: []*Condition{{From: "a@"}, {From: "b@"}}}, ""},
+		{"not", &Rule{Not: &Condition{Subject: "resolved"}}, ""},
+		{"empty any", &Rule{Any: []*Condition{}}, "'Any'"},
+		{"empty all", &Rule{From: "a@", All: []*Condition{}}, "'All'"},
+		{"empty condition", &Rule{Any: []*Condition{{From: "a@"}, {}}}, "'Any[1]'"},
+		{"nil condition", &Rule{All: []*Condition{nil}}, "'All[0]'"},
+		{"empty not", &Rule{Not: &Condition{}}, "'Not'"},
+		{"nested regex", &Rule{Any: []*Condition{{From: "a@"}, {Not: &Condition{Subject: "/(/"}}}}, "'Any[1].Not.Subject'"},
+		{"nested header", &Rule{All: []*Condition{{Any: []*Condition{{Headers: map[string]string{"To": "/[/"}}}}}}, "'All[0].Any[0].Headers.To'"},
+		{"nested empty header", &Rule{Not: &Condition{Headers: map[string]string{"": "x"}}}, "'Not.Headers'"},
+	}
+
+	for _, tt := range tests {
+		tt.rule.Channels = []string{"#alerts"}
+		err := tt.rule.Validate()
+
+		if tt.path == "" {
+			if err != nil {
+				t.Fatalf("Test %v expected valid err:%v", tt.name, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), tt.path) {
+			t.Fatalf("Test %v expected error with %v err:%v", tt.name, tt.path, err)
+		}
+	}
+}
+
+func TestFilter_UnmarshalGroups(t *testing.T) {
+	data := `[
+		{"Subject": "x", "Channels": ["#other"]},
+		{
+			"Any": [{"From": "monitor@"}, {"From": "pager@"}],
+			"Not": {"Subject": "Resolved"},
+			"Channels": ["#alerts"]
+		}
+	]`
+
+	filter := &Filter{}
+	if err := json.Unmarshal([]byte(data), filter); err != nil {
+		t.Fatal(err)
+	}
+
+	filter.Fix()
+
+	if err := filter.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &FilterMessage{From: "pager@example.com", Subject: "Disk usage"}
+	if ch := filter.GetChannels(msg); ch[0] != "#alerts" {
+		t.Fatal("Expected #alerts result:", ch)
+	}
+
+	msg.Subject = "RESOLVED disk usage"
+	if ch := filter.GetChannels(msg); ch[0] != "" {
+		t.Fatal("Expected no channel result:", ch)
+	}
+
+	(*filter)[1].Not.Subject = "/(/"
+	if err := filter.Validate(); err == nil || !strings.Contains(err.Error(), "Rule[1]: Field 'Not.Subject'") {
+		t.Fatal("Expected error with rule and field path err:", err)
+	}
+}
+
+func TestFilter_MatchChannels(t *testing.T) {
+	yes, no := true, false
+
+	filter := &Filter{
+		&Rule{Subject: "security", Channels: []string{"#security"}},
+		&Rule{From: "monitor@", Channels: []string{"#team", "#security"}},
+		&Rule{Subject: "disk", Channels: []string{"#infra"}, Continue: &no},
+		&Rule{From: "@example.com", Channels: []string{"#all"}},
+	}
+
+	tests := []struct {
+		name      string
+		subject   string
+		fanOut    bool
+		continue1 *bool
+		channels  []string
+	}{
+		{"first", "security alert", false, nil, []string{"#security"}},
+		{"first skips rule without match", "lunch", false, nil, []string{"#team", "#security"}},
+		{"first with continue", "security alert", false, &yes, []string{"#security", "#team"}},
+		{"fan out without duplicates", "security alert", true, nil, []string{"#security", "#team", "#all"}},
+		{"fan out with stop", "disk security", true, nil, []string{"#security", "#team", "#infra"}},
+		{"fan out with stop in first rule", "security alert", true, &no, []string{"#security"}},
+		{"fan out skips rule without match", "lunch", true, nil, []string{"#team", "#security", "#all"}},
+	}
+
+	for _, tt := range tests {
+		(*filter)[0].Continue = tt.continue1
+		msg := &FilterMessage{From: "monitor@example.com", Subject: tt.subject}
+
+		if channels := filter.MatchChannels(msg, tt.fanOut); !reflect.DeepEqual(channels, tt.channels) {
+			t.Fatalf("Test %v expected channels:%v result:%v", tt.name, tt.channels, channels)
+		}
+	}
+
+	if channels := filter.GetChannels(&FilterMessage{From: "other@test.com"}); !reflect.DeepEqual(channels, []string{""}) {
+		t.Fatal("Expected empty channel result:", channels)
+	}
+}
+
+func TestFilter_MatchDrop(t *testing.T) {
+	filter := &Filter{
+		&Rule{From: "monitor@", Channels: []string{"#team"}, Continue: new(bool)},
+		&Rule{Name: "newsletter", Action: " Drop ", Headers: map[string]string{"List-Unsubscribe": "/./"}},
+		&Rule{Action: RuleActionDrop, Subject: "out of office"},
+		&Rule{From: "@example.com", Channels: []string{"#all"}},
+	}
+
+	filter.Fix()
+
+	if err := filter.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	newsletter := map[string][]string{"List-Unsubscribe": {"<mailto:unsubscribe@example.com>"}}
+
+	tests := []struct {
+		name     string
+		from     string
+		subject  string
+		header   map[string][]string
+		fanOut   bool
+		channels []string
+		dropped  string
+	}{
+		{"post", "john@example.com", "hello", nil, false, []string{"#all"}, ""},
+		{"drop by name", "john@example.com", "news", newsletter, false, nil, "newsletter"},
+		{"drop by index", "john@example.com", "Out of Office", nil, false, nil, "Rule[2]"},
+		{"stop before drop", "monitor@example.com", "Out of Office", nil, false, []string{"#team"}, ""},
+		{"stop before drop in fan out", "monitor@example.com", "Out of Office", nil, true, []string{"#team"}, ""},
+		{"drop in fan out", "john@example.com", "Out of Office", nil, true, nil, "Rule[2]"},
+	}
+
+	for _, tt := range tests {
+		msg := &FilterMessage{From: tt.from, Subject: tt.subject, Header: tt.header}
+		channels, dropped := filter.Match(msg, tt.fanOut)
+
+		if !reflect.DeepEqual(channels, tt.channels) || dropped != tt.dropped {
+			t.Fatalf("Test %v expected channels:%v dropped:%v result:%v %v", tt.name, tt.channels, tt.dropped, channels, dropped)
+		}
+	}
+}
+
+func TestFilter_MatchRule(t *testing.T) {
+	filter := &Filter{
+		&Rule{Subject: "newsletter", Action: RuleActionDrop},
+		&Rule{From: "monitor@", Channels: []string{"#alerts"}},
+		&Rule{Subject: "disk", Channels: []string{"#infra"}},
+	}
+
+	if r := filter.MatchRule(&FilterMessage{From: "monitor@example.com", Subject: "disk newsletter"}); r != (*filter)[1] {
+		t.Fatal("Expected rule 1 result:", r)
+	}
+
+	if r := filter.MatchRule(&FilterMessage{From: "john@example.com", Subject: "disk"}); r != (*filter)[2] {
+		t.Fatal("Expected rule 2 result:", r)
+	}
+
+	if r := filter.MatchRule(&FilterMessage{From: "john@example.com", Subject: "newsletter"}); r != nil {
+		t.Fatal("Expected nil result:", r)
+	}
+}
+
+func TestRule_ValidateAction(t *testing.T) {
+	one, zero := 1, 0
+	template, invalid := "{{.Subject | upper}}", "{{.Subject"
+
+	tests := []struct {
+		name  string
+		rule  *Rule
+		valid bool
+	}{
+		{"post", &Rule{Subject: "x", Action: RuleActionPost, Channels: []string{"#a"}}, true},
+		{"post without channels", &Rule{Subject: "x", Action: RuleActionPost}, false},
+		{"drop", &Rule{Subject: "x", Action: RuleActionDrop}, true},
+		{"drop with channels", &Rule{Subject: "x", Action: RuleActionDrop, Channels: []string{"#a"}}, false},
+		{"drop without condition", &Rule{Action: RuleActionDrop}, false},
+		{"unknown action", &Rule{Subject: "x", Action: "ignore"}, false},
+		{"lines to preview", &Rule{Subject: "x", Channels: []string{"#a"}, LinesToPreview: &one}, true},
+		{"invalid lines to preview", &Rule{Subject: "x", Channels: []string{"#a"}, LinesToPreview: &zero}, false},
+		{"template", &Rule{Subject: "x", Channels: []string{"#a"}, MailTemplate: &template}, true},
+		{"invalid template", &Rule{Subject: "x", Channels: []string{"#a"}, MailTemplate: &invalid}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err == nil) != tt.valid {
+			t.Fatalf("Test %v expected valid:%v err:%v", tt.name, tt.valid, err)
+		}
+	}
+}
+
 func TestFilter_Fix(t *testing.T) {
 	filter := &Filter{
 		&Rule{
 			Channels: []string{"  Channel "},
 			Subject:  " Subject  ",
 			From:     " Test@test.com ",
+			Headers:  map[string]string{"x-priority ": " High"},
+			Body:     " /^Alert/ ",
+			Any:      []*Condition{{From: " Pager@ "}},
+			Not:      &Condition{Headers: map[string]string{"list-id": " Billing "}},
 		},
 	}
 
@@ -119,6 +525,22 @@ func TestFilter_Fix(t *testing.T) {
 	if rule.From != "test@test.com" {
 		t.Fatal("Expected From: test@test.com result:", rule.From)
 	}
+
+	if rule.Headers["X-Priority"] != "high" {
+		t.Fatal("Expected Headers: X-Priority=high result:", rule.Headers)
+	}
+
+	if rule.Body != "/^Alert/" {
+		t.Fatal("Expected Body: /^Alert/ result:", rule.Body)
+	}
+
+	if rule.Any[0].From != "pager@" {
+		t.Fatal("Expected Any[0].From: pager@ result:", rule.Any[0].From)
+	}
+
+	if rule.Not.Headers["List-Id"] != "billing" {
+		t.Fatal("Expected Not.Headers: List-Id=billing result:", rule.Not.Headers)
+	}
 }
 
 func TestFilter_Validate(t *testing.T) {
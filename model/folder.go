@@ -0,0 +1,45 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultFolder = "INBOX"
+
+// Folder type with settings of an email folder watched by the profile
+type Folder struct {
+	Name     string
+	Channels []string `json:",omitempty"`
+}
+
+// Validate check if this folder is valid
+func (f *Folder) Validate() error {
+	if f.Name == "" {
+		return errors.New("Field 'Name' is empty set the folder name eg.: INBOX")
+	}
+
+	for _, channel := range f.Channels {
+		if channel != "" && !validateChannel(channel) {
+			return errors.Errorf("Field 'Channels' of folder '%v' contains invalid chars. This field need to start with # for channel or @ for username: %v", f.Name, channel)
+		}
+	}
+
+	return nil
+}
+
+// Fix remove spaces and convert to lower case the channels
+func (f *Folder) Fix() {
+	f.Name = strings.TrimSpace(f.Name)
+
+	for i, channel := range f.Channels {
+		channel = strings.TrimSpace(channel)
+		channel = strings.ToLower(channel)
+
+		if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "@") {
+			channel = "#" + channel
+		}
+		f.Channels[i] = channel
+	}
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestFolder_Validate(t *testing.T) {
+	folder := &Folder{}
+	if err := folder.Validate(); err == nil {
+		t.Fatal("Expected error for folder without name")
+	}
+
+	folder.Name = "Alerts"
+	folder.Channels = []string{"Channel 1"}
+	if err := folder.Validate(); err == nil {
+		t.Fatal("Expected error for folder with invalid channel")
+	}
+
+	folder.Channels = []string{"#alerts", "@john"}
+	if err := folder.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFolder_Fix(t *testing.T) {
+	folder := &Folder{
+		Name:     " Alerts ",
+		Channels: []string{"  Alerts ", "@John"},
+	}
+
+	folder.Fix()
+
+	if folder.Name != "Alerts" {
+		t.Fatal("Expected Name: Alerts result:", folder.Name)
+	}
+
+	if folder.Channels[0] != "#alerts" || folder.Channels[1] != "@john" {
+		t.Fatal("Expected Channels: [#alerts @john] result:", folder.Channels)
+	}
+}
@@ -0,0 +1,74 @@
+package model
+
+import "github.com/pkg/errors"
+
+const (
+	defaultInlineImagesUpload       = false
+	defaultInlineImagesMinSize      = 4096
+	defaultInlineImagesMinDimension = 64
+)
+
+// InlineImages type with settings of the images embedded in HTML emails, by default they are
+// embedded in email.html as data URIs, Upload posts them as files and removes them from
+// email.html. The images smaller than MinSize bytes or with width or height smaller than
+// MinDimension pixels are not uploaded, they are usually logos and tracking pixels
+type InlineImages struct {
+	Upload       *bool `json:",omitempty"`
+	MinSize      *int  `json:",omitempty"`
+	MinDimension *int  `json:",omitempty"`
+}
+
+// NewInlineImages creates new InlineImages with default values
+func NewInlineImages() *InlineImages {
+	images := &InlineImages{
+		Upload:       new(bool),
+		MinSize:      new(int),
+		MinDimension: new(int),
+	}
+	*images.Upload = defaultInlineImagesUpload
+	*images.MinSize = defaultInlineImagesMinSize
+	*images.MinDimension = defaultInlineImagesMinDimension
+	return images
+}
+
+// Validate check if the inline images settings are valid
+func (c *InlineImages) Validate() error {
+	if c.MinSize != nil && *c.MinSize < 0 {
+		return errors.New("Field 'MinSize' can not be negative")
+	}
+
+	if c.MinDimension != nil && *c.MinDimension < 0 {
+		return errors.New("Field 'MinDimension' can not be negative")
+	}
+	return nil
+}
+
+// Fix fields and using default if is necessary
+func (c *InlineImages) Fix() {
+	if c.Upload == nil {
+		x := defaultInlineImagesUpload
+		c.Upload = &x
+	}
+	if c.MinSize == nil {
+		x := defaultInlineImagesMinSize
+		c.MinSize = &x
+	}
+	if c.MinDimension == nil {
+		x := defaultInlineImagesMinDimension
+		c.MinDimension = &x
+	}
+}
+
+// Accept returns true if the image is large enough to be uploaded, width and height are zero
+// when the dimension of image is unknown
+func (c *InlineImages) Accept(size, width, height int) bool {
+	if c.MinSize != nil && size < *c.MinSize {
+		return false
+	}
+
+	if width == 0 && height == 0 {
+		return true
+	}
+
+	return c.MinDimension == nil || width >= *c.MinDimension && height >= *c.MinDimension
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestInlineImages_Validate(t *testing.T) {
+	images := NewInlineImages()
+
+	if err := images.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	*images.MinSize = -1
+	if err := images.Validate(); err == nil {
+		t.Fatal("Expected error for negative MinSize")
+	}
+
+	*images.MinSize = 0
+	*images.MinDimension = -1
+	if err := images.Validate(); err == nil {
+		t.Fatal("Expected error for negative MinDimension")
+	}
+}
+
+func TestInlineImages_Fix(t *testing.T) {
+	images := &InlineImages{}
+	images.Fix()
+
+	if *images.Upload != defaultInlineImagesUpload || *images.MinSize != defaultInlineImagesMinSize || *images.MinDimension != defaultInlineImagesMinDimension {
+		t.Fatalf("Expected default values result:%+v", images)
+	}
+}
+
+func TestInlineImages_Accept(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		width  int
+		height int
+		want   bool
+	}{
+		{"photo", 50000, 800, 600, true},
+		{"tracking pixel", 43, 1, 1, false},
+		{"small file", 1000, 300, 300, false},
+		{"logo", 8000, 200, 40, false},
+		{"unknown dimension", 8000, 0, 0, true},
+	}
+	images := NewInlineImages()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := images.Accept(tt.size, tt.width, tt.height); got != tt.want {
+				t.Errorf("InlineImages.Accept() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
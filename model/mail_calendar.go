@@ -0,0 +1,135 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Methods of calendar invitations
+const (
+	CalendarMethodRequest = "REQUEST"
+	CalendarMethodCancel  = "CANCEL"
+	CalendarMethodReply   = "REPLY"
+)
+
+// Participation status of attendees
+const (
+	AttendeeAccepted    = "ACCEPTED"
+	AttendeeDeclined    = "DECLINED"
+	AttendeeTentative   = "TENTATIVE"
+	AttendeeNeedsAction = "NEEDS-ACTION"
+)
+
+// MailAttendee attendee of an event and its participation status
+type MailAttendee struct {
+	MailAddress
+	Status string
+}
+
+// MailCalendar event of a calendar invitation (text/calendar) of the email, Start and End are
+// in the timezone of the event, AllDay events have only the date, End is the last day of the
+// event. Recurrence is a summary of the rule ex: "Weekly on Monday until Dec 31, 2017"
+type MailCalendar struct {
+	Method      string
+	Summary     string
+	Description string
+	Location    string
+	Organizer   MailAddress
+	Attendees   []MailAttendee
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	Recurrence  string
+	Cancelled   bool
+}
+
+// Title returns the kind of invitation and the summary ex: ":calendar: **Invitation:** Meeting",
+// cancelled events are marked and the summary is struck through
+func (c *MailCalendar) Title() string {
+	summary := c.Summary
+	if summary == "" {
+		summary = "(no summary)"
+	}
+
+	if c.Cancelled {
+		return ":x: **Cancelled:** ~~" + summary + "~~"
+	}
+
+	if c.Method == CalendarMethodReply && len(c.Attendees) > 0 {
+		a := c.Attendees[0]
+		switch a.Status {
+		case AttendeeAccepted:
+			return ":white_check_mark: **Accepted:** " + summary + " by " + a.String()
+		case AttendeeDeclined:
+			return ":no_entry_sign: **Declined:** " + summary + " by " + a.String()
+		case AttendeeTentative:
+			return ":grey_question: **Tentative:** " + summary + " by " + a.String()
+		}
+		return ":calendar: **Reply:** " + summary + " by " + a.String()
+	}
+
+	if c.Method == CalendarMethodRequest {
+		return ":calendar: **Invitation:** " + summary
+	}
+	return ":calendar: **Event:** " + summary
+}
+
+// When returns the period of the event ex: "Mon, Jun 5 2017 14:00 - 15:00 (America/Sao_Paulo)"
+func (c *MailCalendar) When() string {
+	if c.Start.IsZero() {
+		return ""
+	}
+
+	if c.AllDay {
+		when := c.Start.Format("Mon, Jan 2 2006")
+		if !c.End.IsZero() && !sameDay(c.Start, c.End) {
+			when += " - " + c.End.Format("Mon, Jan 2 2006")
+		}
+		return when + " (all day)"
+	}
+
+	when := c.Start.Format("Mon, Jan 2 2006 15:04")
+	if !c.End.IsZero() {
+		if sameDay(c.Start, c.End) {
+			when += " - " + c.End.Format("15:04")
+		} else {
+			when += " - " + c.End.Format("Mon, Jan 2 2006 15:04")
+		}
+	}
+	return when + " (" + c.Start.Location().String() + ")"
+}
+
+// Card returns the event formatted in markdown used in MailTemplate ex: {{with .Calendar}}{{.Card}}{{end}}
+func (c *MailCalendar) Card() string {
+	lines := []string{c.Title()}
+
+	field := func(name, value string) {
+		if value != "" {
+			lines = append(lines, "**"+name+":** "+value)
+		}
+	}
+
+	field("When", c.When())
+	field("Repeats", c.Recurrence)
+	field("Where", c.Location)
+	field("Organizer", c.Organizer.String())
+
+	if c.Method != CalendarMethodReply {
+		attendees := make([]string, len(c.Attendees))
+		for i, a := range c.Attendees {
+			attendees[i] = a.String()
+			if a.Status != "" && a.Status != AttendeeNeedsAction {
+				attendees[i] += " (" + strings.ToLower(a.Status) + ")"
+			}
+		}
+		field("Attendees", strings.Join(attendees, ", "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMailCalendar_Card(t *testing.T) {
+	loc := time.FixedZone("America/Sao_Paulo", -3*3600)
+	c := &MailCalendar{
+		Method:    CalendarMethodRequest,
+		Summary:   "Weekly status",
+		Location:  "Room 3",
+		Organizer: MailAddress{Name: "John Doe", Address: "jdoe@example.com"},
+		Attendees: []MailAttendee{
+			{MailAddress: MailAddress{Name: "Mary", Address: "mary@example.net"}, Status: AttendeeAccepted},
+			{MailAddress: MailAddress{Address: "test@gmail.com"}, Status: AttendeeNeedsAction},
+		},
+		Start:      time.Date(2017, 6, 6, 14, 0, 0, 0, loc),
+		End:        time.Date(2017, 6, 6, 15, 0, 0, 0, loc),
+		Recurrence: "Weekly on Tuesday",
+	}
+
+	want := ":calendar: **Invitation:** Weekly status\n" +
+		"**When:** Tue, Jun 6 2017 14:00 - 15:00 (America/Sao_Paulo)\n" +
+		"**Repeats:** Weekly on Tuesday\n" +
+		"**Where:** Room 3\n" +
+		"**Organizer:** John Doe <jdoe@example.com>\n" +
+		"**Attendees:** Mary <mary@example.net> (accepted), test@gmail.com"
+	if card := c.Card(); card != want {
+		t.Fatalf("Expected card:\n%v\nresult:\n%v", want, card)
+	}
+
+	c.Method = CalendarMethodCancel
+	c.Cancelled = true
+	if title := c.Title(); title != ":x: **Cancelled:** ~~Weekly status~~" {
+		t.Fatal("Expected cancelled title result:", title)
+	}
+
+	c.Method = CalendarMethodReply
+	c.Cancelled = false
+	c.Attendees = c.Attendees[:1]
+	c.Attendees[0].Status = AttendeeDeclined
+	if title := c.Title(); title != ":no_entry_sign: **Declined:** Weekly status by Mary <mary@example.net>" {
+		t.Fatal("Expected declined title result:", title)
+	}
+
+	if card := c.Card(); card != ":no_entry_sign: **Declined:** Weekly status by Mary <mary@example.net>\n"+
+		"**When:** Tue, Jun 6 2017 14:00 - 15:00 (America/Sao_Paulo)\n**Repeats:** Weekly on Tuesday\n**Where:** Room 3\n"+
+		"**Organizer:** John Doe <jdoe@example.com>" {
+		t.Fatal("Expected reply card without attendees result:", card)
+	}
+}
+
+func TestMailCalendar_When(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *MailCalendar
+		want string
+	}{
+		{"no date", &MailCalendar{}, ""},
+		{"all day", &MailCalendar{AllDay: true, Start: time.Date(2017, 6, 6, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 6, 6, 0, 0, 0, 0, time.UTC)}, "Tue, Jun 6 2017 (all day)"},
+		{"days", &MailCalendar{AllDay: true, Start: time.Date(2017, 6, 6, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 6, 8, 0, 0, 0, 0, time.UTC)}, "Tue, Jun 6 2017 - Thu, Jun 8 2017 (all day)"},
+		{"overnight", &MailCalendar{Start: time.Date(2017, 6, 6, 22, 0, 0, 0, time.UTC), End: time.Date(2017, 6, 7, 2, 0, 0, 0, time.UTC)}, "Tue, Jun 6 2017 22:00 - Wed, Jun 7 2017 02:00 (UTC)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.When(); got != tt.want {
+				t.Errorf("MailCalendar.When() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"net/textproto"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// MailAddress name and address of an email address
+type MailAddress struct {
+	Name    string
+	Address string
+}
+
+// String returns the address in the format "Name <address>" or only the address without name
+func (a MailAddress) String() string {
+	if a.Name == "" {
+		return a.Address
+	}
+	return a.Name + " <" + a.Address + ">"
+}
+
+// MailAddresses list of email addresses
+type MailAddresses []MailAddress
+
+// String returns the addresses separated by comma
+func (l MailAddresses) String() string {
+	s := make([]string, len(l))
+	for i, a := range l {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// MailHeader headers of the email
+type MailHeader map[string][]string
+
+// Get returns the first value of the header, name is case insensitive
+func (h MailHeader) Get(name string) string {
+	if values := h[textproto.CanonicalMIMEHeaderKey(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// MailAttachment name and size in bytes of an attachment
+type MailAttachment struct {
+	Filename string
+	Size     int
+}
+
+// MailTemplateData fields of the email available in MailTemplate, Rule is the filter rule
+// that matched the email or nil, Channel is the channel or user where the email is posted and
+// Calendar is the event of a calendar invitation or nil
+type MailTemplateData struct {
+	Folder  string
+	From    string
+	Subject string
+	Message string
+
+	FromAddress MailAddress
+	ReplyTo     MailAddresses
+	To          MailAddresses
+	Cc          MailAddresses
+	Date        time.Time
+	MessageID   string
+	Header      MailHeader
+	Attachments []MailAttachment
+	Rule        *Rule
+	Channel     string
+	Calendar    *MailCalendar
+}
+
+// mailTemplateFuncs functions available in MailTemplate
+var mailTemplateFuncs = template.FuncMap{
+	"truncate":       truncate,
+	"escapeMarkdown": escapeMarkdown,
+	"codeBlock":      codeBlock,
+	"lower":          strings.ToLower,
+	"upper":          strings.ToUpper,
+	"default":        defaultValue,
+	"regexReplace":   regexReplace,
+	"humanizeSize":   HumanSize,
+	"formatDate":     formatDate,
+}
+
+// parseMailTemplate parses the MailTemplate with the functions of mailTemplateFuncs
+func parseMailTemplate(text string) (*template.Template, error) {
+	return template.New("MailTemplate").Funcs(mailTemplateFuncs).Parse(text)
+}
+
+// truncate limits s to n characters adding "..." when it is cut ex: {{.Subject | truncate 50}}
+func truncate(n int, s string) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
+
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "~", `\~`, "[", `\[`, "]", `\]`,
+	"#", `\#`, ">", `\>`, "<", `\<`, "|", `\|`,
+)
+
+// escapeMarkdown escapes the characters used by markdown ex: {{.Subject | escapeMarkdown}}
+func escapeMarkdown(s string) string {
+	return markdownReplacer.Replace(s)
+}
+
+// codeBlock quotes s in a markdown code block, the fence is longer than any sequence of
+// backticks in s
+func codeBlock(s string) string {
+	fence := "```"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	return fence + "\n" + strings.TrimRight(s, "\n") + "\n" + fence
+}
+
+// defaultValue returns value or def when value is empty ex: {{.Subject | default "(no subject)"}}
+func defaultValue(def, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+
+	if v := reflect.ValueOf(value); v.IsZero() || (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0 {
+		return def
+	}
+	return value
+}
+
+// regexReplace replaces the matches of pattern in s by repl, repl can use $1 for submatches
+// ex: {{.Subject | regexReplace "^(RE|FW): " ""}}
+func regexReplace(pattern, repl, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// formatDate formats the date using the layout of time.Format, zero date returns empty
+// ex: {{formatDate "2006-01-02 15:04" .Date}}
+func formatDate(layout string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
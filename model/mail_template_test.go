@@ -0,0 +1,133 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMailAddress_String(t *testing.T) {
+	tests := []struct {
+		name string
+		l    MailAddresses
+		want string
+	}{
+		{"empty", nil, ""},
+		{"address", MailAddresses{{Address: "jdoe@example.com"}}, "jdoe@example.com"},
+		{"name", MailAddresses{{Name: "John Doe", Address: "jdoe@example.com"}}, "John Doe <jdoe@example.com>"},
+		{"list", MailAddresses{{Name: "John", Address: "john@example.com"}, {Address: "mary@example.com"}}, "John <john@example.com>, mary@example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.l.String(); got != tt.want {
+			t.Fatalf("Test %v expected:'%v' result:'%v'", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMailHeader_Get(t *testing.T) {
+	h := MailHeader{"X-Priority": {"1", "2"}}
+
+	if v := h.Get("x-priority"); v != "1" {
+		t.Fatal("Expected 1 result:", v)
+	}
+
+	if v := h.Get("List-Id"); v != "" {
+		t.Fatal("Expected empty result:", v)
+	}
+}
+
+func TestPostSettings_FormatMailTemplate(t *testing.T) {
+	data := &MailTemplateData{
+		Folder:      "INBOX",
+		From:        "John Doe <jdoe@example.com>",
+		Subject:     "Report",
+		Message:     "See attached",
+		FromAddress: MailAddress{Name: "John Doe", Address: "jdoe@example.com"},
+		To:          MailAddresses{{Name: "Team", Address: "team@example.com"}, {Address: "mary@example.com"}},
+		Date:        time.Date(2017, 6, 5, 11, 8, 54, 0, time.UTC),
+		MessageID:   "1234@example.com",
+		Header:      MailHeader{"X-Priority": {"1"}},
+		Attachments: []MailAttachment{{Filename: "report.pdf", Size: 1024}, {Filename: "data.csv", Size: 10}},
+		Rule:        &Rule{Name: "reports"},
+		Channel:     "#reports",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"old fields", "{{.Folder}}|{{.From}}|{{.Subject}}|{{.Message}}", "INBOX|John Doe <jdoe@example.com>|Report|See attached"},
+		{"date and to", `{{.Date.Format "2006-01-02"}} to {{.To}} with {{len .Attachments}} files`, "2017-06-05 to Team <team@example.com>, mary@example.com with 2 files"},
+		{"address", "{{.FromAddress.Name}}|{{.FromAddress.Address}}|{{(index .To 1).Address}}", "John Doe|jdoe@example.com|mary@example.com"},
+		{"header", `{{.Header.Get "x-priority"}}|{{.MessageID}}`, "1|1234@example.com"},
+		{"attachments", "{{range .Attachments}}{{.Filename}}:{{.Size}} {{end}}", "report.pdf:1024 data.csv:10 "},
+		{"rule and channel", "{{if .Rule}}{{.Rule.Name}}{{end}}|{{.Channel}}", "reports|#reports"},
+	}
+
+	for _, tt := range tests {
+		s := &PostSettings{MailTemplate: tt.template}
+		got, err := s.FormatMailTemplate(data)
+		if err != nil {
+			t.Fatalf("Test %v err:%v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Fatalf("Test %v expected:'%v' result:'%v'", tt.name, tt.want, got)
+		}
+	}
+
+	s := &PostSettings{MailTemplate: "{{.Unknown}}"}
+	if _, err := s.FormatMailTemplate(data); err == nil {
+		t.Fatal("Expected error for unknown field")
+	}
+}
+
+func TestMailTemplateFuncs(t *testing.T) {
+	data := &MailTemplateData{
+		Subject:     "RE: [PROD] *Disk* usage_95%",
+		Message:     "df -h\n```\n/dev/sda1 95%\n```\n",
+		Date:        time.Date(2017, 6, 5, 11, 8, 54, 0, time.UTC),
+		Attachments: []MailAttachment{{Filename: "a.log", Size: 512}, {Filename: "b.log", Size: 1536}, {Filename: "c.iso", Size: 3 * 1024 * 1024}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"truncate", "{{.Subject | truncate 9}}", "RE: [PROD..."},
+		{"truncate short", "{{.Subject | truncate 100}}", data.Subject},
+		{"escape markdown", "{{.Subject | escapeMarkdown}}", `RE: \[PROD\] \*Disk\* usage\_95%`},
+		{"code block", "{{.Message | codeBlock}}", "````\ndf -h\n```\n/dev/sda1 95%\n```\n````"},
+		{"lower upper", "{{.Subject | lower | truncate 3}}|{{upper \"prod\"}}", "re:...|PROD"},
+		{"default", `{{.Folder | default "INBOX"}}|{{.Subject | default "none" | truncate 2}}|{{.To | default "nobody"}}`, "INBOX|RE...|nobody"},
+		{"regex replace", `{{.Subject | regexReplace "^(RE|FW): " "" | regexReplace "\\[(\\w+)\\]" "($1)"}}`, "(PROD) *Disk* usage_95%"},
+		{"humanize size", "{{range .Attachments}}{{humanizeSize .Size}};{{end}}", "512 B;1.5 KB;3.0 MB;"},
+		{"format date", `{{.Date | formatDate "02/01/2006 15:04"}}`, "05/06/2017 11:08"},
+	}
+
+	for _, tt := range tests {
+		s := &PostSettings{MailTemplate: tt.template}
+		got, err := s.FormatMailTemplate(data)
+		if err != nil {
+			t.Fatalf("Test %v err:%v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Fatalf("Test %v expected:'%v' result:'%v'", tt.name, tt.want, got)
+		}
+	}
+
+	if got := formatDate("2006-01-02", data.Date); got != "2017-06-05" {
+		t.Fatal("Expected 2017-06-05 result:", got)
+	}
+
+	if got := formatDate("2006-01-02", time.Time{}); got != "" {
+		t.Fatal("Expected empty date result:", got)
+	}
+
+	if _, err := regexReplace("(", "", "x"); err == nil {
+		t.Fatal("Expected error for invalid regular expression")
+	}
+}
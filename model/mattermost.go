@@ -2,28 +2,54 @@ package model
 
 import "github.com/pkg/errors"
 
-const defaultUseAPIv3 = false
+const (
+	defaultUseAPIv3       = false
+	defaultMaxAttachments = 5
+)
 
 // Mattermost type with Mattermost connection settings
 type Mattermost struct {
-	Server   string
-	Team     string
-	User     string
-	Password string
-	UseAPIv3 *bool `json:",omitempty"`
+	Server          string `json:",omitempty"`
+	Team            string `json:",omitempty"`
+	User            string `json:",omitempty"`
+	Password        string `json:",omitempty"`
+	Token           string `json:",omitempty"`
+	UseAPIv3        *bool  `json:",omitempty"`
+	WebhookURL      string `json:",omitempty"`
+	WebhookUsername string `json:",omitempty"`
+	WebhookIconURL  string `json:",omitempty"`
+	MaxAttachments  *int   `json:",omitempty"`
 }
 
 // NewMattermost creates new Mattermost with default values
 func NewMattermost() *Mattermost {
 	mm := &Mattermost{
-		UseAPIv3: new(bool),
+		UseAPIv3:       new(bool),
+		MaxAttachments: new(int),
 	}
 	*mm.UseAPIv3 = defaultUseAPIv3
+	*mm.MaxAttachments = defaultMaxAttachments
 	return mm
 }
 
 // Validate valids Mattermost
 func (c *Mattermost) Validate() error {
+	if c.MaxAttachments != nil && *c.MaxAttachments <= 0 {
+		return errors.New("Field 'MaxAttachments' need to be greater than 0")
+	}
+
+	if c.WebhookURL != "" {
+		// incoming webhook does not need server and user
+		if !validateWebhookURL(c.WebhookURL) {
+			return errors.Errorf("Field 'WebhookURL' need to start with http:// or https:// and be a valid url: %v", c.WebhookURL)
+		}
+
+		if c.WebhookIconURL != "" && !validateWebhookURL(c.WebhookIconURL) {
+			return errors.Errorf("Field 'WebhookIconURL' need to start with http:// or https:// and be a valid url: %v", c.WebhookIconURL)
+		}
+		return nil
+	}
+
 	if c.Server == "" {
 		return errors.New("Field 'Server' is empty set mattermost address eg.: https://mattermost.example.com")
 	}
@@ -40,8 +66,15 @@ func (c *Mattermost) Validate() error {
 		return errors.Errorf("Field 'Team' contains invalid chars, make sure if you are using url team name: %v", c.Team)
 	}
 
+	if c.Token != "" {
+		if c.UseAPIv3 != nil && *c.UseAPIv3 {
+			return errors.New("Field 'Token' is not supported by Mattermost Api V3, set UseAPIv3 to false")
+		}
+		return nil
+	}
+
 	if c.User == "" {
-		return errors.New("Field 'User' is empty")
+		return errors.New("Field 'User' is empty, set User and Password or a personal access Token")
 	}
 
 	if c.Password == "" {
@@ -57,4 +90,8 @@ func (c *Mattermost) Fix() {
 		x := defaultUseAPIv3
 		c.UseAPIv3 = &x
 	}
+	if c.MaxAttachments == nil {
+		x := defaultMaxAttachments
+		c.MaxAttachments = &x
+	}
 }
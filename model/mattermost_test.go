@@ -34,4 +34,37 @@ func TestMattermost_Validate(t *testing.T) {
 	if err := config.Validate(); err != nil {
 		t.Fatal(err)
 	}
+
+	config.User = ""
+	config.Password = ""
+	config.Token = "h4kx7mqwbjb8tjgdfs1t8r3kgh"
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.MaxAttachments = new(int)
+	valid(6)
+
+	*config.MaxAttachments = 10
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.UseAPIv3 = new(bool)
+	*config.UseAPIv3 = true
+	valid(7)
+
+	config = &Mattermost{WebhookURL: "mattermost.example.com"}
+	valid(8)
+
+	config.WebhookURL = "https://mattermost.example.com/hooks/ib9fxi3ioj8xfxt8jbi8grkrby"
+	config.WebhookIconURL = "icon.png"
+	valid(9)
+
+	config.WebhookIconURL = "https://mattermost.example.com/icon.png"
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
 }
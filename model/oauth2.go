@@ -0,0 +1,67 @@
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	// OAuth2MechanismXOAuth2 SASL mechanism used by Gmail and Office 365
+	OAuth2MechanismXOAuth2 = "XOAUTH2"
+
+	// OAuth2MechanismOAuthBearer SASL mechanism defined in RFC 7628
+	OAuth2MechanismOAuthBearer = "OAUTHBEARER"
+
+	defaultOAuth2Mechanism = OAuth2MechanismXOAuth2
+)
+
+// OAuth2 type with settings used to authenticate on IMAP server using an OAuth2 access token
+type OAuth2 struct {
+	ClientID     string
+	ClientSecret string `json:",omitempty"`
+	RefreshToken string
+	TokenURL     string
+	Scope        string  `json:",omitempty"`
+	Mechanism    *string `json:",omitempty"`
+}
+
+// NewOAuth2 creates new OAuth2 with default values
+func NewOAuth2() *OAuth2 {
+	oauth := &OAuth2{
+		Mechanism: new(string),
+	}
+	*oauth.Mechanism = defaultOAuth2Mechanism
+	return oauth
+}
+
+// Validate set default value for oauth2 and check if valid return err
+func (c *OAuth2) Validate() error {
+	if c.ClientID == "" {
+		return errors.New("Field 'ClientID' is empty set the client id of the application registered in the provider")
+	}
+
+	if c.RefreshToken == "" {
+		return errors.New("Field 'RefreshToken' is empty")
+	}
+
+	if c.TokenURL == "" {
+		return errors.New("Field 'TokenURL' is empty set token endpoint eg.: https://oauth2.googleapis.com/token")
+	}
+
+	if !validateWebhookURL(c.TokenURL) {
+		return errors.Errorf("Field 'TokenURL' need to be a valid url: %v", c.TokenURL)
+	}
+
+	if c.Mechanism != nil && *c.Mechanism != OAuth2MechanismXOAuth2 && *c.Mechanism != OAuth2MechanismOAuthBearer {
+		return errors.Errorf("Field 'Mechanism' need to be %v or %v: %v", OAuth2MechanismXOAuth2, OAuth2MechanismOAuthBearer, *c.Mechanism)
+	}
+
+	return nil
+}
+
+// Fix fields and using default if is necessary
+func (c *OAuth2) Fix() {
+	if c.Mechanism == nil {
+		x := defaultOAuth2Mechanism
+		c.Mechanism = &x
+	}
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestOAuth2_Validate(t *testing.T) {
+	config := &OAuth2{}
+	valid := func(n int) {
+		if err := config.Validate(); err == nil {
+			t.Fatal("Test:", n, "this config need to be invalid")
+		}
+	}
+
+	valid(0)
+
+	config.ClientID = "client"
+	valid(1)
+
+	config.RefreshToken = "refresh"
+	valid(2)
+
+	config.TokenURL = "oauth2.googleapis.com/token"
+	valid(3)
+
+	config.TokenURL = "https://login.microsoftonline.com/common/oauth2/v2.0/token"
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	mechanism := "PLAIN"
+	config.Mechanism = &mechanism
+	valid(4)
+
+	mechanism = OAuth2MechanismOAuthBearer
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOAuth2_Fix(t *testing.T) {
+	o := OAuth2{}
+	o.Fix()
+
+	if *o.Mechanism != defaultOAuth2Mechanism {
+		t.Fatal("Expected Mechanism:", defaultOAuth2Mechanism, " result:", *o.Mechanism)
+	}
+}
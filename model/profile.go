@@ -9,11 +9,33 @@ import (
 )
 
 const (
-	defaultMailTemplate      = ":incoming_envelope: _From: **{{.From}}**_\n>_{{.Subject}}_\n\n{{.Message}}"
+	defaultMailTemplate      = ":incoming_envelope: _From: **{{.From}}**_\n>_{{.Subject}}_\n\n{{with .Calendar}}{{.Card}}\n\n{{end}}{{.Message}}"
 	defaultLinesToPreview    = 10
 	defaultRedirectBySubject = true
 	defaultAttachment        = true
 	defaultDisabled          = false
+	defaultThreadReplies     = true
+	defaultRouting           = RoutingFirst
+	defaultSplitLongMessages = false
+	defaultMessageSource     = MessageSourceText
+)
+
+const (
+	// RoutingFirst posts the email in the channels of the first option that resolves:
+	// subject, filter or default channels
+	RoutingFirst = "first"
+
+	// RoutingAll posts the email in the channels of the subject and of all filter rules that match
+	RoutingAll = "all"
+)
+
+const (
+	// MessageSourceText posts the text part of the email, emails without text part are posted
+	// with the HTML part converted to Markdown
+	MessageSourceText = "text"
+
+	// MessageSourceHTML posts the HTML part of the email converted to Markdown when it exists
+	MessageSourceHTML = "html"
 )
 
 // Profile type with general service settings
@@ -25,9 +47,20 @@ type Profile struct {
 	RedirectBySubject *bool   `json:",omitempty"`
 	Attachment        *bool   `json:",omitempty"`
 	Disabled          *bool   `json:",omitempty"`
+	ThreadReplies     *bool   `json:",omitempty"`
+	Routing           *string `json:",omitempty"`
+	SplitLongMessages *bool   `json:",omitempty"`
+	MessageSource     *string `json:",omitempty"`
 	Email             *Email
 	Mattermost        *Mattermost
-	Filter            *Filter `json:",omitempty"`
+	SMTP              *SMTP         `json:",omitempty"`
+	Filter            *Filter       `json:",omitempty"`
+	Sanitize          *Sanitize     `json:",omitempty"`
+	Strip             *Strip        `json:",omitempty"`
+	InlineImages      *InlineImages `json:",omitempty"`
+
+	// MailTemplate parsed in Validate
+	mailTemplate *template.Template
 }
 
 // NewProfile creates new Profile with default values
@@ -38,14 +71,25 @@ func NewProfile() *Profile {
 		RedirectBySubject: new(bool),
 		Attachment:        new(bool),
 		Disabled:          new(bool),
+		ThreadReplies:     new(bool),
+		Routing:           new(string),
+		SplitLongMessages: new(bool),
+		MessageSource:     new(string),
 		Email:             NewEmail(),
 		Mattermost:        NewMattermost(),
+		Sanitize:          NewSanitize(),
+		Strip:             NewStrip(),
+		InlineImages:      NewInlineImages(),
 	}
 	*profile.MailTemplate = defaultMailTemplate
 	*profile.LinesToPreview = defaultLinesToPreview
 	*profile.RedirectBySubject = defaultRedirectBySubject
 	*profile.Attachment = defaultAttachment
 	*profile.Disabled = defaultDisabled
+	*profile.ThreadReplies = defaultThreadReplies
+	*profile.Routing = defaultRouting
+	*profile.SplitLongMessages = defaultSplitLongMessages
+	*profile.MessageSource = defaultMessageSource
 
 	return profile
 }
@@ -70,6 +114,22 @@ func (c *Profile) Validate() error {
 		return errors.New("Field 'LinesToPreview' need to be greater than 0")
 	}
 
+	if c.MailTemplate != nil {
+		t, err := parseMailTemplate(*c.MailTemplate)
+		if err != nil {
+			return errors.Wrap(err, "Field 'MailTemplate' has an invalid template")
+		}
+		c.mailTemplate = t
+	}
+
+	if c.Routing != nil && *c.Routing != RoutingFirst && *c.Routing != RoutingAll {
+		return errors.Errorf("Field 'Routing' need to be '%v' or '%v'", RoutingFirst, RoutingAll)
+	}
+
+	if c.MessageSource != nil && *c.MessageSource != MessageSourceText && *c.MessageSource != MessageSourceHTML {
+		return errors.Errorf("Field 'MessageSource' need to be '%v' or '%v'", MessageSourceText, MessageSourceHTML)
+	}
+
 	if c.Email == nil {
 		return errors.New("Field 'Email' is empty set Email configuration")
 	}
@@ -90,12 +150,50 @@ func (c *Profile) Validate() error {
 		}
 	}
 
+	if c.SMTP != nil {
+		if err := c.SMTP.Validate(); err != nil {
+			return errors.Wrap(err, "Error in SMTP")
+		}
+
+		if c.Mattermost.WebhookURL != "" {
+			return errors.New("Field 'SMTP' can not be used with Mattermost 'WebhookURL', replies are not available using incoming webhook")
+		}
+	}
+
+	if c.Mattermost.WebhookURL != "" {
+		if c.ThreadReplies != nil && *c.ThreadReplies {
+			return errors.New("Field 'ThreadReplies' can not be used with Mattermost 'WebhookURL', threads are not available using incoming webhook")
+		}
+
+		if c.SplitLongMessages != nil && *c.SplitLongMessages {
+			return errors.New("Field 'SplitLongMessages' can not be used with Mattermost 'WebhookURL', threads are not available using incoming webhook")
+		}
+	}
+
 	if c.Filter != nil {
 		if err := c.Filter.Validate(); err != nil {
 			return errors.Errorf("Error in Filter:%v", err)
 		}
 	}
 
+	if c.Sanitize != nil {
+		if err := c.Sanitize.Validate(); err != nil {
+			return errors.Wrap(err, "Error in Sanitize")
+		}
+	}
+
+	if c.Strip != nil {
+		if err := c.Strip.Validate(); err != nil {
+			return errors.Wrap(err, "Error in Strip")
+		}
+	}
+
+	if c.InlineImages != nil {
+		if err := c.InlineImages.Validate(); err != nil {
+			return errors.Wrap(err, "Error in InlineImages")
+		}
+	}
+
 	return nil
 }
 
@@ -131,6 +229,27 @@ func (c *Profile) Fix() {
 		x := defaultDisabled
 		c.Disabled = &x
 	}
+	if c.ThreadReplies == nil {
+		// incoming webhook does not support threads
+		x := defaultThreadReplies && (c.Mattermost == nil || c.Mattermost.WebhookURL == "")
+		c.ThreadReplies = &x
+	}
+	if c.SplitLongMessages == nil {
+		x := defaultSplitLongMessages
+		c.SplitLongMessages = &x
+	}
+	if c.Routing == nil {
+		x := defaultRouting
+		c.Routing = &x
+	} else {
+		*c.Routing = strings.ToLower(strings.TrimSpace(*c.Routing))
+	}
+	if c.MessageSource == nil {
+		x := defaultMessageSource
+		c.MessageSource = &x
+	} else {
+		*c.MessageSource = strings.ToLower(strings.TrimSpace(*c.MessageSource))
+	}
 
 	if c.Email != nil {
 		c.Email.Fix()
@@ -140,31 +259,103 @@ func (c *Profile) Fix() {
 		c.Mattermost.Fix()
 	}
 
+	if c.SMTP != nil {
+		c.SMTP.Fix()
+	}
+
 	if c.Filter != nil {
 		c.Filter.Fix()
 	}
+
+	// mentions are neutralized by default
+	if c.Sanitize == nil {
+		c.Sanitize = NewSanitize()
+	}
+	c.Sanitize.Fix()
+
+	// nothing is removed from preview unless enabled, profiles keep posting the full email
+	if c.Strip == nil {
+		c.Strip = NewStrip()
+	}
+	c.Strip.Fix()
+
+	if c.InlineImages == nil {
+		c.InlineImages = NewInlineImages()
+	}
+	c.InlineImages.Fix()
 }
 
-// FormatMailTemplate formats MailTemplate using fields
-func (c *Profile) FormatMailTemplate(from, subject, message string) (string, error) {
-	t, err := template.New("").Parse(*c.MailTemplate)
-	if err != nil {
-		return "", errors.Wrapf(err, "parse MailTemplate %v", c.MailTemplate)
+// PostSettings settings used to create the post of an email
+type PostSettings struct {
+	MailTemplate   string
+	LinesToPreview int
+	Attachment     bool
+
+	// MailTemplate parsed or nil
+	mailTemplate *template.Template
+}
+
+// PostSettings returns the settings of the profile overridden by the settings of the rule,
+// rule can be nil
+func (c *Profile) PostSettings(rule *Rule) *PostSettings {
+	s := &PostSettings{
+		MailTemplate:   defaultMailTemplate,
+		LinesToPreview: defaultLinesToPreview,
+		Attachment:     defaultAttachment,
+	}
+
+	if c.MailTemplate != nil {
+		s.MailTemplate = *c.MailTemplate
+		s.mailTemplate = c.mailTemplate
+	}
+	if c.LinesToPreview != nil {
+		s.LinesToPreview = *c.LinesToPreview
+	}
+	if c.Attachment != nil {
+		s.Attachment = *c.Attachment
+	}
+
+	if rule == nil {
+		return s
+	}
+
+	if rule.MailTemplate != nil {
+		s.MailTemplate = *rule.MailTemplate
+		s.mailTemplate = rule.mailTemplate
+	}
+	if rule.LinesToPreview != nil {
+		s.LinesToPreview = *rule.LinesToPreview
 	}
+	if rule.Attachment != nil {
+		s.Attachment = *rule.Attachment
+	}
+	return s
+}
 
-	r := &struct {
-		From    string
-		Subject string
-		Message string
-	}{
+// FormatMailTemplate formats MailTemplate using fields
+func (c *Profile) FormatMailTemplate(folder, from, subject, message string) (string, error) {
+	return c.PostSettings(nil).FormatMailTemplate(&MailTemplateData{
+		Folder:  folder,
 		From:    from,
 		Subject: subject,
 		Message: message,
+	})
+}
+
+// FormatMailTemplate formats MailTemplate using the fields of data
+func (s *PostSettings) FormatMailTemplate(data *MailTemplateData) (string, error) {
+	t := s.mailTemplate
+	if t == nil {
+		// profile or rule was not validated
+		var err error
+		if t, err = parseMailTemplate(s.MailTemplate); err != nil {
+			return "", errors.Wrapf(err, "parse MailTemplate %v", s.MailTemplate)
+		}
 	}
 
 	buff := &bytes.Buffer{}
-	if err = t.Execute(buff, r); err != nil {
-		return "", errors.Wrapf(err, "Error on execute MailTemplate %v", c.MailTemplate)
+	if err := t.Execute(buff, data); err != nil {
+		return "", errors.Wrapf(err, "Error on execute MailTemplate %v", s.MailTemplate)
 	}
 
 	return buff.String(), nil
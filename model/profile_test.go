@@ -62,6 +62,70 @@ func TestProfile_Validate(t *testing.T) {
 
 	config.Filter = nil
 
+	config.SMTP = &SMTP{}
+	valid(11)
+
+	config.SMTP = &SMTP{Server: "smtp.example.com:587", From: "orders@example.com"}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Routing = new(string)
+	*config.Routing = "fanout"
+	valid(12)
+
+	*config.Routing = RoutingFirst
+	config.MailTemplate = new(string)
+	*config.MailTemplate = "{{.From"
+	valid(13)
+
+	*config.MailTemplate = "{{.From | unknownFunc}}"
+	valid(14)
+
+	*config.MailTemplate = "{{.Subject | truncate 50 | escapeMarkdown}}"
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	*config.Routing = RoutingAll
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.MessageSource = new(string)
+	*config.MessageSource = "markdown"
+	valid(15)
+
+	*config.MessageSource = MessageSourceHTML
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Strip = &Strip{Disclaimers: []string{"/(/"}}
+	valid(16)
+
+	config.Strip = NewStrip()
+	config.InlineImages = &InlineImages{MinSize: new(int)}
+	*config.InlineImages.MinSize = -1
+	valid(17)
+
+	config.InlineImages = NewInlineImages()
+	config.Sanitize = &Sanitize{AllowMentions: []string{"example.com"}}
+	valid(18)
+
+	config.Sanitize = NewSanitize()
+	config.SMTP = nil
+	config.Mattermost = &Mattermost{WebhookURL: "https://mattermost.example.com/hooks/xxx"}
+	config.ThreadReplies = new(bool)
+	*config.ThreadReplies = true
+	valid(19)
+
+	*config.ThreadReplies = false
+	config.SplitLongMessages = new(bool)
+	*config.SplitLongMessages = true
+	valid(20)
+
+	*config.SplitLongMessages = false
 	if err := config.Validate(); err != nil {
 		t.Fatal(err)
 	}
@@ -91,10 +155,79 @@ func TestProfile_Fix(t *testing.T) {
 	if *p.Disabled != defaultDisabled {
 		t.Fatal("Expected Disabled:", defaultDisabled, " result:", *p.Disabled)
 	}
+	if *p.ThreadReplies != defaultThreadReplies {
+		t.Fatal("Expected ThreadReplies:", defaultThreadReplies, " result:", *p.ThreadReplies)
+	}
+	if p.Sanitize == nil || !*p.Sanitize.Mentions {
+		t.Fatal("Expected Sanitize with Mentions result:", p.Sanitize)
+	}
+	if p.Strip == nil || *p.Strip.Quotes || *p.Strip.Signature {
+		t.Fatal("Expected Strip without Quotes and Signature result:", p.Strip)
+	}
+	if p.InlineImages == nil || *p.InlineImages.Upload {
+		t.Fatal("Expected InlineImages without Upload result:", p.InlineImages)
+	}
+	if *p.Routing != defaultRouting {
+		t.Fatal("Expected Routing:", defaultRouting, " result:", *p.Routing)
+	}
+
+	if *p.MessageSource != defaultMessageSource {
+		t.Fatal("Expected MessageSource:", defaultMessageSource, " result:", *p.MessageSource)
+	}
+
+	*p.Routing = " All "
+	*p.MessageSource = " HTML "
+	p.Fix()
+
+	if *p.Routing != RoutingAll {
+		t.Fatal("Expected Routing:", RoutingAll, " result:", *p.Routing)
+	}
+	if *p.MessageSource != MessageSourceHTML {
+		t.Fatal("Expected MessageSource:", MessageSourceHTML, " result:", *p.MessageSource)
+	}
+
+	// incoming webhook does not post in threads by default
+	p = &Profile{Mattermost: &Mattermost{WebhookURL: "https://mattermost.example.com/hooks/xxx"}}
+	p.Fix()
+
+	if *p.ThreadReplies {
+		t.Fatal("Expected ThreadReplies disabled with WebhookURL")
+	}
+}
+
+func TestProfile_PostSettings(t *testing.T) {
+	p := NewProfile()
+	*p.LinesToPreview = 5
+
+	s := p.PostSettings(nil)
+	if s.MailTemplate != defaultMailTemplate || s.LinesToPreview != 5 || s.Attachment != defaultAttachment {
+		t.Fatalf("Expected settings of profile result:%+v", s)
+	}
+
+	rule := &Rule{MailTemplate: new(string), Attachment: new(bool)}
+	*rule.MailTemplate = "{{.Subject}}"
+
+	s = p.PostSettings(rule)
+	if s.MailTemplate != "{{.Subject}}" || s.LinesToPreview != 5 || s.Attachment {
+		t.Fatalf("Expected settings of rule result:%+v", s)
+	}
+
+	rule.LinesToPreview = new(int)
+	*rule.LinesToPreview = 1
+
+	if s = p.PostSettings(rule); s.LinesToPreview != 1 {
+		t.Fatal("Expected LinesToPreview: 1 result:", s.LinesToPreview)
+	}
+
+	// profile without Fix uses the default values
+	if s = (&Profile{}).PostSettings(nil); s.MailTemplate != defaultMailTemplate || s.LinesToPreview != defaultLinesToPreview {
+		t.Fatalf("Expected default settings result:%+v", s)
+	}
 }
 
 func TestProfile_FormatMailTemplate(t *testing.T) {
 	type args struct {
+		folder  string
 		from    string
 		subject string
 		message string
@@ -106,7 +239,7 @@ func TestProfile_FormatMailTemplate(t *testing.T) {
 		}
 		*profile.MailTemplate = template
 
-		result, err := profile.FormatMailTemplate(args.from, args.subject, args.message)
+		result, err := profile.FormatMailTemplate(args.folder, args.from, args.subject, args.message)
 		gotErr := (err != nil)
 		if gotErr != wantErr {
 			if gotErr {
@@ -126,4 +259,5 @@ func TestProfile_FormatMailTemplate(t *testing.T) {
 	assert(3, ">{{.From}}, {{.Subject}}, {{.Message}}", ">test@test.com, subject, message", false, args{from: "test@test.com", subject: "subject", message: "message"})
 	assert(4, ">{{.Nothing}}", "", true, args{from: "test@test.com", subject: "", message: ""})
 	assert(5, ">{{.Noth%ing}}", "", true, args{from: "test@test.com", subject: "", message: ""})
+	assert(6, "{{.Folder}}>{{.From}}", "Alerts>test@test.com", false, args{folder: "Alerts", from: "test@test.com", subject: "", message: ""})
 }
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultReceiverAddress = "127.0.0.1:2525"
+	defaultLMTP            = false
+	defaultMaxMessageSize  = 10 * 1024 * 1024
+)
+
+// Receiver type with settings of the SMTP/LMTP listener used to receive emails from a MTA
+type Receiver struct {
+	Address        string
+	Hostname       string `json:",omitempty"`
+	LMTP           *bool  `json:",omitempty"`
+	MaxMessageSize *int   `json:",omitempty"`
+}
+
+// NewReceiver creates new Receiver with default values
+func NewReceiver() *Receiver {
+	receiver := &Receiver{
+		Address:        defaultReceiverAddress,
+		LMTP:           new(bool),
+		MaxMessageSize: new(int),
+	}
+	*receiver.LMTP = defaultLMTP
+	*receiver.MaxMessageSize = defaultMaxMessageSize
+	return receiver
+}
+
+// Validate set default value for receiver and check if valid return err
+func (c *Receiver) Validate() error {
+	if c.Address == "" {
+		return errors.Errorf("Field 'Address' is empty set the listener address eg.: %v", defaultReceiverAddress)
+	}
+
+	if !validateListenAddress(c.Address) {
+		return errors.Errorf("Field 'Address' need to be a valid address with port eg.: %v: %v", defaultReceiverAddress, c.Address)
+	}
+
+	if c.MaxMessageSize != nil && *c.MaxMessageSize <= 0 {
+		return errors.New("Field 'MaxMessageSize' need to be greater than 0")
+	}
+
+	return nil
+}
+
+// Fix fields and using default if is necessary
+func (c *Receiver) Fix() {
+	if c.Address == "" {
+		c.Address = defaultReceiverAddress
+	}
+	if c.LMTP == nil {
+		x := defaultLMTP
+		c.LMTP = &x
+	}
+	if c.MaxMessageSize == nil {
+		x := defaultMaxMessageSize
+		c.MaxMessageSize = &x
+	}
+}
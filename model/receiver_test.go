@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestReceiver_Validate(t *testing.T) {
+	config := &Receiver{}
+	valid := func(n int) {
+		if err := config.Validate(); err == nil {
+			t.Fatal("Test:", n, "this config need to be invalid")
+		}
+	}
+
+	valid(0)
+
+	config.Address = "localhost"
+	valid(1)
+
+	config.Address = "localhost:24"
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	size := 0
+	config.MaxMessageSize = &size
+	valid(2)
+
+	size = 1024
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReceiver_Fix(t *testing.T) {
+	r := Receiver{}
+	r.Fix()
+
+	if r.Address != defaultReceiverAddress {
+		t.Fatal("Expected Address:", defaultReceiverAddress, " result:", r.Address)
+	}
+
+	if *r.LMTP != defaultLMTP {
+		t.Fatal("Expected LMTP:", defaultLMTP, " result:", *r.LMTP)
+	}
+
+	if *r.MaxMessageSize != defaultMaxMessageSize {
+		t.Fatal("Expected MaxMessageSize:", defaultMaxMessageSize, " result:", *r.MaxMessageSize)
+	}
+}
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSanitizeMentions = true
+	defaultSanitizeMarkdown = false
+	defaultSanitizeLinks    = false
+)
+
+// Sanitize type with settings used to neutralize the content of emails before post, Mentions
+// defangs @channel, @all, @here and @user, Markdown escapes the markdown and Links quotes the
+// links to not be clickable. AllowMentions has the senders that can mention, it accepts
+// addresses eg.: boss@example.com or domains eg.: @example.com
+type Sanitize struct {
+	Mentions      *bool    `json:",omitempty"`
+	Markdown      *bool    `json:",omitempty"`
+	Links         *bool    `json:",omitempty"`
+	AllowMentions []string `json:",omitempty"`
+}
+
+// NewSanitize creates new Sanitize with default values
+func NewSanitize() *Sanitize {
+	sanitize := &Sanitize{
+		Mentions: new(bool),
+		Markdown: new(bool),
+		Links:    new(bool),
+	}
+	*sanitize.Mentions = defaultSanitizeMentions
+	*sanitize.Markdown = defaultSanitizeMarkdown
+	*sanitize.Links = defaultSanitizeLinks
+	return sanitize
+}
+
+// Validate check if the sanitize settings are valid
+func (c *Sanitize) Validate() error {
+	for _, sender := range c.AllowMentions {
+		if !strings.Contains(sender, "@") || strings.ContainsAny(sender, " \t<>") {
+			return errors.Errorf("Field 'AllowMentions' need to contain email addresses or domains eg.: @example.com: %v", sender)
+		}
+	}
+	return nil
+}
+
+// Fix fields and using default if is necessary
+func (c *Sanitize) Fix() {
+	if c.Mentions == nil {
+		x := defaultSanitizeMentions
+		c.Mentions = &x
+	}
+	if c.Markdown == nil {
+		x := defaultSanitizeMarkdown
+		c.Markdown = &x
+	}
+	if c.Links == nil {
+		x := defaultSanitizeLinks
+		c.Links = &x
+	}
+
+	for i, sender := range c.AllowMentions {
+		c.AllowMentions[i] = strings.ToLower(strings.TrimSpace(sender))
+	}
+}
+
+// canMention check if the sender address is in AllowMentions
+func (c *Sanitize) canMention(address string) bool {
+	address = strings.ToLower(address)
+	for _, sender := range c.AllowMentions {
+		if address == sender || strings.HasPrefix(sender, "@") && strings.HasSuffix(address, sender) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply neutralizes the fields of the email used in MailTemplate: From, Subject, Message, the
+// names of the addresses and the texts of Calendar
+func (c *Sanitize) Apply(data *MailTemplateData) {
+	mentions := c.Mentions != nil && *c.Mentions && !c.canMention(data.FromAddress.Address)
+	markdown := c.Markdown != nil && *c.Markdown
+	links := c.Links != nil && *c.Links
+
+	if !mentions && !markdown && !links {
+		return
+	}
+
+	text := func(s string) string {
+		return sanitizeText(s, mentions, markdown, links)
+	}
+
+	data.From = text(data.From)
+	data.Subject = text(data.Subject)
+	data.Message = text(data.Message)
+	data.FromAddress.Name = text(data.FromAddress.Name)
+
+	for _, l := range []MailAddresses{data.ReplyTo, data.To, data.Cc} {
+		for i := range l {
+			l[i].Name = text(l[i].Name)
+		}
+	}
+
+	if cal := data.Calendar; cal != nil {
+		cal.Summary = text(cal.Summary)
+		cal.Description = text(cal.Description)
+		cal.Location = text(cal.Location)
+		cal.Organizer.Name = text(cal.Organizer.Name)
+		for i := range cal.Attendees {
+			cal.Attendees[i].Name = text(cal.Attendees[i].Name)
+		}
+	}
+}
+
+var (
+	linkRegex    = regexp.MustCompile("(?i)\\b(?:(?:https?|ftp)://|www\\.)[^\\s<>\"`]+")
+	mentionRegex = regexp.MustCompile(`(^|[^\w@.\-])@([a-zA-Z][\w.\-]*)`)
+)
+
+// sanitizeText neutralizes mentions, markdown and links of s, the links are not escaped to keep
+// them working and the targets of markdown links ex: [text](https://x) are kept
+func sanitizeText(s string, mentions, markdown, links bool) string {
+	var b strings.Builder
+	last := 0
+	for {
+		loc := linkRegex.FindStringIndex(s[last:])
+		if loc == nil {
+			break
+		}
+
+		start := last + loc[0]
+		link := trimLink(s[start : last+loc[1]])
+		b.WriteString(sanitizeSegment(s[last:start], mentions, markdown))
+
+		if links && (markdown || !strings.HasSuffix(s[:start], "](")) {
+			b.WriteString("`" + link + "`")
+		} else {
+			b.WriteString(link)
+		}
+		last = start + len(link)
+	}
+	b.WriteString(sanitizeSegment(s[last:], mentions, markdown))
+	return b.String()
+}
+
+// trimLink removes the end of the link matched that is not part of the URL, the target of
+// markdown link after "](" and the closing parenthesis without opening
+func trimLink(link string) string {
+	if i := strings.Index(link, "]("); i >= 0 {
+		link = link[:i]
+	}
+
+	for strings.HasSuffix(link, ")") && strings.Count(link, "(") < strings.Count(link, ")") {
+		link = link[:len(link)-1]
+	}
+	return link
+}
+
+// sanitizeSegment neutralizes mentions and markdown of a text without links
+func sanitizeSegment(s string, mentions, markdown bool) string {
+	if markdown {
+		s = escapeMarkdown(s)
+	}
+
+	if mentions {
+		// zero width space after @ is not a mention on Mattermost
+		s = mentionRegex.ReplaceAllString(s, "${1}@\u200b${2}")
+	}
+	return s
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestSanitize_Validate(t *testing.T) {
+	sanitize := NewSanitize()
+
+	if err := sanitize.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sanitize.AllowMentions = []string{"boss@example.com", "@example.org"}
+	if err := sanitize.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sanitize.AllowMentions = []string{"example.com"}
+	if err := sanitize.Validate(); err == nil {
+		t.Fatal("Expected error for sender without @")
+	}
+
+	sanitize.AllowMentions = []string{"Boss <boss@example.com>"}
+	if err := sanitize.Validate(); err == nil {
+		t.Fatal("Expected error for sender with name")
+	}
+}
+
+func TestSanitize_Fix(t *testing.T) {
+	sanitize := &Sanitize{AllowMentions: []string{" Boss@Example.com "}}
+	sanitize.Fix()
+
+	if *sanitize.Mentions != defaultSanitizeMentions || *sanitize.Markdown != defaultSanitizeMarkdown || *sanitize.Links != defaultSanitizeLinks {
+		t.Fatalf("Expected default values result:%+v", sanitize)
+	}
+
+	if sanitize.AllowMentions[0] != "boss@example.com" {
+		t.Fatal("Expected boss@example.com result:", sanitize.AllowMentions[0])
+	}
+}
+
+func Test_sanitizeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		mentions bool
+		markdown bool
+		links    bool
+		want     string
+	}{
+		{"nothing", "@all *hi*", false, false, false, "@all *hi*"},
+		{"keywords", "@all @channel, (@here)", true, false, false, "@\u200ball @\u200bchannel, (@\u200bhere)"},
+		{"user", "@john.doe: call @mary_1", true, false, false, "@\u200bjohn.doe: call @\u200bmary_1"},
+		{"email address is not a mention", "john@example.com", true, false, false, "john@example.com"},
+		{"line start", "hi\n@all", true, false, false, "hi\n@\u200ball"},
+		{"markdown", "*bold* [link](http://a.com/x_y) # title", false, true, false, `\*bold\* \[link\](http://a.com/x_y) \# title`},
+		{"links", "see https://example.com/a_b and www.example.org", false, false, true, "see `https://example.com/a_b` and `www.example.org`"},
+		{"markdown link", "see [docs](https://example.com/a_b) now", false, false, true, "see [docs](https://example.com/a_b) now"},
+		{"markdown link with url text", "[https://example.com](https://example.com)", false, false, true, "[`https://example.com`](https://example.com)"},
+		{"link in parenthesis", "(see https://example.com) and https://en.wikipedia.org/wiki/Go_(language)", false, false, true, "(see `https://example.com`) and `https://en.wikipedia.org/wiki/Go_(language)`"},
+		{"markdown link escaped", "[docs](https://example.com)", false, true, true, "\\[docs\\](`https://example.com`)"},
+		{"all", "@here _urgent_ https://a.com/@here", true, true, true, "@\u200bhere \\_urgent\\_ `https://a.com/@here`"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeText(tt.text, tt.mentions, tt.markdown, tt.links); got != tt.want {
+			t.Fatalf("Test %v expected:'%v' result:'%v'", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSanitize_Apply(t *testing.T) {
+	newData := func() *MailTemplateData {
+		return &MailTemplateData{
+			From:        "@all <spam@example.net>",
+			Subject:     "Hi @channel",
+			Message:     "ping @here",
+			FromAddress: MailAddress{Name: "@all", Address: "spam@example.net"},
+			To:          MailAddresses{{Name: "@here", Address: "team@example.com"}},
+		}
+	}
+
+	sanitize := NewSanitize()
+	sanitize.AllowMentions = []string{"@example.com"}
+
+	data := newData()
+	sanitize.Apply(data)
+
+	if data.From != "@\u200ball <spam@example.net>" || data.Subject != "Hi @\u200bchannel" || data.Message != "ping @\u200bhere" {
+		t.Fatalf("Expected mentions neutralized result:%+v", data)
+	}
+
+	if data.FromAddress.Name != "@\u200ball" || data.To[0].Name != "@\u200bhere" {
+		t.Fatalf("Expected names neutralized result:%+v", data)
+	}
+
+	// calendar
+	data = newData()
+	data.Calendar = &MailCalendar{Summary: "Meet @all", Location: "@here", Attendees: []MailAttendee{{MailAddress: MailAddress{Name: "@channel"}}}}
+	sanitize.Apply(data)
+
+	if data.Calendar.Summary != "Meet @\u200ball" || data.Calendar.Location != "@\u200bhere" || data.Calendar.Attendees[0].Name != "@\u200bchannel" {
+		t.Fatalf("Expected calendar neutralized result:%+v", data.Calendar)
+	}
+
+	// allowed sender
+	data = newData()
+	data.FromAddress.Address = "Boss@Example.com"
+	sanitize.Apply(data)
+
+	if data.Subject != "Hi @channel" || data.Message != "ping @here" {
+		t.Fatalf("Expected mentions of allowed sender result:%+v", data)
+	}
+
+	// disabled
+	*sanitize.Mentions = false
+	sanitize.AllowMentions = nil
+	data = newData()
+	sanitize.Apply(data)
+
+	if data.Subject != "Hi @channel" {
+		t.Fatalf("Expected mentions without sanitize result:%+v", data)
+	}
+}
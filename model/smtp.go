@@ -0,0 +1,65 @@
+package model
+
+import (
+	"net/mail"
+
+	"github.com/pkg/errors"
+)
+
+// SMTP type with settings used to send Mattermost thread replies as email
+type SMTP struct {
+	Server            string
+	Username          string `json:",omitempty"`
+	Password          string `json:",omitempty"`
+	From              string
+	StartTLS          *bool `json:",omitempty"`
+	TLSAcceptAllCerts *bool `json:",omitempty"`
+}
+
+// NewSMTP creates new SMTP with default values
+func NewSMTP() *SMTP {
+	smtp := &SMTP{
+		StartTLS:          new(bool),
+		TLSAcceptAllCerts: new(bool),
+	}
+	*smtp.StartTLS = defaultStartTLS
+	*smtp.TLSAcceptAllCerts = defaultTLSAcceptAllCerts
+	return smtp
+}
+
+// Validate set default value for smtp and check if valid return err
+func (c *SMTP) Validate() error {
+	if c.Server == "" {
+		return errors.New("Field 'Server' is empty set smtp server address eg.: smtp.example.com:587")
+	}
+
+	if !validateImap(c.Server) {
+		return errors.Errorf("Field 'Server' need to be a valid url: %v", c.Server)
+	}
+
+	if c.From == "" {
+		return errors.New("Field 'From' is empty, set email address used to send replies eg.: orders@example.com")
+	}
+
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return errors.Errorf("Field 'From' need to be a valid email address: %v", c.From)
+	}
+
+	if c.Username != "" && c.Password == "" {
+		return errors.New("Field 'Password' is empty")
+	}
+
+	return nil
+}
+
+// Fix fields and using default if is necessary
+func (c *SMTP) Fix() {
+	if c.StartTLS == nil {
+		x := defaultStartTLS
+		c.StartTLS = &x
+	}
+	if c.TLSAcceptAllCerts == nil {
+		x := defaultTLSAcceptAllCerts
+		c.TLSAcceptAllCerts = &x
+	}
+}
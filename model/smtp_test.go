@@ -0,0 +1,53 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestSMTP_Validate(t *testing.T) {
+	config := &SMTP{}
+	valid := func(n int) {
+		if err := config.Validate(); err == nil {
+			t.Fatal("Test:", n, "this config need to be invalid")
+		}
+	}
+
+	valid(0)
+
+	config.Server = "Z"
+	valid(1)
+
+	config.Server = "smtp.example.com:587"
+	valid(2)
+
+	config.From = "invalid"
+	valid(3)
+
+	config.From = "Orders <orders@example.com>"
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Username = "orders@example.com"
+	valid(4)
+
+	config.Password = "1234"
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSMTP_Fix(t *testing.T) {
+	s := SMTP{}
+	s.Fix()
+
+	if *s.StartTLS != defaultStartTLS {
+		t.Fatal("Expected StartTLS:", defaultStartTLS, " result:", *s.StartTLS)
+	}
+
+	if *s.TLSAcceptAllCerts != defaultTLSAcceptAllCerts {
+		t.Fatal("Expected TLSAcceptAllCerts:", defaultTLSAcceptAllCerts, " result:", *s.TLSAcceptAllCerts)
+	}
+}
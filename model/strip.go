@@ -0,0 +1,198 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultStripQuotes    = false
+	defaultStripSignature = false
+)
+
+// Strip type with settings used to remove the content of emails that is not new from the
+// preview, Quotes removes the quoted reply and its header ex: "On Tue, John wrote:", Signature
+// removes the text after the signature delimiter "-- " and Disclaimers removes the text from
+// the first line that matches a pattern, the pattern is a substring case insensitive or a
+// regular expression between slashes
+type Strip struct {
+	Quotes      *bool    `json:",omitempty"`
+	Signature   *bool    `json:",omitempty"`
+	Disclaimers []string `json:",omitempty"`
+
+	// regular expressions of Disclaimers compiled in Validate
+	regexps map[string]*regexp.Regexp
+}
+
+// NewStrip creates new Strip with default values
+func NewStrip() *Strip {
+	strip := &Strip{
+		Quotes:    new(bool),
+		Signature: new(bool),
+	}
+	*strip.Quotes = defaultStripQuotes
+	*strip.Signature = defaultStripSignature
+	return strip
+}
+
+// Validate check if the strip settings are valid
+func (c *Strip) Validate() error {
+	regexps := make(map[string]*regexp.Regexp)
+	for _, pattern := range c.Disclaimers {
+		if strings.TrimSpace(pattern) == "" {
+			return errors.New("Field 'Disclaimers' contains an empty pattern")
+		}
+
+		if !isRegexPattern(pattern) {
+			continue
+		}
+
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "Field 'Disclaimers' has an invalid regular expression %v", pattern)
+		}
+		regexps[pattern] = re
+	}
+	c.regexps = regexps
+	return nil
+}
+
+// Fix fields and using default if is necessary
+func (c *Strip) Fix() {
+	if c.Quotes == nil {
+		x := defaultStripQuotes
+		c.Quotes = &x
+	}
+	if c.Signature == nil {
+		x := defaultStripSignature
+		c.Signature = &x
+	}
+
+	for i, pattern := range c.Disclaimers {
+		c.Disclaimers[i] = fixPattern(pattern)
+	}
+}
+
+var (
+	// quote headers of Gmail, Thunderbird and Apple Mail in some languages ex:
+	// "On Tue, Jun 5, 2017 at 2:08 PM, John <john@example.com> wrote:"
+	quoteHeaderRegex = regexp.MustCompile(`(?i)^(On|Em|Le|El|Am|Op|Il) .{4,}(wrote|escreveu|a écrit|escribió|schrieb|schreef|ha scritto)\s?:$`)
+
+	// separator of Outlook ex: "-----Original Message-----"
+	originalMessageRegex = regexp.MustCompile(`(?i)^-{2,}\s*(Original Message|Forwarded message|Mensagem original|Message d'origine|Mensaje original|Ursprüngliche Nachricht)\s*-{2,}$`)
+
+	// header of quoted email of Outlook ex: "From: John" followed by "Sent: Tuesday"
+	outlookFromRegex = regexp.MustCompile(`(?i)^\**(From|De|Von|Van|Da)\s?:\**\s`)
+	outlookSentRegex = regexp.MustCompile(`(?i)^\**(Sent|Date|Enviado|Envoyé|Enviada|Gesendet|Verzonden|Inviato)\s?:\**\s`)
+
+	// signatures added by mobile clients
+	mobileSignatureRegex = regexp.MustCompile(`(?i)^(Sent from my |Get Outlook for |Enviado do meu |Enviado desde mi )`)
+)
+
+// Apply returns the new content of the email text removing quotes, signature and disclaimers,
+// the text is returned without change when nothing would remain
+func (c *Strip) Apply(text string) string {
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+	}
+
+	quotes := c.Quotes != nil && *c.Quotes
+	signature := c.Signature != nil && *c.Signature
+
+	lines := strings.Split(text, newline)
+	var content []string
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+
+		if quotes && (isQuoteHeader(lines, i) || originalMessageRegex.MatchString(trimmed)) {
+			break
+		}
+
+		if quotes && strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+
+		if signature && (strings.TrimRight(l, " \t") == "--" || mobileSignatureRegex.MatchString(trimmed)) {
+			break
+		}
+
+		if c.matchDisclaimer(trimmed) {
+			break
+		}
+
+		content = append(content, l)
+	}
+
+	s := strings.TrimRight(strings.Join(content, newline), " \t\r\n")
+	if strings.TrimSpace(s) == "" {
+		return text
+	}
+	if s == strings.TrimRight(text, " \t\r\n") {
+		return text
+	}
+	return s
+}
+
+// isQuoteHeader returns true if the line i starts the header of a quoted email, the header
+// can be wrapped in more lines
+func isQuoteHeader(lines []string, i int) bool {
+	header := strings.TrimSpace(lines[i])
+	if header == "" {
+		return false
+	}
+
+	// Outlook header or underscores separator of Outlook Web followed by the header
+	from := i
+	if strings.Trim(header, "_") == "" && len(header) >= 10 {
+		from++
+	}
+	if from < len(lines) && outlookFromRegex.MatchString(strings.TrimSpace(lines[from])) {
+		for j := from + 1; j < len(lines) && j <= from+3; j++ {
+			if outlookSentRegex.MatchString(strings.TrimSpace(lines[j])) {
+				return true
+			}
+		}
+	}
+
+	for j := i; j < len(lines) && j <= i+2; j++ {
+		if j > i {
+			header += " " + strings.TrimSpace(lines[j])
+		}
+		if quoteHeaderRegex.MatchString(header) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDisclaimer returns true if the line matches a pattern of Disclaimers
+func (c *Strip) matchDisclaimer(line string) bool {
+	if line == "" {
+		return false
+	}
+
+	for _, pattern := range c.Disclaimers {
+		if !isRegexPattern(pattern) {
+			if strings.Contains(strings.ToLower(line), strings.ToLower(pattern)) {
+				return true
+			}
+			continue
+		}
+
+		re := c.regexps[pattern]
+		if re == nil {
+			// strip was not validated
+			var err error
+			if re, err = compilePattern(pattern); err != nil {
+				continue
+			}
+		}
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
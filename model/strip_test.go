@@ -0,0 +1,85 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestStrip_Validate(t *testing.T) {
+	strip := NewStrip()
+
+	if err := strip.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	strip.Disclaimers = []string{"confidential", "/^This e-?mail/"}
+	if err := strip.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strip.regexps["/^This e-?mail/"] == nil {
+		t.Fatal("Expected regular expression compiled")
+	}
+
+	strip.Disclaimers = []string{"/(/"}
+	if err := strip.Validate(); err == nil {
+		t.Fatal("Expected error for invalid regular expression")
+	}
+
+	strip.Disclaimers = []string{" "}
+	if err := strip.Validate(); err == nil {
+		t.Fatal("Expected error for empty pattern")
+	}
+}
+
+func TestStrip_Fix(t *testing.T) {
+	strip := &Strip{Disclaimers: []string{" Confidential ", "/^This/"}}
+	strip.Fix()
+
+	if *strip.Quotes != defaultStripQuotes || *strip.Signature != defaultStripSignature {
+		t.Fatalf("Expected default values result:%+v", strip)
+	}
+
+	if strip.Disclaimers[0] != "confidential" || strip.Disclaimers[1] != "/^This/" {
+		t.Fatal("Expected fixed patterns result:", strip.Disclaimers)
+	}
+}
+
+func TestStrip_Apply(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		quotes    bool
+		signature bool
+		want      string
+	}{
+		{"nothing to strip", "Hello\n\nThanks\n", true, true, "Hello\n\nThanks\n"},
+		{"gmail", "Fine\n\nOn Tue, Jun 5, 2017 at 2:08 PM, John <john@example.com> wrote:\n> How are you?\n", true, true, "Fine"},
+		{"gmail wrapped header", "Fine\n\nOn Tue, Jun 5, 2017 at 2:08 PM, John Doe <\njohn@example.com> wrote:\n\n> How are you?", true, true, "Fine"},
+		{"gmail portuguese", "Ok\n\nEm ter., 5 de jun. de 2017 às 14:08, Rodrigo <r@example.com> escreveu:\n> Teste", true, true, "Ok"},
+		{"thunderbird", "Fine\r\n\r\nOn 05/06/2017 14:08, John wrote:\r\n> How are you?\r\n", true, true, "Fine"},
+		{"outlook", "Done\n\n-----Original Message-----\nFrom: John\nSent: Tuesday\n", true, true, "Done"},
+		{"outlook header", "Done\n\nFrom: John Doe <john@example.com>\nSent: Tuesday, June 5, 2017 2:08 PM\nTo: Mary\nSubject: Task\n\nCan you?", true, true, "Done"},
+		{"outlook web", "Done\n\n________________________________\nFrom: John Doe\nSent: Tuesday\n", true, true, "Done"},
+		{"inline quotes", "> question 1\nanswer 1\n> question 2\nanswer 2", true, true, "answer 1\nanswer 2"},
+		{"quotes disabled", "Fine\n> quote", false, true, "Fine\n> quote"},
+		{"signature", "Hello\n-- \nJohn Doe\nACME", true, true, "Hello"},
+		{"mobile signature", "Yes\n\nSent from my iPhone", true, true, "Yes"},
+		{"signature disabled", "Hello\n-- \nJohn Doe", true, false, "Hello\n-- \nJohn Doe"},
+		{"only quotes", "> quote\n> text", true, true, "> quote\n> text"},
+		{"from in text", "From: the team\nwe are happy", true, true, "From: the team\nwe are happy"},
+		{"disclaimer", "Report attached\n\nCONFIDENTIAL: this message is private", true, true, "Report attached"},
+		{"disclaimer regex", "Report attached\nThis email and any files are private", true, true, "Report attached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strip := &Strip{Quotes: &tt.quotes, Signature: &tt.signature, Disclaimers: []string{"confidential:", "/^This e-?mail/"}}
+			if err := strip.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strip.Apply(tt.text); got != tt.want {
+				t.Errorf("Strip.Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
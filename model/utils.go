@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -11,11 +12,21 @@ func validateURL(url string) bool {
 	return Re.MatchString(url)
 }
 
+func validateWebhookURL(url string) bool {
+	Re := regexp.MustCompile(`^https?://[A-Za-z0-9\.\-_]+(:[0-9]{1,5})?(/[A-Za-z0-9\.\-_~%]*)*$`)
+	return Re.MatchString(url)
+}
+
 func validateImap(url string) bool {
 	Re := regexp.MustCompile(`^[a-z0-9\.\-_]+:?([0-9]{1,5})?$`)
 	return Re.MatchString(url)
 }
 
+func validateListenAddress(address string) bool {
+	Re := regexp.MustCompile(`^([a-z0-9\.\-_]+|\[[0-9a-f:]+\])?:[0-9]{1,5}$`)
+	return Re.MatchString(address)
+}
+
 func validateChannel(channel string) bool {
 	Re := regexp.MustCompile(`^(#|@)[a-z0-9\.\-_]+$`)
 	return Re.MatchString(channel)
@@ -38,3 +49,19 @@ func findDir(dir string) string {
 
 	return fileName + "/"
 }
+
+// HumanSize formats size in bytes ex: HumanSize(2048) => 2.0 KB
+func HumanSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := unit, 0
+	for n := size / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
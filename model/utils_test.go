@@ -16,6 +16,19 @@ func Test_validateURL(t *testing.T) {
 	assert("https://mattermost.example.com", true)
 }
 
+func Test_validateWebhookURL(t *testing.T) {
+	assert := func(test string, expected bool) {
+		if validateWebhookURL(test) != expected {
+			t.Fatalf("test %v expected %v", test, expected)
+		}
+	}
+	assert("", false)
+	assert("mattermost.example.com/hooks/xxx", false)
+	assert("https://mattermost.example.com/hooks/ib9fxi3ioj8xfxt8jbi8grkrby", true)
+	assert("http://localhost:8065/mattermost/hooks/xxx", true)
+	assert("https://mattermost.example.com/hooks/xxx?a=b", false)
+}
+
 func Test_validateImap(t *testing.T) {
 	assert := func(test string, expected bool) {
 		if validateImap(test) != expected {
@@ -27,6 +40,21 @@ func Test_validateImap(t *testing.T) {
 	assert("imap.com", true)
 }
 
+func Test_validateListenAddress(t *testing.T) {
+	assert := func(test string, expected bool) {
+		if validateListenAddress(test) != expected {
+			t.Fatalf("test %v expected %v", test, expected)
+		}
+	}
+	assert("", false)
+	assert("localhost", false)
+	assert("localhost:2525", true)
+	assert(":24", true)
+	assert("127.0.0.1:2525", true)
+	assert("[::1]:2525", true)
+	assert("http://localhost:2525", false)
+}
+
 func Test_validateChannel(t *testing.T) {
 	assert := func(test string, expected bool) {
 		if validateChannel(test) != expected {
@@ -53,3 +81,23 @@ func Test_validateTeam(t *testing.T) {
 	assert("D D", false)
 	assert("team", true)
 }
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{5 * 1024 * 1024, "5.0 MB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := HumanSize(tt.size); got != tt.want {
+			t.Errorf("HumanSize(%v) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}